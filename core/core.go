@@ -11,6 +11,7 @@ import (
 
 	cuejson "cuelang.org/go/encoding/json"
 	"github.com/avast/retry-go"
+	"github.com/dosco/graphjin/core/internal/allow"
 	"github.com/dosco/graphjin/core/internal/psql"
 	"github.com/dosco/graphjin/core/internal/qcode"
 	"github.com/dosco/graphjin/core/internal/sdata"
@@ -563,7 +564,15 @@ func (gj *graphjin) saveToAllowList(qc *qcode.QCode, query, namespace string) er
 		}
 	}
 
-	return gj.allowList.Set(av, query, qc.Metadata, namespace)
+	err = gj.allowList.Set(av, query, qc.Metadata, namespace, allow.WithOverwrite())
+
+	// a full save queue only means this query is not recorded
+	// so it must not fail the request
+	if errors.Is(err, allow.ErrQueueFull) {
+		gj.log.Printf("WRN allow list: %s", err)
+		return nil
+	}
+	return err
 }
 
 func (gj *graphjin) spanStart(c context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
@@ -2,21 +2,31 @@ package allow
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash"
+	"io"
 	"io/fs"
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 	"text/scanner"
+	"time"
 
 	"gopkg.in/yaml.v3"
 
 	"github.com/chirino/graphql/schema"
 	"github.com/dosco/graphjin/core/internal/graph"
 	"github.com/dosco/graphjin/internal/jsn"
+	lru "github.com/hashicorp/golang-lru"
 	"github.com/spf13/afero"
 )
 
@@ -32,407 +42,2839 @@ const (
 	fragmentPath = "/fragments"
 )
 
+var (
+	ErrReadOnly           = errors.New("allow list is read-only")
+	ErrEmptyQuery         = errors.New("empty query")
+	ErrNoQueryName        = errors.New("no query name defined. only named queries are saved to the allow list")
+	ErrUnknownFileType    = errors.New("unknown filetype")
+	ErrNotFound           = errors.New("query not found")
+	ErrQueueFull          = errors.New("allow list save queue is full")
+	ErrInvalidVars        = errors.New("invalid variables json")
+	ErrFragmentCycle      = errors.New("fragments used in a cycle")
+	ErrNameCollision      = errors.New("a different query with the same name is already saved")
+	ErrVarNotAllowed      = errors.New("variable not allowed")
+	ErrFragmentConflict   = errors.New("a different fragment with the same name is already saved")
+	ErrUnknownVarsRef     = errors.New("shared variables not found")
+	ErrInvalidNamespace   = errors.New("invalid namespace")
+	ErrInvalidName        = errors.New("invalid query name")
+	ErrInvalidFragment    = errors.New("invalid fragment")
+	ErrLint               = errors.New("query does not pass the lint rules")
+	ErrEmptyFragment      = errors.New("empty fragment")
+	ErrUnknownVariable    = errors.New("variable not declared by the query")
+	ErrDuplicateOperation = errors.New("operation with the same name saved more than once")
+	ErrUnsetEnvVar        = errors.New("environment variable not set")
+	ErrInvalidHash        = errors.New("invalid query hash")
+	ErrAnonymousOperation = errors.New("anonymous operation")
+	ErrSourceFile         = errors.New("query is defined in a .gql source file")
+)
+
+// Formats the allow list items can be saved in
+const (
+	FormatYAML = "yaml"
+	FormatJSON = "json"
+)
+
+// Policies for operations with the same name read by Load
+const (
+	DuplicateError     = "error"
+	DuplicateFirstWins = "first-wins"
+	DuplicateLastWins  = "last-wins"
+)
+
+// Formats the queries are saved in
+const (
+	QueryFormatPretty  = "pretty"
+	QueryFormatCompact = "compact"
+)
+
 type Item struct {
-	Namespace string `yaml:",omitempty"`
-	Name      string
-	Comment   string `yaml:",omitempty"`
+	Namespace string `yaml:",omitempty" json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	Comment   string `yaml:",omitempty" json:"comment,omitempty"`
 	key       string
-	Query     string
-	Vars      string   `yaml:",omitempty"`
-	Metadata  Metadata `yaml:",inline,omitempty"`
-	frags     []Frag
+	Query     string `json:"query"`
+	OpType    string `yaml:"op_type,omitempty" json:"op_type,omitempty"`
+	Vars      string `yaml:",omitempty" json:"vars,omitempty"`
+
+	// VarsRef names the shared variables in the defaults file (DefaultsFile)
+	// used as the variables when Vars is not set
+	VarsRef string `yaml:"vars_ref,omitempty" json:"vars_ref,omitempty"`
+
+	Metadata Metadata `yaml:",inline,omitempty" json:"metadata"`
+	frags    []Frag
+
+	// source is the file the item was read from
+	source string
+
+	// header is the parsed query header, see Header
+	header *headerCache
+}
+
+// Operation types of the saved queries
+const (
+	OpQuery        = "query"
+	OpMutation     = "mutation"
+	OpSubscription = "subscription"
+)
+
+// opType returns the operation type for the parser type of a query
+func opType(t graph.ParserType) string {
+	switch t {
+	case graph.OpMutate:
+		return OpMutation
+	case graph.OpSub:
+		return OpSubscription
+	default:
+		return OpQuery
+	}
 }
 
 type Metadata struct {
 	Order struct {
-		Var    string   `yaml:"var,omitempty"`
-		Values []string `yaml:"values,omitempty"`
-	} `yaml:",omitempty"`
-}
+		Var    string   `yaml:"var,omitempty" json:"var,omitempty"`
+		Values []string `yaml:"values,omitempty" json:"values,omitempty"`
+	} `yaml:",omitempty" json:"order"`
 
-type Frag struct {
-	Name  string
-	Value string
-}
+	// Hash of the normalized query and variables and the name of the
+	// algorithm used (see Config.Hasher), set when saved
+	Hash          string `yaml:"hash,omitempty" json:"hash,omitempty"`
+	HashAlgorithm string `yaml:"hash_algorithm,omitempty" json:"hash_algorithm,omitempty"`
 
-type List struct {
-	saveChan chan Item
-	fs       afero.Fs
-}
+	Deprecated        bool      `yaml:"deprecated,omitempty" json:"deprecated,omitempty"`
+	DeprecationReason string    `yaml:"deprecation_reason,omitempty" json:"deprecation_reason,omitempty"`
+	Sunset            time.Time `yaml:"sunset,omitempty" json:"sunset,omitempty"`
 
-type Config struct {
-	Log *log.Logger
-}
+	// Variables the query may be called with, any allowed when empty
+	AllowedVars []string `yaml:"allowed_vars,omitempty" json:"allowed_vars,omitempty"`
 
-func NewReadOnly(fs afero.Fs) (*List, error) {
-	return &List{fs: fs}, nil
-}
+	// Tags to group the queries by, see LoadByTag
+	Tags []string `yaml:"tags,omitempty" json:"tags,omitempty"`
 
-func New(conf Config, fs afero.Fs) (*List, error) {
-	if fs == nil {
-		return nil, fmt.Errorf("no filesystem defined for the allow list")
-	}
+	// Depth of the most nested field and the number of fields selected
+	// including those in fragments, set when saved
+	Depth      int `yaml:"depth,omitempty" json:"depth,omitempty"`
+	FieldCount int `yaml:"field_count,omitempty" json:"field_count,omitempty"`
 
-	al := List{saveChan: make(chan Item), fs: fs}
+	// APQHash is the hex encoded sha256 hash of the normalized query used
+	// to look it up with GetByHash, set when saved
+	APQHash string `yaml:"apq_hash,omitempty" json:"apq_hash,omitempty"`
 
-	_ = fs.MkdirAll(queryPath, os.ModePerm)
-	_ = fs.MkdirAll(fragmentPath, os.ModePerm)
+	// Subscription settings, only used by subscriptions
+	Subscription *SubscriptionMetadata `yaml:"subscription,omitempty" json:"subscription,omitempty"`
 
-	var err error
+	// CreatedAt is when the query was first saved and UpdatedAt when it was
+	// last changed, set when saved using the allow list clock (Config.Now)
+	CreatedAt time.Time `yaml:"created_at,omitempty" json:"created_at,omitempty"`
+	UpdatedAt time.Time `yaml:"updated_at,omitempty" json:"updated_at,omitempty"`
 
-	go func() {
-		for {
-			v, ok := <-al.saveChan
-			if !ok {
-				break
-			}
-			err = al.save(v)
-			if err != nil && conf.Log != nil {
-				conf.Log.Println("WRN allow list save:", err)
-			}
-		}
-	}()
+	// RateLimit of the operation enforced by the gateway, no limit when nil
+	RateLimit *RateLimit `yaml:"rate_limit,omitempty" json:"rate_limit,omitempty"`
+}
 
-	return &al, err
+// SubscriptionMetadata are the settings of a subscription read by the
+// router. Zero values use the router defaults.
+type SubscriptionMetadata struct {
+	HeartbeatSeconds   int `yaml:"heartbeat_seconds,omitempty" json:"heartbeat_seconds,omitempty"`
+	MaxLifetimeSeconds int `yaml:"max_lifetime_seconds,omitempty" json:"max_lifetime_seconds,omitempty"`
 }
 
-func (al *List) Set(vars []byte, query string, md Metadata, namespace string) error {
-	if al.saveChan == nil {
-		return errors.New("allow list is read-only")
+// RateLimit is the rate limit of an operation read by the gateway when
+// admitting requests. A zero Burst uses the gateway default.
+type RateLimit struct {
+	RequestsPerSecond int `yaml:"requests_per_second,omitempty" json:"requests_per_second,omitempty"`
+	Burst             int `yaml:"burst,omitempty" json:"burst,omitempty"`
+}
+
+// RateLimit returns the rate limit of the operation and false if it has
+// no limit, when not set or RequestsPerSecond is not positive.
+func (i Item) RateLimit() (RateLimit, bool) {
+	if rl := i.Metadata.RateLimit; rl != nil && rl.RequestsPerSecond > 0 {
+		return *rl, true
 	}
+	return RateLimit{}, false
+}
 
-	if query == "" {
-		return errors.New("empty query")
+// Heartbeat returns the heartbeat interval of the subscription or def
+// if it is not set.
+func (i Item) Heartbeat(def time.Duration) time.Duration {
+	if sm := i.Metadata.Subscription; sm != nil && sm.HeartbeatSeconds > 0 {
+		return time.Duration(sm.HeartbeatSeconds) * time.Second
 	}
+	return def
+}
 
-	item, err := parseQuery(query)
-	if err != nil {
-		return err
+// MaxLifetime returns the max time the subscription is kept open or def
+// if it is not set.
+func (i Item) MaxLifetime(def time.Duration) time.Duration {
+	if sm := i.Metadata.Subscription; sm != nil && sm.MaxLifetimeSeconds > 0 {
+		return time.Duration(sm.MaxLifetimeSeconds) * time.Second
 	}
+	return def
+}
 
-	item.Namespace = namespace
-	item.Vars = string(vars)
-	item.Metadata = md
-	al.saveChan <- item
-	return nil
+// Source returns the path of the file the item was read from or an empty
+// string if it was not read from a file.
+func (i Item) Source() string {
+	return i.source
 }
 
-func (al *List) Load() ([]Item, error) {
-	var items []Item
-	var files []fs.FileInfo
-	var err error
+// PastSunset reports whether the query has a sunset date before t.
+func (md Metadata) PastSunset(t time.Time) bool {
+	return !md.Sunset.IsZero() && md.Sunset.Before(t)
+}
 
-	if ok, err := afero.DirExists(al.fs, queryPath); !ok {
-		return items, nil
-	} else if err != nil {
-		return nil, fmt.Errorf("allow list: %w", err)
+// savedTime returns the current time of the allow list clock (Config.Now)
+// in UTC to the second as saved in the item metadata.
+func (al *List) savedTime() time.Time {
+	return al.conf.Now().UTC().Truncate(time.Second)
+}
+
+// PastSunset reports whether the item has a sunset date before the
+// current time of the allow list clock (Config.Now).
+func (al *List) PastSunset(item Item) bool {
+	return item.Metadata.PastSunset(al.conf.Now())
+}
+
+// CheckVars returns ErrVarNotAllowed if the variables json has a variable
+// that is not in the AllowedVars of the item. All variables are allowed
+// when AllowedVars is empty.
+func (i Item) CheckVars(vars []byte) error {
+	if len(i.Metadata.AllowedVars) == 0 || len(bytes.TrimSpace(vars)) == 0 {
+		return nil
 	}
 
-	files, err = afero.ReadDir(al.fs, queryPath)
-	if err != nil {
-		return nil, fmt.Errorf("allow list: %w", err)
+	var vm map[string]json.RawMessage
+	if err := json.Unmarshal(vars, &vm); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidVars, err)
 	}
 
-	for _, f := range files {
-		if f.IsDir() {
-			continue
+	for k := range vm {
+		if !hasString(i.Metadata.AllowedVars, k) {
+			return fmt.Errorf("%w: %s", ErrVarNotAllowed, k)
 		}
+	}
+	return nil
+}
 
-		item, err := al.Get(filepath.Join(queryPath, f.Name()))
-		if err == errUnknownFileType {
-			continue
-		}
-		if err != nil {
-			return nil, err
+func hasString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
 		}
-		items = append(items, item)
 	}
-	return items, nil
+	return false
 }
 
-func (al *List) GetByName(filePath string) (Item, error) {
-	var item Item
-	fpath := filepath.Join(queryPath, filePath)
+type Frag struct {
+	Name  string
+	Value string
+}
 
-	fn := (fpath + ".gql")
-	if ok, err := afero.Exists(al.fs, fn); ok {
-		return al.Get(fn)
-	} else if err != nil {
-		return item, err
-	}
+type List struct {
+	saveChan chan saveReq
+	store    Store
+	conf     Config
+
+	mu      sync.Mutex
+	pending int
+	drained *sync.Cond
+
+	// closeMu is held for reading while queueing so Close does not close
+	// saveChan during a send
+	closeMu sync.RWMutex
+	closed  bool
+	workers sync.WaitGroup
+
+	indexMu sync.RWMutex
+	index   map[string]Item
+	hashes  map[string]string
+
+	// cacheGen is bumped on each invalidation so a GetByName that read
+	// the file before a save does not add the stale item after it
+	cacheMu  sync.Mutex
+	cache    *lru.Cache
+	cacheGen uint64
+
+	libMu   sync.Mutex
+	library map[string]string
+
+	// files are the locks of the files being read or written
+	// shared with the read-only views
+	files *sync.Map
+}
 
-	fn = (fpath + ".graphql")
-	if ok, err := afero.Exists(al.fs, fn); ok {
-		return al.Get(fn)
-	} else if err != nil {
-		return item, err
+const (
+	opSave = iota
+	opRemove
+	opSaveMany
+	opSaveFragment
+	opRename
+)
+
+type saveReq struct {
+	op        int
+	item      Item
+	items     []Item
+	target    Item
+	gcFrags   bool
+	overwrite bool
+	update    bool
+	merge     bool
+	reply     chan error
+}
+
+type Config struct {
+	Log *log.Logger
+
+	// Format the items are saved in, either FormatYAML (default) or FormatJSON
+	Format string
+
+	// YAMLIndent is the number of spaces the yaml files are indented with
+	// (default: 2)
+	YAMLIndent int
+
+	// YAMLSortKeys saves the keys of the yaml files sorted by name instead
+	// of in the order of the Item fields
+	YAMLSortKeys bool
+
+	// Compress saves the items gzip compressed in .yaml.gz or .json.gz files
+	Compress bool
+
+	// QueryFormat the queries are saved in, either QueryFormatPretty
+	// (default, indented) or QueryFormatCompact (minified)
+	QueryFormat string
+
+	// QueryDir is the directory queries are saved in (default: /queries)
+	QueryDir string
+
+	// FragmentDir is the directory fragments are saved in (default: /fragments)
+	FragmentDir string
+
+	// FragmentNamer returns the path of the fragment file relative to
+	// FragmentDir, eg. "<namespace>/<name>.gql" for a nested layout. It is
+	// used both to save and to read fragments and the path must stay within
+	// FragmentDir (default: "<namespace>.<name>.gql"). Stats, Watch and
+	// MigrateFragments only see fragments saved in the default layout.
+	FragmentNamer func(namespace, name string) string
+
+	// ScopedFragments saves the fragments in a directory per namespace,
+	// "<namespace>/<name>.gql" with the fragments without a namespace in
+	// "_global/<name>.gql". The fragments used by a query are looked up in
+	// its namespace and then in _global. It cannot be used with a
+	// FragmentNamer. Migrate moves the fragments saved in the default layout.
+	ScopedFragments bool
+
+	// SaveWorkers is the number of goroutines saving queued items (default: 1)
+	SaveWorkers int
+
+	// SaveQueueSize is the number of items that can be queued to be saved
+	// before Set returns ErrQueueFull (default: 100)
+	SaveQueueSize int
+
+	// EnableIndex keeps the items read by Load in memory and uses them
+	// to serve GetByName. The index is updated by Set, Remove, Watch
+	// and Reload.
+	EnableIndex bool
+
+	// OnSave is called by the save workers after each queued item is saved
+	// with the item (its Namespace and Name identify the query) and the save
+	// error if any. It must return quickly as it blocks the worker.
+	OnSave func(item Item, err error)
+
+	// OnLoad is called after Load reads all the items with the number of
+	// items read and the time taken.
+	OnLoad func(count int, dur time.Duration)
+
+	// CacheSize is the max number of items read by GetByName kept in memory,
+	// the least recently used are dropped first (default: 0, no cache)
+	CacheSize int
+
+	// OnCache is called by GetByName on each lookup in the cache with the
+	// name and whether it was found.
+	OnCache func(name string, hit bool)
+
+	// OnDuplicate is what Load does when operations with the same name
+	// are read from different files or from the same bundle: return
+	// ErrDuplicateOperation, keep the first read or keep the last read
+	// logging the one dropped (default: DuplicateError)
+	OnDuplicate string
+
+	// SkipInvalid logs and skips the query files that cannot be read or
+	// parsed so Load, Range and LoadMeta return the other items. By default
+	// the first file that fails stops them with its error.
+	SkipInvalid bool
+
+	// InterpolateVars replaces the ${NAME} and ${NAME:-default} references
+	// in the variables of the items read with the value of the environment
+	// variable or the default if it's not set. Reading an item referencing
+	// a variable that is not set and has no default fails with
+	// ErrUnsetEnvVar. The variables are saved without the values replaced.
+	InterpolateVars bool
+
+	// Hasher returns the hash used for the content hash of the saved items
+	// (Metadata.Hash) and HashAlgorithm is its name saved with the hash, it
+	// must be set along with the hasher (default: sha256). The APQ hashes
+	// used by GetByHash are always sha256.
+	Hasher        func() hash.Hash
+	HashAlgorithm string
+
+	// Linters are run in order on each query before it is saved and the
+	// first error stops the save. See LintPascalCase and LintUnboundedLists.
+	Linters []func(Item) error
+
+	// Now returns the current time used for the time based metadata of
+	// the items (default: time.Now)
+	Now func() time.Time
+}
+
+func (conf *Config) init() error {
+	switch conf.Format {
+	case "":
+		conf.Format = FormatYAML
+	case FormatYAML, FormatJSON:
+	default:
+		return fmt.Errorf("invalid allow list format: %s", conf.Format)
 	}
 
-	fn = (fpath + ".yml")
-	if ok, err := afero.Exists(al.fs, fn); ok {
-		return al.Get(fn)
-	} else if err != nil {
-		return item, err
+	switch conf.QueryFormat {
+	case "":
+		conf.QueryFormat = QueryFormatPretty
+	case QueryFormatPretty, QueryFormatCompact:
+	default:
+		return fmt.Errorf("invalid allow list query format: %s", conf.QueryFormat)
 	}
 
-	fn = (fpath + ".yaml")
-	if ok, err := afero.Exists(al.fs, fn); ok {
-		return al.Get(fn)
-	} else if err != nil {
-		return item, err
+	switch conf.OnDuplicate {
+	case "":
+		conf.OnDuplicate = DuplicateError
+	case DuplicateError, DuplicateFirstWins, DuplicateLastWins:
+	default:
+		return fmt.Errorf("invalid allow list duplicate policy: %s", conf.OnDuplicate)
 	}
 
-	return item, nil
-}
+	if conf.QueryDir == "" {
+		conf.QueryDir = queryPath
+	}
 
-var errUnknownFileType = errors.New("unknown filetype")
+	if conf.Now == nil {
+		conf.Now = time.Now
+	}
 
-func (al *List) Get(filePath string) (Item, error) {
-	var item Item
+	if conf.FragmentDir == "" {
+		conf.FragmentDir = fragmentPath
+	}
 
-	switch filepath.Ext(filePath) {
-	case ".gql", ".graphql":
-		return itemFromGQL(al.fs, filePath)
-	case ".yml", ".yaml":
-		return itemFromYaml(al.fs, filePath)
-	default:
-		return item, errUnknownFileType
+	if conf.ScopedFragments {
+		if conf.FragmentNamer != nil {
+			return errors.New("allow list scoped fragments cannot be used with a fragment namer")
+		}
+		conf.FragmentNamer = scopedFragmentName
 	}
-}
 
-func itemFromYaml(fs afero.Fs, filePath string) (Item, error) {
-	var item Item
+	switch {
+	case conf.Hasher == nil && conf.HashAlgorithm == "":
+		conf.Hasher = sha256.New
+		conf.HashAlgorithm = defaultHashAlgorithm
+	case conf.Hasher == nil || conf.HashAlgorithm == "":
+		return errors.New("allow list hasher and hash algorithm must be set together")
+	}
 
-	b, err := afero.ReadFile(fs, filePath)
-	if err != nil {
-		return item, err
+	switch {
+	case conf.YAMLIndent == 0:
+		conf.YAMLIndent = 2
+	case conf.YAMLIndent < 0:
+		return fmt.Errorf("invalid allow list yaml indent: %d", conf.YAMLIndent)
 	}
 
-	if err := yaml.Unmarshal(b, &item); err != nil {
-		return item, err
+	if conf.SaveWorkers <= 0 {
+		conf.SaveWorkers = 1
 	}
-	return item, nil
-}
 
-func itemFromGQL(fs afero.Fs, filePath string) (Item, error) {
-	var item Item
+	if conf.SaveQueueSize <= 0 {
+		conf.SaveQueueSize = 100
+	}
 
-	fn := filepath.Base(filePath)
-	fn = strings.TrimSuffix(fn, filepath.Ext(fn))
-	queryNS, queryName := splitName(fn)
+	conf.QueryDir = filepath.Clean(conf.QueryDir)
+	conf.FragmentDir = filepath.Clean(conf.FragmentDir)
 
-	if queryName == "" {
-		return item, fmt.Errorf("invalid filename: %s", filePath)
+	if conf.QueryDir == conf.FragmentDir {
+		return fmt.Errorf("allow list query and fragment directories must differ: %s",
+			conf.QueryDir)
 	}
+	return nil
+}
 
-	query, err := parseGQLFile(fs, filePath)
-	if err != nil {
-		return item, err
+func NewReadOnly(conf Config, fs afero.Fs) (*List, error) {
+	if err := conf.init(); err != nil {
+		return nil, err
 	}
 
-	// h, err := graph.FastParse(query)
-	// if err != nil {
-	// 	return item, err
-	// }
+	if fs == nil {
+		return nil, fmt.Errorf("no filesystem defined for the allow list")
+	}
 
-	item.Namespace = queryNS
-	item.Name = queryName
-	item.Query = query
-	item.key = strings.ToLower(item.Name)
+	al := &List{store: newFsStore(fs, conf), conf: conf, files: &sync.Map{}}
+	if err := al.initCache(); err != nil {
+		return nil, err
+	}
+	return al, nil
+}
 
-	return item, nil
+func (al *List) initCache() (err error) {
+	if al.conf.CacheSize > 0 {
+		al.cache, err = lru.New(al.conf.CacheSize)
+	}
+	return err
 }
 
-func parseQuery(b string) (Item, error) {
-	var s scanner.Scanner
-	s.Init(strings.NewReader(b))
-	s.Mode ^= scanner.SkipComments
+// NewFromFS returns a read-only allow list reading from an io/fs filesystem
+// such as an embed.FS containing the queries and fragments directories.
+func NewFromFS(fsys fs.FS) (*List, error) {
+	if fsys == nil {
+		return nil, fmt.Errorf("no filesystem defined for the allow list")
+	}
+	return NewReadOnly(Config{}, afero.FromIOFS{FS: rootedFS{fsys}})
+}
 
-	var op, sp scanner.Position
-	var item Item
-	var err error
+// NewLayered returns an allow list reading from the filesystem layers
+// listed from the bottom to the top layer. Files in a higher layer shadow
+// the files with the same path in the layers below and the directories are
+// merged. Saves and removes only change the top layer, the lower layers are
+// never written to (see afero.CopyOnWriteFs). Since files in the lower
+// layers cannot be removed, removing a query only found in them or saving
+// over it in a different format (extension) than it has there fails.
+func NewLayered(layers ...afero.Fs) (*List, error) {
+	if len(layers) == 0 {
+		return nil, fmt.Errorf("no filesystem defined for the allow list")
+	}
 
-	st := expComment
+	fs := layers[0]
+	for _, l := range layers[1:] {
+		fs = afero.NewCopyOnWriteFs(fs, l)
+	}
+	return New(Config{}, fs)
+}
 
-	for tok := s.Scan(); tok != scanner.EOF; tok = s.Scan() {
-		txt := s.TokenText()
+// rootedFS allows an io/fs filesystem to be opened with rooted paths
+// (/queries) like the other afero filesystems.
+type rootedFS struct {
+	fsys fs.FS
+}
 
-		switch {
-		case strings.HasPrefix(txt, "/*"):
-			v := b[sp.Offset:s.Pos().Offset]
-			item, err = setValue(st, v, item)
-			sp = s.Pos()
+func (r rootedFS) Open(name string) (fs.File, error) {
+	name = strings.TrimPrefix(name, "/")
+	if name == "" {
+		name = "."
+	}
+	return r.fsys.Open(name)
+}
 
-		case strings.HasPrefix(txt, "variables"):
-			v := b[sp.Offset:s.Pos().Offset]
-			item, err = setValue(st, v, item)
-			sp = s.Pos()
-			st = expVar
+func New(conf Config, fs afero.Fs) (*List, error) {
+	if fs == nil {
+		return nil, fmt.Errorf("no filesystem defined for the allow list")
+	}
 
-		case isGraphQL(txt):
-			v := b[sp.Offset:s.Pos().Offset]
-			item, err = setValue(st, v, item)
-			sp = op
-			st = expQuery
+	if err := conf.init(); err != nil {
+		return nil, err
+	}
 
-		case strings.HasPrefix(txt, "fragment"):
-			v := b[sp.Offset:s.Pos().Offset]
-			item, err = setValue(st, v, item)
-			sp = op
-			st = expFrag
-		}
+	_ = fs.MkdirAll(conf.QueryDir, os.ModePerm)
+	_ = fs.MkdirAll(conf.FragmentDir, os.ModePerm)
 
-		if err != nil {
-			return item, err
-		}
+	return newList(conf, newFsStore(fs, conf))
+}
 
-		op = s.Pos()
+// newList returns an allow list keeping its files in the store
+func newList(conf Config, s Store) (*List, error) {
+	al := &List{
+		saveChan: make(chan saveReq, conf.SaveQueueSize),
+		store:    s,
+		conf:     conf,
+		files:    &sync.Map{},
 	}
+	al.drained = sync.NewCond(&al.mu)
 
-	if st == expQuery || st == expFrag {
-		v := b[sp.Offset:s.Pos().Offset]
-		item, err = setValue(st, v, item)
+	if err := al.initCache(); err != nil {
+		return nil, err
 	}
 
-	if err != nil {
-		return item, err
+	al.workers.Add(conf.SaveWorkers)
+	for i := 0; i < conf.SaveWorkers; i++ {
+		go al.saveWorker()
 	}
 
-	item.key = strings.ToLower(item.Name)
-	return item, nil
+	return al, nil
 }
 
-func setValue(st int, v string, item Item) (Item, error) {
-	val := func() string {
-		return strings.TrimSpace(v[:strings.LastIndexByte(v, '}')+1])
+func (al *List) saveWorker() {
+	defer al.workers.Done()
+
+	for r := range al.saveChan {
+		item, err := al.process(r)
+		if r.op == opSave && al.conf.OnSave != nil {
+			al.conf.OnSave(item, err)
+		}
+		if r.reply != nil {
+			r.reply <- err
+		} else if err != nil && al.conf.Log != nil {
+			al.conf.Log.Println("WRN allow list save:", err)
+		}
+		al.done()
 	}
-	switch st {
-	case expComment:
-		item.Comment = val()
+}
 
-	case expVar:
-		item.Vars = val()
+func (al *List) done() {
+	al.mu.Lock()
+	al.pending--
+	if al.pending == 0 {
+		al.drained.Broadcast()
+	}
+	al.mu.Unlock()
+}
 
-	case expQuery:
-		item.Query = val()
+// process handles a queued request recovering from any panic so
+// the worker can continue with the next request.
+func (al *List) process(r saveReq) (item Item, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("allow list: panic saving '%s': %v", r.item.Name, v)
+		}
+	}()
 
-	case expFrag:
-		f := Frag{Value: val()}
-		f.Name = fragmentName(f.Value)
-		item.frags = append(item.frags, f)
-	}
+	item = r.item
 
-	return item, nil
+	switch r.op {
+	case opRemove:
+		if err = al.remove(r.item.Namespace, r.item.Name, r.gcFrags); err == nil {
+			al.updateIndex(r.item, true)
+		}
+	case opSaveMany:
+		err = al.saveMany(r)
+	case opSaveFragment:
+		err = al.saveFragment(r)
+	case opRename:
+		item, err = al.rename(r)
+	default:
+		item, err = al.save(r)
+	}
+	return item, err
 }
 
-func (al *List) save(item Item) error {
-	var buf bytes.Buffer
+// enqueue adds the request to the save queue. If wait is false and the
+// queue is full ErrQueueFull is returned.
+func (al *List) enqueue(r saveReq, wait bool) error {
+	al.closeMu.RLock()
+	defer al.closeMu.RUnlock()
 
-	qd := &schema.QueryDocument{}
-	if err := qd.Parse(item.Query); err != nil {
-		return err
+	if al.closed {
+		return ErrReadOnly
 	}
 
-	qd.WriteTo(&buf)
-	query := buf.String()
-	buf.Reset()
+	al.mu.Lock()
+	al.pending++
+	al.mu.Unlock()
 
-	h, err := graph.FastParse(query)
-	if err != nil {
-		return err
+	if wait {
+		al.saveChan <- r
+		return nil
 	}
 
-	if h.Name == "" {
-		return errors.New("no query name defined. only named queries are saved to the allow list")
+	select {
+	case al.saveChan <- r:
+		return nil
+	default:
+		al.done()
+		return ErrQueueFull
 	}
+}
 
-	item.Name = h.Name
-	item.key = strings.ToLower(item.Name)
+// Close stops the save workers after the queued items are saved. Once
+// closed the allow list is read-only, saves return ErrReadOnly. Close can
+// be called more than once.
+func (al *List) Close() error {
+	if al.saveChan == nil {
+		return nil
+	}
 
-	if err := al.saveItem(item, true); err != nil {
-		return err
+	al.closeMu.Lock()
+	if !al.closed {
+		al.closed = true
+		close(al.saveChan)
 	}
+	al.closeMu.Unlock()
 
+	al.workers.Wait()
 	return nil
 }
 
-func (al *List) saveItem(item Item, ow bool) error {
-	var err error
+// IsReadOnly reports whether the allow list cannot be saved to, either
+// created with NewReadOnly or closed.
+func (al *List) IsReadOnly() bool {
+	if al.saveChan == nil {
+		return true
+	}
 
-	if item.Vars != "" {
-		var buf bytes.Buffer
+	al.closeMu.RLock()
+	defer al.closeMu.RUnlock()
+	return al.closed
+}
 
-		if err := jsn.Clear(&buf, []byte(item.Vars)); err != nil {
-			return err
-		}
+// ReadOnly returns a read-only view of the allow list reading from the same
+// filesystem. The view has no cache or index so the items saved to the allow
+// list are read by the view when next looked up.
+func (al *List) ReadOnly() *List {
+	conf := al.conf
+	conf.CacheSize = 0
+	conf.EnableIndex = false
+	return &List{store: al.store, conf: conf, files: al.files}
+}
 
-		vj := json.RawMessage(buf.Bytes())
-		if vj, err = json.MarshalIndent(vj, "", "  "); err != nil {
-			return err
-		}
-		item.Vars = string(vj)
+// Flush blocks until all the queued items have been saved.
+func (al *List) Flush() {
+	if al.saveChan == nil {
+		return
 	}
 
-	var b bytes.Buffer
-	y := yaml.NewEncoder(&b)
-	y.SetIndent(2)
-	err = y.Encode(&item)
+	al.mu.Lock()
+	for al.pending != 0 {
+		al.drained.Wait()
+	}
+	al.mu.Unlock()
+}
+
+// Update replaces the query and variables of a saved query and waits for
+// it to be written. When mergeMetadata is true the fields not set in md
+// (zero values) and the comment are kept from the saved query else the
+// saved metadata is replaced with md. ErrNotFound is returned if the
+// query is not already saved.
+func (al *List) Update(vars []byte, query string, md Metadata, namespace string, mergeMetadata bool) error {
+	item, err := al.newItem(vars, query, md, namespace)
 	if err != nil {
 		return err
 	}
 
-	var fn string
-	if item.Namespace != "" {
-		fn = item.Namespace + "." + item.Name + ".yaml"
-	} else {
-		fn = item.Name + ".yaml"
+	r := saveReq{
+		op:     opSave,
+		item:   item,
+		update: true,
+		merge:  mergeMetadata,
+		reply:  make(chan error, 1),
 	}
-
-	if err := afero.WriteFile(
-		al.fs,
-		filepath.Join(queryPath, fn),
-		b.Bytes(),
-		0600); err != nil {
+	if err := al.enqueue(r, true); err != nil {
 		return err
 	}
+	return <-r.reply
+}
 
-	for _, fv := range item.frags {
-		if item.Namespace != "" {
-			fn = item.Namespace + "." + fv.Name
-		} else {
-			fn = fv.Name
-		}
-		err := afero.WriteFile(
-			al.fs,
-			filepath.Join(fragmentPath, fn),
-			[]byte(fv.Value),
-			0600)
+// SetItem is a query to be saved by SetMany
+type SetItem struct {
+	Vars  []byte
+	Query string
+	MD    Metadata
+	NS    string
+}
 
-		if err != nil {
-			return err
-		}
-	}
+// ItemError is the error saving an item in a batch
+type ItemError struct {
+	Index int
+	Name  string
+	Err   error
+}
 
-	return nil
+func (e ItemError) Error() string {
+	return fmt.Sprintf("item %d (%s): %s", e.Index, e.Name, e.Err)
 }
 
-func (al *List) FragmentFetcher(namespace string) func(name string) (string, error) {
+func (e ItemError) Unwrap() error {
+	return e.Err
+}
+
+// BatchError is returned by SetMany with the errors of the items
+// that failed
+type BatchError []ItemError
+
+func (e BatchError) Error() string {
+	var sb strings.Builder
+	sb.WriteString("allow list: failed to save items: ")
+	for i, v := range e {
+		if i != 0 {
+			sb.WriteString("; ")
+		}
+		sb.WriteString(v.Error())
+	}
+	return sb.String()
+}
+
+// SetMany saves the queries to the allow list and waits for them to be
+// written. Every item is parsed and checked before any is written and if
+// any fail nothing is written and a BatchError with the failed items is
+// returned.
+func (al *List) SetMany(items []SetItem, opts ...SetOption) error {
+	var errs BatchError
+
+	if al.IsReadOnly() {
+		return ErrReadOnly
+	}
+
+	list := make([]Item, 0, len(items))
+	for i, v := range items {
+		item, err := parseItem(v.Vars, v.Query, v.MD, v.NS)
+		if err == nil {
+			item, err = al.prepareItem(item)
+		}
+		if err != nil {
+			errs = append(errs, ItemError{Index: i, Name: item.Name, Err: err})
+			continue
+		}
+		list = append(list, item)
+	}
+
+	if len(errs) != 0 {
+		return errs
+	}
+
+	r := saveReq{op: opSaveMany, items: list, reply: make(chan error, 1)}
+	for _, o := range opts {
+		o(&r)
+	}
+	if err := al.enqueue(r, true); err != nil {
+		return err
+	}
+	return <-r.reply
+}
+
+// saveMany checks all the prepared items in the request and only writes
+// them if all can be saved.
+func (al *List) saveMany(r saveReq) error {
+	var errs BatchError
+
+	var write []int
+	seen := make(map[string]int, len(r.items))
+	paths := make([]string, len(r.items))
+
+	for i, v := range r.items {
+		k := indexKey(v.Namespace, v.Name)
+		if j, ok := seen[k]; ok {
+			errs = append(errs, ItemError{Index: i, Name: v.Name,
+				Err: fmt.Errorf("%w: same name as item %d", ErrNameCollision, j)})
+			continue
+		}
+		seen[k] = i
+
+		item, fn, skip, err := al.checkSave(r, v)
+		if err != nil {
+			errs = append(errs, ItemError{Index: i, Name: v.Name, Err: err})
+			continue
+		}
+		if !skip {
+			r.items[i] = item
+			paths[i] = fn
+			write = append(write, i)
+		}
+	}
+
+	if len(errs) != 0 {
+		return errs
+	}
+
+	for _, i := range write {
+		v := r.items[i]
+		fn, err := al.saveItem(v, paths[i])
+		if al.conf.OnSave != nil {
+			al.conf.OnSave(v, err)
+		}
+		if err != nil {
+			return BatchError{{Index: i, Name: v.Name, Err: err}}
+		}
+		al.indexSaved(v, fn)
+	}
+	return nil
+}
+
+// SetOption changes how a query is saved by Set, SetSync and SetMany
+type SetOption func(*saveReq)
+
+// WithOverwrite allows a saved query with the same name but a different
+// query or variables to be overwritten instead of failing with
+// ErrNameCollision.
+func WithOverwrite() SetOption {
+	return func(r *saveReq) {
+		r.overwrite = true
+	}
+}
+
+// Set queues the query to be saved to the allow list and returns without
+// waiting for it to be written. Save errors are only logged. If the save
+// queue is full ErrQueueFull is returned.
+func (al *List) Set(vars []byte, query string, md Metadata, namespace string, opts ...SetOption) error {
+	item, err := al.newItem(vars, query, md, namespace)
+	if err != nil {
+		return err
+	}
+
+	r := saveReq{op: opSave, item: item}
+	for _, o := range opts {
+		o(&r)
+	}
+	return al.enqueue(r, false)
+}
+
+// SetSync saves the query to the allow list and waits for it to be
+// written, returning any error encountered while saving.
+func (al *List) SetSync(vars []byte, query string, md Metadata, namespace string, opts ...SetOption) error {
+	item, err := al.newItem(vars, query, md, namespace)
+	if err != nil {
+		return err
+	}
+
+	r := saveReq{op: opSave, item: item, reply: make(chan error, 1)}
+	for _, o := range opts {
+		o(&r)
+	}
+	if err := al.enqueue(r, true); err != nil {
+		return err
+	}
+	return <-r.reply
+}
+
+// SetFragment saves a shared fragment on its own to be used by the queries
+// in the namespace. The body must be a single fragment definition with the
+// name. A different fragment saved with the name is ErrFragmentConflict
+// unless WithOverwrite is set.
+func (al *List) SetFragment(namespace, name, body string, opts ...SetOption) error {
+	if al.IsReadOnly() {
+		return ErrReadOnly
+	}
+
+	if err := validateNames(namespace, name); err != nil {
+		return err
+	}
+
+	qd := &schema.QueryDocument{}
+	if err := qd.Parse(body); err != nil {
+		return fmt.Errorf("%w: %s: %s", ErrInvalidFragment, name, err)
+	}
+
+	if len(qd.Operations) != 0 || len(qd.Fragments) != 1 || qd.Fragments[0].Name != name {
+		return fmt.Errorf("%w: %s: expected a single fragment definition named '%s'",
+			ErrInvalidFragment, name, name)
+	}
+
+	item := Item{
+		Namespace: namespace,
+		frags:     []Frag{{Name: name, Value: strings.TrimSpace(body)}},
+	}
+
+	r := saveReq{op: opSaveFragment, item: item, reply: make(chan error, 1)}
+	for _, o := range opts {
+		o(&r)
+	}
+	if err := al.enqueue(r, true); err != nil {
+		return err
+	}
+	return <-r.reply
+}
+
+func (al *List) saveFragment(r saveReq) error {
+	f := r.item.frags[0]
+
+	v, err := al.readFragment(r.item.Namespace, f.Name)
+	if err == nil && !r.overwrite && !sameQuery(v, f.Value) {
+		return fmt.Errorf("%w: %s", ErrFragmentConflict, fileName(r.item.Namespace, f.Name))
+	}
+
+	return al.writeFragment(r.item.Namespace, f)
+}
+
+func (al *List) newItem(vars []byte, query string, md Metadata, namespace string) (Item, error) {
+	var item Item
+
+	if al.IsReadOnly() {
+		return item, ErrReadOnly
+	}
+	return parseItem(vars, query, md, namespace)
+}
+
+func parseItem(vars []byte, query string, md Metadata, namespace string) (Item, error) {
+	if query == "" {
+		return Item{}, ErrEmptyQuery
+	}
+
+	if err := validateNamespace(namespace); err != nil {
+		return Item{}, err
+	}
+
+	item, err := parseQuery(query)
+	if err != nil {
+		return item, err
+	}
+
+	item.Namespace = namespace
+	item.Vars = string(vars)
+	item.Metadata = md
+	return item, nil
+}
+
+// Validate parses and normalizes the query and variables as Set does and
+// returns the item that would be saved without queueing it or reading or
+// writing the allow list files.
+func (al *List) Validate(vars []byte, query string, md Metadata, namespace string) (Item, error) {
+	item, err := parseItem(vars, query, md, namespace)
+	if err != nil {
+		return item, err
+	}
+	return al.prepareItem(item)
+}
+
+// Remove deletes the query saved under the namespace and name. When gcFrags
+// is true any fragments used by the query that are no longer referenced by
+// other queries in the same namespace are deleted as well.
+func (al *List) Remove(namespace, name string, gcFrags bool) error {
+	if al.IsReadOnly() {
+		return ErrReadOnly
+	}
+
+	if name == "" {
+		return ErrNoQueryName
+	}
+
+	if err := validateNames(namespace, name); err != nil {
+		return err
+	}
+
+	r := saveReq{
+		op:      opRemove,
+		item:    Item{Namespace: namespace, Name: name},
+		gcFrags: gcFrags,
+		reply:   make(chan error, 1),
+	}
+	if err := al.enqueue(r, true); err != nil {
+		return err
+	}
+	return <-r.reply
+}
+
+func (al *List) Load() ([]Item, error) {
+	return al.LoadCtx(context.Background())
+}
+
+// LoadCtx is Load stopping with the context error when the context is
+// done before all the files are read.
+func (al *List) LoadCtx(ctx context.Context) ([]Item, error) {
+	var items []Item
+	start := time.Now()
+
+	err := al.rangeCtx(ctx, func(item Item) error {
+		items = append(items, item)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if items, err = al.dedupe(items); err != nil {
+		return nil, err
+	}
+
+	if al.conf.EnableIndex {
+		al.setIndex(items)
+	}
+	al.setHashes(items)
+	al.purgeCache()
+	al.resetLibrary()
+
+	if al.conf.OnLoad != nil {
+		al.conf.OnLoad(len(items), time.Since(start))
+	}
+	return items, nil
+}
+
+// Range calls fn with each of the saved items reading one file at a time.
+// It stops and returns the error if reading a file fails, unless the file
+// is skipped (see Config.SkipInvalid), or fn returns an error.
+func (al *List) Range(fn func(Item) error) error {
+	return al.rangeCtx(context.Background(), fn)
+}
+
+func (al *List) rangeCtx(ctx context.Context, fn func(Item) error) error {
+	return al.rangeFiles(ctx, nil, fn)
+}
+
+// rangeFiles calls fn with the items in the query files matching the
+// filter (all the files if nil) skipping the invalid files as Range does
+func (al *List) rangeFiles(ctx context.Context, match func(fn string) bool, fn func(Item) error) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("allow list: %w", err)
+	}
+
+	files, err := al.queryFiles()
+	if err != nil {
+		return err
+	}
+
+	var d defaults
+
+	for _, f := range files {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("allow list: %w", err)
+		}
+
+		if match != nil && !match(f) {
+			continue
+		}
+
+		items, err := al.get(f, &d)
+		if err != nil {
+			if al.skipInvalid(err) {
+				continue
+			}
+			return err
+		}
+		for _, v := range items {
+			if err := fn(v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// loadFiles returns the items in the query files matching the filter
+// handling the invalid files and duplicate items as Load does
+func (al *List) loadFiles(match func(fn string) bool) ([]Item, error) {
+	var items []Item
+
+	err := al.rangeFiles(context.Background(), match, func(item Item) error {
+		items = append(items, item)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return al.dedupe(items)
+}
+
+// skipInvalid reports whether the file that failed to be read is skipped
+// logging the error
+func (al *List) skipInvalid(err error) bool {
+	if !al.conf.SkipInvalid {
+		return false
+	}
+	if al.conf.Log != nil {
+		al.conf.Log.Printf("WRN allow list: skipping invalid file: %s", err)
+	}
+	return true
+}
+
+// LoadMap returns all the items keyed by the lowercase namespace and name
+// joined by a dot (just the name when there is no namespace), the same keys
+// used by the index. Items with the same key are handled by Load as set
+// by Config.OnDuplicate.
+func (al *List) LoadMap() (map[string]Item, error) {
+	items, err := al.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	m := make(map[string]Item, len(items))
+	for _, v := range items {
+		m[indexKey(v.Namespace, v.Name)] = v
+	}
+	return m, nil
+}
+
+// dedupe applies the OnDuplicate policy to the items with the same
+// namespace and name keeping the order they were read in
+func (al *List) dedupe(items []Item) ([]Item, error) {
+	seen := make(map[string]int, len(items))
+	res := items[:0]
+
+	for _, v := range items {
+		// the empty items read from empty files have no name
+		if v.Query == "" {
+			res = append(res, v)
+			continue
+		}
+
+		k := indexKey(v.Namespace, v.Name)
+		i, ok := seen[k]
+		if !ok {
+			seen[k] = len(res)
+			res = append(res, v)
+			continue
+		}
+
+		switch al.conf.OnDuplicate {
+		case DuplicateFirstWins:
+			al.logDuplicate(k, res[i], v)
+		case DuplicateLastWins:
+			al.logDuplicate(k, v, res[i])
+			res[i] = v
+		default:
+			return nil, fmt.Errorf("allow list: %w: %s in %s and %s",
+				ErrDuplicateOperation, k, res[i].source, v.source)
+		}
+	}
+	return res, nil
+}
+
+// logDuplicate logs the duplicate item dropped in favour of the one kept
+func (al *List) logDuplicate(k string, kept, dropped Item) {
+	if al.conf.Log != nil {
+		al.conf.Log.Printf("WRN allow list: %s: %s in %s ignored, using the one in %s",
+			ErrDuplicateOperation, k, dropped.source, kept.source)
+	}
+}
+
+// Reload reads all the items again rebuilding the index if enabled.
+func (al *List) Reload() ([]Item, error) {
+	return al.Load()
+}
+
+func (al *List) setIndex(items []Item) {
+	index := make(map[string]Item, len(items))
+	for _, v := range items {
+		index[indexKey(v.Namespace, v.Name)] = v
+	}
+
+	al.indexMu.Lock()
+	al.index = index
+	al.indexMu.Unlock()
+}
+
+func (al *List) updateIndex(item Item, remove bool) {
+	al.invalidateCache(item)
+	al.updateHashes(item, remove)
+
+	if !al.conf.EnableIndex {
+		return
+	}
+
+	al.indexMu.Lock()
+	defer al.indexMu.Unlock()
+
+	if al.index == nil {
+		return
+	}
+
+	if remove {
+		delete(al.index, indexKey(item.Namespace, item.Name))
+	} else {
+		al.index[indexKey(item.Namespace, item.Name)] = item
+	}
+}
+
+func (al *List) fromIndex(name string) (Item, bool) {
+	if !al.conf.EnableIndex {
+		return Item{}, false
+	}
+
+	al.indexMu.RLock()
+	item, ok := al.index[strings.ToLower(name)]
+	al.indexMu.RUnlock()
+	return item, ok
+}
+
+func indexKey(namespace, name string) string {
+	return strings.ToLower(fileName(namespace, name))
+}
+
+// LoadNamespace returns the items in the namespace. Only the files with
+// the namespace prefix are read, an empty namespace returns the items from
+// files with no namespace prefix.
+func (al *List) LoadNamespace(namespace string) ([]Item, error) {
+	return al.loadFiles(func(fn string) bool {
+		ns, _ := SplitName(fileStem(fn))
+		return ns == namespace
+	})
+}
+
+// LoadGlob returns the items in the files with the namespace and name
+// (<namespace>.<name>, the filename without the extension) matching the
+// pattern (see filepath.Match), for example admin.* for the items in the
+// admin namespace. A * also matches dots so * matches all the files. Only
+// the files with matching names are read.
+func (al *List) LoadGlob(pattern string) ([]Item, error) {
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("allow list: %s: %w", pattern, err)
+	}
+
+	return al.loadFiles(func(fn string) bool {
+		ok, _ := filepath.Match(pattern, fileStem(fn))
+		return ok
+	})
+}
+
+// Search returns the items with a name starting with the prefix (ignoring
+// case). Only the files with matching names are read. If a namespace is
+// given only the items in it are returned else those in all namespaces.
+func (al *List) Search(prefix string, namespace ...string) ([]Item, error) {
+	prefix = strings.ToLower(prefix)
+
+	return al.loadFiles(func(fn string) bool {
+		ns, name := SplitName(fileStem(fn))
+		if len(namespace) != 0 && ns != namespace[0] {
+			return false
+		}
+		return strings.HasPrefix(strings.ToLower(name), prefix)
+	})
+}
+
+// Names returns the names (including the namespace prefix if any) of all
+// the saved queries without reading or parsing the query files.
+func (al *List) Names() ([]string, error) {
+	files, err := al.queryFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(files))
+	for _, fn := range files {
+		names = append(names, fileStem(fn))
+	}
+	return names, nil
+}
+
+// Count returns the number of saved queries without reading or parsing
+// the query files.
+func (al *List) Count() (int, error) {
+	files, err := al.queryFiles()
+	if err != nil {
+		return 0, err
+	}
+	return len(files), nil
+}
+
+// queryFiles returns the paths of all the files in the query directory
+// with a known file type.
+func (al *List) queryFiles() ([]string, error) {
+	var files []string
+
+	fi, err := al.listFiles(KindQuery, false)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range fi {
+		if isDefaultsFile(f.name()) || isNamespaceFile(f.name()) || !isQueryFile(f.name()) {
+			continue
+		}
+		files = append(files, f.path)
+	}
+	return files, nil
+}
+
+func (al *List) GetByName(filePath string) (Item, error) {
+	var item Item
+
+	if v, ok := al.fromIndex(filePath); ok {
+		return v, nil
+	}
+
+	if err := validateNames(SplitName(filePath)); err != nil {
+		return item, err
+	}
+
+	key := strings.ToLower(filePath)
+	v, ok, gen := al.fromCache(key)
+	if ok {
+		return v, nil
+	}
+
+	fn, err := al.findFileFold(filePath)
+	if err != nil || fn == "" {
+		return item, err
+	}
+	_, name := SplitName(filePath)
+
+	if item, err = al.getItem(fn, name, &defaults{}); err != nil {
+		return item, err
+	}
+	al.addCache(key, item, gen)
+	return item, nil
+}
+
+// fromCache returns the cached item for the key along with the cache
+// generation to pass to addCache on a miss.
+func (al *List) fromCache(key string) (Item, bool, uint64) {
+	if al.cache == nil {
+		return Item{}, false, 0
+	}
+
+	al.cacheMu.Lock()
+	gen := al.cacheGen
+	v, ok := al.cache.Get(key)
+	al.cacheMu.Unlock()
+
+	if al.conf.OnCache != nil {
+		al.conf.OnCache(key, ok)
+	}
+	if !ok {
+		return Item{}, false, gen
+	}
+	return v.(Item), true, gen
+}
+
+// addCache adds the item unless the cache was invalidated since gen
+// was returned by fromCache.
+func (al *List) addCache(key string, item Item, gen uint64) {
+	if al.cache == nil {
+		return
+	}
+
+	al.cacheMu.Lock()
+	if al.cacheGen == gen {
+		al.cache.Add(key, item)
+	}
+	al.cacheMu.Unlock()
+}
+
+// invalidateCache removes the item from the cache including when it was
+// cached under a different key (the file or case used in the lookup).
+func (al *List) invalidateCache(item Item) {
+	if al.cache == nil {
+		return
+	}
+
+	al.cacheMu.Lock()
+	defer al.cacheMu.Unlock()
+
+	al.cacheGen++
+	al.cache.Remove(indexKey(item.Namespace, item.Name))
+
+	for _, k := range al.cache.Keys() {
+		v, ok := al.cache.Peek(k)
+		if !ok {
+			continue
+		}
+		if ci := v.(Item); ci.Namespace == item.Namespace &&
+			strings.EqualFold(ci.Name, item.Name) {
+			al.cache.Remove(k)
+		}
+	}
+}
+
+func (al *List) purgeCache() {
+	if al.cache == nil {
+		return
+	}
+
+	al.cacheMu.Lock()
+	al.cacheGen++
+	al.cache.Purge()
+	al.cacheMu.Unlock()
+}
+
+// Has reports whether a query is saved under the namespace and name
+// without reading or parsing the query file.
+func (al *List) Has(namespace, name string) (bool, error) {
+	if err := validateNames(namespace, name); err != nil {
+		return false, err
+	}
+
+	fn, err := al.findFile(fileName(namespace, name))
+	if err != nil {
+		return false, err
+	}
+	return fn != "", nil
+}
+
+var queryExts = []string{".gql", ".graphql", ".yml", ".yaml", ".json"}
+
+const gzipExt = ".gz"
+
+// fragmentExt is the extension of the fragment files
+const fragmentExt = ".gql"
+
+// isGQLFile reports whether the query file is a .gql source file
+func isGQLFile(fn string) bool {
+	ext := fileExt(fn)
+	return ext == ".gql" || ext == ".graphql"
+}
+
+func isQueryFile(fn string) bool {
+	ext := fileExt(fn)
+	for _, v := range queryExts {
+		if ext == v {
+			return true
+		}
+	}
+	return false
+}
+
+// findFile returns the path of the query file for the name (including
+// the namespace prefix if any) or an empty string if there is none.
+func (al *List) findFile(name string) (string, error) {
+	fpath := filepath.Join(al.conf.QueryDir, name)
+
+	for _, ext := range queryExts {
+		for _, fn := range []string{fpath + ext, fpath + ext + gzipExt} {
+			if ok, err := al.exists(fn); ok {
+				return fn, nil
+			} else if err != nil {
+				return "", fmt.Errorf("allow list: %w", err)
+			}
+		}
+	}
+	return "", nil
+}
+
+// findFileFold is findFile falling back to a file with the name differing
+// only in case so names are matched case-insensitively on case-sensitive
+// filesystems. The fallback reads the query directory.
+func (al *List) findFileFold(name string) (string, error) {
+	fn, err := al.findFile(name)
+	if err != nil || fn != "" {
+		return fn, err
+	}
+
+	files, err := al.queryFiles()
+	if err != nil {
+		return "", err
+	}
+	for _, fn := range files {
+		if strings.EqualFold(fileStem(fn), name) {
+			return fn, nil
+		}
+	}
+	return "", nil
+}
+
+// Get returns the items saved in the file. Files with multiple
+// operations return an item per operation. Files ending in .gz are
+// decompressed before being read.
+func (al *List) Get(filePath string) ([]Item, error) {
+	return al.GetCtx(context.Background(), filePath)
+}
+
+// GetCtx is Get returning the context error without reading the file
+// when the context is done.
+func (al *List) GetCtx(ctx context.Context, filePath string) ([]Item, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("allow list: %w", err)
+	}
+	return al.get(filePath, &defaults{})
+}
+
+func (al *List) get(filePath string, d *defaults) ([]Item, error) {
+	var items []Item
+	var err error
+
+	switch fileExt(filePath) {
+	case ".gql", ".graphql":
+		items, err := al.itemFromGQL(filePath)
+		if err != nil {
+			return nil, err
+		}
+		for i := range items {
+			if items[i], err = al.inheritMetadata(items[i], d); err != nil {
+				return nil, fmt.Errorf("allow list: %s: %w", filePath, err)
+			}
+			items[i].source = filePath
+			if items[i].header == nil {
+				items[i].header = &headerCache{}
+			}
+		}
+		return items, nil
+	case libraryExt:
+		// fragment library files have no queries
+		return nil, nil
+	case ".yml", ".yaml":
+		items, err = al.itemsFromYaml(filePath)
+	case ".json":
+		var item Item
+		item, err = al.itemFromJSON(filePath)
+		items = []Item{item}
+	default:
+		err = ErrUnknownFileType
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range items {
+		if items[i], err = al.readItem(filePath, items[i], d); err != nil {
+			return nil, err
+		}
+	}
+	return items, nil
+}
+
+// readItem sets the fields of the item read from a yaml or json file
+// that are not saved in it
+func (al *List) readItem(filePath string, item Item, d *defaults) (Item, error) {
+	var err error
+
+	if item, err = al.resolveVarsRef(item, d); err != nil {
+		return item, fmt.Errorf("allow list: %s: %w", filePath, err)
+	}
+
+	if item, err = al.inheritMetadata(item, d); err != nil {
+		return item, fmt.Errorf("allow list: %s: %w", filePath, err)
+	}
+
+	if al.conf.InterpolateVars && !d.saved {
+		if item.Vars, err = interpolateVars(item.Vars); err != nil {
+			return item, fmt.Errorf("allow list: %s: %w", filePath, err)
+		}
+	}
+
+	if item.Vars, err = validateVars(item.Vars); err != nil {
+		return item, fmt.Errorf("allow list: %s: %w", filePath, err)
+	}
+
+	item.header = &headerCache{}
+	item.source = filePath
+
+	// empty files are read as an empty item which is skipped when loading
+	if item.Query == "" {
+		return item, nil
+	}
+
+	h, err := item.Header()
+	if err != nil {
+		return item, fmt.Errorf("allow list: %s: %w", filePath, err)
+	}
+
+	if err := checkOperationName(item.Name, h); err != nil {
+		return item, fmt.Errorf("allow list: %s: %w", filePath, err)
+	}
+
+	// files saved before the operation type was stored
+	if item.OpType == "" {
+		item.OpType = opType(h.Type)
+	}
+	return item, nil
+}
+
+// checkOperationName returns ErrAnonymousOperation if the operation is not
+// named and ErrInvalidName if its name is not the name of the item so the
+// items read can be run by name like those saved.
+func checkOperationName(name string, h graph.Header) error {
+	if h.Name == "" {
+		return fmt.Errorf("%w: %s", ErrAnonymousOperation, name)
+	}
+	if !strings.EqualFold(h.Name, name) {
+		return fmt.Errorf("%w: %s: the operation is named '%s'", ErrInvalidName, name, h.Name)
+	}
+	return nil
+}
+
+// getItem returns the item named name from the file falling back
+// to the first item in the file.
+func (al *List) getItem(filePath, name string, d *defaults) (Item, error) {
+	var item Item
+
+	items, err := al.get(filePath, d)
+	if err != nil || len(items) == 0 {
+		return item, err
+	}
+
+	for _, v := range items {
+		if strings.EqualFold(v.Name, name) {
+			return v, nil
+		}
+	}
+	return items[0], nil
+}
+
+// itemsFromYaml returns an item for each document in the yaml file
+// skipping the empty documents
+func (al *List) itemsFromYaml(filePath string) ([]Item, error) {
+	var items []Item
+
+	b, err := al.readFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("allow list: %w", err)
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	for {
+		var item Item
+
+		err := dec.Decode(&item)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("allow list: %s: %w", filePath, err)
+		}
+
+		if item.Name == "" && item.Query == "" {
+			continue
+		}
+		items = append(items, item)
+	}
+
+	// an empty file is an empty item as before
+	if len(items) == 0 {
+		items = append(items, Item{})
+	}
+	return items, nil
+}
+
+func (al *List) itemFromJSON(filePath string) (Item, error) {
+	var item Item
+
+	b, err := al.readFile(filePath)
+	if err != nil {
+		return item, fmt.Errorf("allow list: %w", err)
+	}
+
+	if err := json.Unmarshal(b, &item); err != nil {
+		return item, fmt.Errorf("allow list: %s: %w", filePath, err)
+	}
+	return item, nil
+}
+
+func (al *List) itemFromGQL(filePath string) ([]Item, error) {
+	queryNS, queryName := SplitName(fileStem(filePath))
+
+	if queryName == "" {
+		return nil, fmt.Errorf("invalid filename: %s", filePath)
+	}
+
+	query, err := al.readGQL(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var md Metadata
+
+	comment, rest := leadingComment(query)
+	if comment != "" {
+		query = rest
+		comment = al.applyDirectives(filePath, comment, &md)
+	}
+
+	vars, rest, err := leadingVars(query)
+	if err != nil {
+		return nil, fmt.Errorf("allow list: %s: %w", filePath, err)
+	}
+	if vars != "" {
+		query = rest
+	}
+
+	if vars, err = validateVars(vars); err != nil {
+		return nil, fmt.Errorf("allow list: %s: %w", filePath, err)
+	}
+
+	qd := &schema.QueryDocument{}
+	if err := qd.Parse(query); err != nil {
+		return nil, fmt.Errorf("allow list: %s: %w", filePath, err)
+	}
+
+	// files with multiple operations are split into an item per operation
+	if len(qd.Operations) > 1 {
+		items, err := splitOperations(qd)
+		if err != nil {
+			return nil, fmt.Errorf("allow list: %s: %w", filePath, err)
+		}
+		for i := range items {
+			items[i].Namespace = queryNS
+			items[i].Comment = comment
+			items[i].Vars = vars
+			items[i].Metadata = md
+		}
+		return items, nil
+	}
+
+	h, err := graph.FastParse(query)
+	if err != nil {
+		return nil, fmt.Errorf("allow list: %s: %w", filePath, err)
+	}
+
+	if h.Name == "" {
+		return nil, fmt.Errorf("allow list: %s: %w: %s", filePath, ErrAnonymousOperation, queryName)
+	}
+
+	if h.Name != queryName {
+		if al.conf.Log != nil {
+			al.conf.Log.Printf("WRN allow list: %s: query name '%s' does not match the filename",
+				filePath, h.Name)
+		}
+		queryName = h.Name
+	}
+
+	item := Item{
+		Namespace: queryNS,
+		Name:      queryName,
+		Comment:   comment,
+		Query:     query,
+		OpType:    opType(h.Type),
+		Vars:      vars,
+		Metadata:  md,
+		key:       strings.ToLower(queryName),
+	}
+	item.setHeader(h)
+
+	return []Item{item}, nil
+}
+
+func parseQuery(b string) (Item, error) {
+	var s scanner.Scanner
+	var serr error
+
+	s.Init(strings.NewReader(b))
+	s.Mode ^= scanner.SkipComments
+	s.Error = func(s *scanner.Scanner, msg string) {
+		if serr == nil {
+			serr = fmt.Errorf("allow list: invalid query: %s: %s", s.Position, msg)
+		}
+	}
+
+	var op, sp scanner.Position
+	var item Item
+	var err error
+
+	st := expComment
+
+	for tok := s.Scan(); tok != scanner.EOF; tok = s.Scan() {
+		txt := s.TokenText()
+
+		switch {
+		case tok == '#':
+			// skip line comments so words in them are not read as keywords
+			for ch := s.Peek(); ch != '\n' && ch != scanner.EOF; ch = s.Peek() {
+				s.Next()
+			}
+
+		case strings.HasPrefix(txt, "/*"):
+			v := substr(b, sp.Offset, s.Pos().Offset)
+			item, err = setValue(st, v, item)
+			sp = s.Pos()
+
+		case strings.HasPrefix(txt, "variables"):
+			v := substr(b, sp.Offset, s.Pos().Offset)
+			item, err = setValue(st, v, item)
+			sp = s.Pos()
+			st = expVar
+
+		case isGraphQL(txt):
+			v := substr(b, sp.Offset, s.Pos().Offset)
+			item, err = setValue(st, v, item)
+			sp = op
+			st = expQuery
+
+		case strings.HasPrefix(txt, "fragment"):
+			v := substr(b, sp.Offset, s.Pos().Offset)
+			item, err = setValue(st, v, item)
+			sp = op
+			st = expFrag
+		}
+
+		if err != nil {
+			return item, err
+		}
+
+		op = s.Pos()
+	}
+
+	if st == expQuery || st == expFrag {
+		v := substr(b, sp.Offset, s.Pos().Offset)
+		item, err = setValue(st, v, item)
+	}
+
+	if err != nil {
+		return item, err
+	}
+
+	if serr != nil {
+		return item, serr
+	}
+
+	if item.Query == "" {
+		return item, ErrEmptyQuery
+	}
+
+	item.key = strings.ToLower(item.Name)
+	return item, nil
+}
+
+// substr returns b[start:end] with the offsets clamped to the bounds
+// of b so a bad offset never panics.
+func substr(b string, start, end int) string {
+	if start < 0 {
+		start = 0
+	}
+	if end > len(b) {
+		end = len(b)
+	}
+	if start > end {
+		return ""
+	}
+	return b[start:end]
+}
+
+func setValue(st int, v string, item Item) (Item, error) {
+	if st == expComment {
+		if c, _ := leadingComment(v); c != "" {
+			item.Comment = c
+		}
+		return item, nil
+	}
+
+	val, err := blockValue(v)
+	if err != nil || val == "" {
+		return item, err
+	}
+
+	switch st {
+	case expVar:
+		item.Vars = val
+
+	case expQuery:
+		item.Query = val
+
+	case expFrag:
+		f := Frag{Value: val}
+		f.Name = fragmentName(f.Value)
+		item.frags = append(item.frags, f)
+	}
+
+	return item, nil
+}
+
+// blockValue returns v up to the brace closing its last top level block
+// dropping what follows, like the keyword starting the next value. Braces
+// in strings and comments are skipped. An empty value is returned when v
+// has nothing but the next keyword.
+func blockValue(v string) (string, error) {
+	var s scanner.Scanner
+	var serr error
+
+	s.Init(strings.NewReader(v))
+	s.Error = func(s *scanner.Scanner, msg string) {
+		if serr == nil {
+			serr = fmt.Errorf("allow list: invalid query: %s: %s", s.Position, msg)
+		}
+	}
+
+	depth, end, n := 0, -1, 0
+
+	for tok := s.Scan(); tok != scanner.EOF; tok = s.Scan() {
+		if tok != '#' {
+			n++
+		}
+
+		switch tok {
+		case '#':
+			for ch := s.Peek(); ch != '\n' && ch != scanner.EOF; ch = s.Peek() {
+				s.Next()
+			}
+
+		case '{':
+			depth++
+
+		case '}':
+			if depth--; depth < 0 {
+				return "", fmt.Errorf("allow list: invalid query: %s: unexpected '}'", s.Position)
+			}
+			if depth == 0 {
+				end = s.Position.Offset + 1
+			}
+		}
+	}
+
+	if serr != nil {
+		return "", serr
+	}
+
+	if depth != 0 {
+		return "", errors.New("allow list: invalid query: unclosed '{'")
+	}
+
+	if end == -1 && n > 1 {
+		return "", fmt.Errorf("allow list: invalid query: no '{' block found: %s",
+			strings.TrimSpace(v))
+	}
+	if end == -1 {
+		return "", nil
+	}
+	return strings.TrimSpace(v[:end]), nil
+}
+
+// formatQuery returns the normalized query in the query format checking
+// the formatted query is the same when parsed.
+func formatQuery(query, format string) (string, error) {
+	if format != QueryFormatCompact {
+		return query, nil
+	}
+
+	q := compactQuery(query)
+	if v, err := Normalize(q); err != nil || v != query {
+		return "", fmt.Errorf("allow list: query changed when compacted: %s", q)
+	}
+	return q, nil
+}
+
+// Normalize returns the query in a canonical format so that queries
+// differing only in whitespace or formatting are the same.
+func Normalize(query string) (string, error) {
+	var sb strings.Builder
+
+	qd := &schema.QueryDocument{}
+	if err := qd.Parse(query); err != nil {
+		return "", err
+	}
+
+	qd.WriteTo(&sb)
+	return sb.String(), nil
+}
+
+func (al *List) save(r saveReq) (Item, error) {
+	item, err := al.prepareItem(r.item)
+	if err != nil {
+		return item, err
+	}
+
+	item, fn, skip, err := al.checkSave(r, item)
+	if err != nil || skip {
+		return item, err
+	}
+
+	if fn, err = al.saveItem(item, fn); err != nil {
+		return item, err
+	}
+	al.indexSaved(item, fn)
+
+	return item, nil
+}
+
+// indexSaved updates the index with the item saved in the file. The item
+// is read back so the index has it as loaded, with the namespace metadata
+// and the shared variables. If it cannot be read it is dropped from the
+// index to be read when looked up.
+func (al *List) indexSaved(item Item, fn string) {
+	if !al.conf.EnableIndex {
+		al.updateIndex(item, false)
+		return
+	}
+
+	v, err := al.getItem(fn, item.Name, &defaults{})
+	if err != nil || !strings.EqualFold(v.Name, item.Name) {
+		al.updateIndex(item, true)
+		return
+	}
+	al.updateIndex(v, false)
+}
+
+// checkSave checks the prepared item can be saved returning the item to
+// save (with the saved metadata merged in if requested), the path of the
+// file it is already saved in if any and whether the write can be skipped
+// since the saved item is unchanged.
+func (al *List) checkSave(r saveReq, item Item) (Item, string, bool, error) {
+	ow := r.overwrite || r.update
+
+	// skip the write if the saved item is unchanged and fail if it's
+	// a different query with the same name unless overwriting
+	fn, err := al.findFileFold(fileName(item.Namespace, item.Name))
+	if err != nil {
+		return item, "", false, err
+	}
+
+	if fn == "" && r.update {
+		return item, fn, false, fmt.Errorf("%w: %s", ErrNotFound, fileName(item.Namespace, item.Name))
+	}
+
+	now := al.savedTime()
+
+	if fn != "" {
+		if v, err := al.getItem(fn, item.Name, &defaults{saved: true}); err == nil {
+			hash := al.savedHash(v)
+
+			if r.merge {
+				item.Metadata = mergeMetadata(v.Metadata, item.Metadata)
+				if item.Comment == "" {
+					item.Comment = v.Comment
+				}
+			}
+
+			// the saved times only change when the item does
+			item.Metadata.CreatedAt = v.Metadata.CreatedAt
+			item.Metadata.UpdatedAt = v.Metadata.UpdatedAt
+
+			// .gql files have no computed metadata to keep current
+			if hash == item.Metadata.Hash && sameMetadata(v.Metadata, item.Metadata) &&
+				(isGQLFile(fn) || sameComputed(v.Metadata, item.Metadata)) {
+				return item, fn, true, nil
+			}
+
+			if hash != item.Metadata.Hash && !ow {
+				return item, fn, false, fmt.Errorf("%w: %s", ErrNameCollision, fn)
+			}
+			item.Metadata.UpdatedAt = now
+		}
+	}
+
+	if item.Metadata.CreatedAt.IsZero() {
+		item.Metadata.CreatedAt = now
+	}
+	if item.Metadata.UpdatedAt.IsZero() {
+		item.Metadata.UpdatedAt = now
+	}
+
+	if err := al.checkFragments(item, ow); err != nil {
+		return item, fn, false, err
+	}
+	return item, fn, false, nil
+}
+
+// sameComputed reports whether the metadata computed when saving is the
+// same, it changes with the hash algorithm or when new fields are added.
+func sameComputed(a, b Metadata) bool {
+	return a.Hash == b.Hash && a.HashAlgorithm == b.HashAlgorithm &&
+		a.APQHash == b.APQHash && a.Depth == b.Depth && a.FieldCount == b.FieldCount
+}
+
+// checkFragments returns ErrFragmentConflict if a fragment of the item is
+// already saved with a different definition. When overwriting the conflict
+// is only logged.
+func (al *List) checkFragments(item Item, ow bool) error {
+	for _, f := range item.frags {
+		v, err := al.readFragment(item.Namespace, f.Name)
+		if err != nil || sameQuery(v, f.Value) {
+			continue
+		}
+
+		name := fileName(item.Namespace, f.Name)
+		if !ow {
+			return fmt.Errorf("%w: %s", ErrFragmentConflict, name)
+		}
+		if al.conf.Log != nil {
+			al.conf.Log.Printf("WRN allow list: query '%s' overwrites the fragment '%s' used by other queries",
+				item.Name, name)
+		}
+	}
+	return nil
+}
+
+// sameQuery reports whether the queries or fragment definitions differ
+// only in formatting.
+func sameQuery(a, b string) bool {
+	na, err1 := Normalize(a)
+	nb, err2 := Normalize(b)
+	if err1 != nil || err2 != nil {
+		return strings.TrimSpace(a) == strings.TrimSpace(b)
+	}
+	return na == nb
+}
+
+// prepareItem formats the query and sets the name, operation type and
+// content hash of the item and normalizes its variables as is done
+// before saving it.
+func (al *List) prepareItem(item Item) (Item, error) {
+	query, err := Normalize(item.Query)
+	if err != nil {
+		return item, err
+	}
+
+	if item.Query, err = formatQuery(query, al.conf.QueryFormat); err != nil {
+		return item, err
+	}
+
+	h, err := graph.FastParse(item.Query)
+	if err != nil {
+		return item, err
+	}
+
+	if h.Name == "" {
+		return item, ErrNoQueryName
+	}
+
+	if err := validateName(h.Name); err != nil {
+		return item, err
+	}
+
+	item.Name = h.Name
+	item.OpType = opType(h.Type)
+	item.key = strings.ToLower(item.Name)
+	item.setHeader(h)
+
+	if err := al.checkDeclaredVars(item); err != nil {
+		return item, err
+	}
+
+	if item.Vars, err = normalizeVars(item.Vars); err != nil {
+		return item, err
+	}
+	item.Metadata.Tags = normalizeTags(item.Metadata.Tags)
+	item.Metadata.Hash = al.contentHash(query, item.Vars)
+	item.Metadata.HashAlgorithm = al.conf.HashAlgorithm
+	item.Metadata.APQHash = apqHash(query)
+
+	if item.Metadata.Depth, item.Metadata.FieldCount, err = complexity(item); err != nil {
+		return item, err
+	}
+
+	if err := al.lint(item); err != nil {
+		return item, err
+	}
+	return item, nil
+}
+
+// mergeMetadata returns the new metadata with the fields that are not
+// set in it taken from the old metadata.
+func mergeMetadata(old, md Metadata) Metadata {
+	if md.Order.Var == "" && len(md.Order.Values) == 0 {
+		md.Order = old.Order
+	}
+	if !md.Deprecated {
+		md.Deprecated = old.Deprecated
+	}
+	if md.DeprecationReason == "" {
+		md.DeprecationReason = old.DeprecationReason
+	}
+	if md.Sunset.IsZero() {
+		md.Sunset = old.Sunset
+	}
+	if len(md.AllowedVars) == 0 {
+		md.AllowedVars = old.AllowedVars
+	}
+	if md.Subscription == nil {
+		md.Subscription = old.Subscription
+	}
+	if md.RateLimit == nil {
+		md.RateLimit = old.RateLimit
+	}
+	if len(md.Tags) == 0 {
+		md.Tags = old.Tags
+	}
+	return md
+}
+
+// savedHash returns the content hash of a saved item computing it
+// for items saved without one or with a different algorithm.
+func (al *List) savedHash(item Item) string {
+	if item.Metadata.Hash != "" && hashAlgorithm(item.Metadata) == al.conf.HashAlgorithm {
+		return item.Metadata.Hash
+	}
+
+	// the hash is of the query without the fragments defined in .gql files
+	// since those are saved apart from it
+	q := item.Query
+	if isGQLFile(item.source) {
+		if v, err := parseQuery(q); err == nil {
+			q = v.Query
+		}
+	}
+
+	query, err := Normalize(q)
+	if err != nil {
+		return ""
+	}
+
+	vars, err := normalizeVars(item.Vars)
+	if err != nil {
+		return ""
+	}
+	return al.contentHash(query, vars)
+}
+
+// normalizeVars clears the values from the variables json and
+// indents it to make it stable to compare and save.
+func normalizeVars(vars string) (string, error) {
+	if vars == "" {
+		return "", nil
+	}
+
+	var buf bytes.Buffer
+	if err := jsn.Clear(&buf, []byte(vars)); err != nil {
+		return "", fmt.Errorf("%w: %s", ErrInvalidVars, err)
+	}
+
+	vj, err := json.MarshalIndent(json.RawMessage(buf.Bytes()), "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrInvalidVars, err)
+	}
+	if emptyVars(vj) {
+		return "", nil
+	}
+	return string(vj), nil
+}
+
+// emptyVars reports whether the variables json is an empty object or null
+// so it is not saved
+func emptyVars(vj []byte) bool {
+	v := string(bytes.TrimSpace(vj))
+	return v == "{}" || v == "null"
+}
+
+// validateVars checks the variables are valid json and returns them
+// indented so they are stable to compare.
+func validateVars(vars string) (string, error) {
+	if strings.TrimSpace(vars) == "" {
+		return "", nil
+	}
+
+	var v json.RawMessage
+	if err := json.Unmarshal([]byte(vars), &v); err != nil {
+		return "", fmt.Errorf("%w: %s", ErrInvalidVars, err)
+	}
+
+	vj, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrInvalidVars, err)
+	}
+	if emptyVars(vj) {
+		return "", nil
+	}
+	return string(vj), nil
+}
+
+// defaultHashAlgorithm is the content hash algorithm when no hasher is
+// set and that of the items saved before the algorithm was saved
+const defaultHashAlgorithm = "sha256"
+
+// contentHash returns a hex encoded hash of the normalized query and
+// variables using the configured hasher.
+func (al *List) contentHash(query, vars string) string {
+	h := al.conf.Hasher()
+	_, _ = io.WriteString(h, query)
+	_, _ = io.WriteString(h, vars)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// hashAlgorithm returns the algorithm of the saved content hash
+func hashAlgorithm(md Metadata) string {
+	if md.HashAlgorithm == "" {
+		return defaultHashAlgorithm
+	}
+	return md.HashAlgorithm
+}
+
+// yamlDocs returns the documents to save in the yaml file at path, the
+// item replacing the document with the same name when the file has other
+// documents so they are kept.
+func (al *List) yamlDocs(item Item, path string) []Item {
+	ext := ".yaml"
+	if al.conf.Compress {
+		ext += gzipExt
+	}
+
+	if path == "" || filepath.Base(path) != fileStem(path)+ext {
+		return []Item{item}
+	}
+
+	docs, err := al.itemsFromYaml(path)
+	if err != nil || len(docs) < 2 {
+		return []Item{item}
+	}
+
+	for i, v := range docs {
+		if indexKey(v.Namespace, v.Name) == indexKey(item.Namespace, item.Name) {
+			docs[i] = item
+			return docs
+		}
+	}
+	return append(docs, item)
+}
+
+// encodeItems returns the items encoded as they are saved in the json or
+// yaml query files. A json file only has the first item.
+func (al *List) encodeItems(format string, items []Item) ([]byte, error) {
+	var b bytes.Buffer
+
+	if format == FormatJSON {
+		e := json.NewEncoder(&b)
+		e.SetIndent("", "  ")
+		if err := e.Encode(&items[0]); err != nil {
+			return nil, err
+		}
+		return b.Bytes(), nil
+	}
+
+	y := yaml.NewEncoder(&b)
+	y.SetIndent(al.conf.YAMLIndent)
+	for _, v := range items {
+		var n yaml.Node
+		if err := n.Encode(&v); err != nil {
+			return nil, err
+		}
+		if al.conf.YAMLSortKeys {
+			sortKeys(&n)
+		}
+		if err := y.Encode(&n); err != nil {
+			return nil, err
+		}
+	}
+	return b.Bytes(), nil
+}
+
+// sortKeys sorts the keys of the yaml mappings in the node by name
+func sortKeys(n *yaml.Node) {
+	if n.Kind == yaml.MappingNode {
+		pairs := make([][2]*yaml.Node, 0, len(n.Content)/2)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			pairs = append(pairs, [2]*yaml.Node{n.Content[i], n.Content[i+1]})
+		}
+		sort.SliceStable(pairs, func(i, j int) bool {
+			return pairs[i][0].Value < pairs[j][0].Value
+		})
+		for i, p := range pairs {
+			n.Content[2*i], n.Content[2*i+1] = p[0], p[1]
+		}
+	}
+	for _, c := range n.Content {
+		sortKeys(c)
+	}
+}
+
+// saveItem writes the item and its fragments. If the item is already saved
+// in the file at path it is saved under the same filename (keeping its case)
+// and if that file has a different extension (format or compression) it
+// is removed so it does not shadow the new file. The hand-written .gql
+// files are never rewritten, saving over one fails with ErrSourceFile.
+func (al *List) saveItem(item Item, path string) (string, error) {
+	if isGQLFile(path) {
+		return "", fmt.Errorf("%w: %s", ErrSourceFile, path)
+	}
+
+	for _, fv := range item.frags {
+		if strings.TrimSpace(fv.Value) == "" {
+			return "", fmt.Errorf("%w: %s", ErrEmptyFragment, fileName(item.Namespace, fv.Name))
+		}
+		if _, err := al.fragmentFile(item.Namespace, fv.Name); err != nil {
+			return "", err
+		}
+	}
+
+	var data []byte
+	var ext string
+	var err error
+
+	switch al.conf.Format {
+	case FormatJSON:
+		data, err = al.encodeItems(FormatJSON, []Item{item})
+		ext = ".json"
+
+	default:
+		data, err = al.encodeItems(FormatYAML, al.yamlDocs(item, path))
+		ext = ".yaml"
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	if al.conf.Compress {
+		if data, err = gzipBytes(data); err != nil {
+			return "", err
+		}
+		ext += gzipExt
+	}
+
+	stem := fileName(item.Namespace, item.Name)
+	if path != "" {
+		stem = fileStem(path)
+	}
+	fn := filepath.Join(al.conf.QueryDir, stem+ext)
+
+	// files with other operations cannot be replaced
+	removeOld := path != "" && path != fn
+	if removeOld {
+		if items, err := al.Get(path); err == nil && len(items) > 1 {
+			removeOld = false
+			if al.conf.Log != nil {
+				al.conf.Log.Printf("WRN allow list: %s: query '%s' saved to %s has other operations",
+					path, item.Name, fn)
+			}
+		}
+	}
+
+	if err := al.writeFile(fn, data); err != nil {
+		return "", fmt.Errorf("allow list: %w", err)
+	}
+
+	if removeOld {
+		if err := al.removeFile(path); err != nil && !os.IsNotExist(err) {
+			return "", fmt.Errorf("allow list: %w", err)
+		}
+	}
+
+	for _, fv := range item.frags {
+		if err := al.writeFragment(item.Namespace, fv); err != nil {
+			return "", err
+		}
+	}
+
+	return fn, nil
+}
+
+// FragmentFetcher returns a function that fetches a fragment by name along
+// with the fragments it uses. The fragments are returned in dependency order
+// with the named fragment last.
+func (al *List) FragmentFetcher(namespace string) func(name string) (string, error) {
 	return func(name string) (string, error) {
-		var fn string
-		if namespace != "" {
-			fn = namespace + "." + name
+		frags, err := al.resolveFragments(namespace, name)
+		if err != nil {
+			return "", err
+		}
+		return joinFragments(frags), nil
+	}
+}
+
+// joinFragments returns the fragment definitions separated by newlines
+func joinFragments(frags []Frag) string {
+	var sb strings.Builder
+	for i, f := range frags {
+		if i != 0 {
+			sb.WriteString("\n")
+		}
+		sb.WriteString(f.Value)
+	}
+	return sb.String()
+}
+
+// resolveFragments returns the named fragment and all the fragments it
+// uses in dependency order. An error is returned if the fragments use
+// each other in a cycle.
+func (al *List) resolveFragments(namespace, name string) ([]Frag, error) {
+	return resolveFragments(namespace, name, func(name string) (string, error) {
+		return al.scopedFragment(namespace, name)
+	})
+}
+
+// resolveFragments resolves the fragments in the namespace reading each
+// one with read
+func resolveFragments(namespace, name string, read func(name string) (string, error)) ([]Frag, error) {
+	var frags []Frag
+	visited := make(map[string]bool)
+
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		if done, ok := visited[name]; ok {
+			if !done {
+				return fmt.Errorf("%w: %s", ErrFragmentCycle,
+					strings.Join(append(path, name), " -> "))
+			}
+			return nil
+		}
+		visited[name] = false
+
+		v, err := read(name)
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(v) == "" {
+			return fmt.Errorf("%w: %s", ErrEmptyFragment, fileName(namespace, name))
+		}
+
+		for _, fn := range fragmentSpreads(v) {
+			if err := visit(fn, append(path, name)); err != nil {
+				return err
+			}
+		}
+
+		visited[name] = true
+		frags = append(frags, Frag{Name: name, Value: v})
+		return nil
+	}
+
+	if err := visit(name, nil); err != nil {
+		return nil, err
+	}
+	return frags, nil
+}
+
+func (al *List) readFragment(namespace, name string) (string, error) {
+	if err := validateNames(namespace, name); err != nil {
+		return "", err
+	}
+
+	fn, err := al.fragmentFile(namespace, name)
+	if err != nil {
+		return "", err
+	}
+
+	// fragments saved before the .gql extension was added
+	if ok, _ := al.exists(fn); !ok && al.conf.FragmentNamer == nil {
+		fn = strings.TrimSuffix(fn, fragmentExt)
+	}
+
+	v, err := al.readFile(fn)
+	if os.IsNotExist(err) {
+		if lv, ok, lerr := al.libraryFragment(namespace, name); lerr != nil {
+			return "", lerr
+		} else if ok {
+			return lv, nil
+		}
+	}
+	return string(normalizeText(v)), err
+}
+
+// fragmentFile returns the path the fragment is saved at
+func (al *List) fragmentFile(namespace, name string) (string, error) {
+	if al.conf.FragmentNamer == nil {
+		return filepath.Join(al.conf.FragmentDir, fileName(namespace, name)+fragmentExt), nil
+	}
+
+	fn := filepath.Join(al.conf.FragmentDir, al.conf.FragmentNamer(namespace, name))
+	rel, err := filepath.Rel(al.conf.FragmentDir, fn)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: %s: path outside the fragment directory: %s",
+			ErrInvalidFragment, fileName(namespace, name), fn)
+	}
+	return fn, nil
+}
+
+// writeFragment saves the fragment to its fragment file
+func (al *List) writeFragment(namespace string, f Frag) error {
+	fn, err := al.fragmentFile(namespace, f.Name)
+	if err != nil {
+		return err
+	}
+
+	if err := al.writeFile(fn, []byte(f.Value)); err != nil {
+		return fmt.Errorf("allow list: %w", err)
+	}
+	return nil
+}
+
+// fragmentStem returns the filename of the fragment file without
+// the directory and extension
+func fragmentStem(fn string) string {
+	return strings.TrimSuffix(filepath.Base(fn), fragmentExt)
+}
+
+// MigrateFragments renames the fragment files saved without an extension
+// to the .gql files used now and returns the number of files renamed.
+// If both files exist the one without an extension is removed.
+func (al *List) MigrateFragments() (int, error) {
+	if al.IsReadOnly() {
+		return 0, ErrReadOnly
+	}
+
+	var n int
+	err := al.migrateFragments(func(fn, newFn string, renamed bool) {
+		if renamed {
+			n++
+		}
+	})
+	return n, err
+}
+
+// migrateFragments renames the fragment files without an extension calling
+// fn with the old and new filename of each file renamed or removed
+func (al *List) migrateFragments(fn func(oldFn, newFn string, renamed bool)) error {
+	fi, err := al.listFiles(KindFragment, false)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range fi {
+		if strings.HasSuffix(f.name(), fragmentExt) || isLibraryFile(f.name()) {
+			continue
+		}
+
+		oldFn := f.path
+		newFn := oldFn + fragmentExt
+
+		ok, _ := al.exists(newFn)
+		if ok {
+			err = al.removeFile(oldFn)
 		} else {
-			fn = name
+			err = al.renameFile(oldFn, newFn)
+		}
+
+		if err != nil {
+			return fmt.Errorf("allow list: %w", err)
+		}
+		fn(oldFn, newFn, !ok)
+	}
+	return nil
+}
+
+func (al *List) remove(namespace, name string, gcFrags bool) error {
+	fn, err := al.findFileFold(fileName(namespace, name))
+	if err != nil {
+		return err
+	}
+
+	if fn == "" {
+		return fmt.Errorf("%w: %s", ErrNotFound, fileName(namespace, name))
+	}
+
+	// the fragments are collected before removing the query so any
+	// error leaves the allow list unchanged
+	var frags []string
+	var inUse map[string]struct{}
+
+	if gcFrags {
+		items, err := al.Get(fn)
+		if err != nil {
+			return err
+		}
+		for _, v := range items {
+			frags = append(frags, fragmentSpreads(v.Query)...)
+		}
+		frags = al.nestedFragments(namespace, frags)
+
+		if len(frags) != 0 {
+			if inUse, err = al.fragmentsInUse(namespace, fn); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := al.removeFile(fn); err != nil {
+		return fmt.Errorf("allow list: %w", err)
+	}
+
+	// the query is removed so failing to remove a fragment is only logged
+	for _, f := range frags {
+		if _, ok := inUse[f]; ok {
+			continue
+		}
+		if err := al.removeFragment(namespace, f); err != nil && al.conf.Log != nil {
+			al.conf.Log.Printf("WRN allow list: %s", err)
+		}
+	}
+	return nil
+}
+
+// fragmentsInUse returns the fragments used by the queries in the
+// namespace other than those in the file skip. The files are read
+// without the side effects of Load, an unreadable file is an error
+// since the fragments it uses are not known.
+func (al *List) fragmentsInUse(namespace, skip string) (map[string]struct{}, error) {
+	files, err := al.queryFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	var used []string
+	d := &defaults{saved: true}
+
+	for _, fn := range files {
+		if fn == skip || isDefaultsFile(fn) || isNamespaceFile(fn) {
+			continue
+		}
+		if ns, _ := SplitName(fileStem(fn)); ns != namespace {
+			continue
+		}
+
+		items, err := al.get(fn, d)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range items {
+			used = append(used, fragmentSpreads(v.Query)...)
+		}
+	}
+
+	inUse := make(map[string]struct{})
+	for _, f := range al.nestedFragments(namespace, used) {
+		inUse[f] = struct{}{}
+	}
+	return inUse, nil
+}
+
+// removeFragment removes the fragment file saved with or without
+// an extension
+func (al *List) removeFragment(namespace, name string) error {
+	fn, err := al.fragmentFile(namespace, name)
+	if err != nil {
+		return err
+	}
+
+	for _, v := range []string{fn, strings.TrimSuffix(fn, fragmentExt)} {
+		if err := al.removeFile(v); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("allow list: %w", err)
+		}
+	}
+	return nil
+}
+
+// nestedFragments returns the names of the fragments and of all the
+// fragments they use. A fragment that cannot be resolved is returned
+// on its own.
+func (al *List) nestedFragments(namespace string, names []string) []string {
+	var res []string
+	seen := make(map[string]struct{})
+
+	add := func(name string) {
+		if _, ok := seen[name]; !ok {
+			seen[name] = struct{}{}
+			res = append(res, name)
+		}
+	}
+
+	for _, name := range names {
+		frags, err := al.resolveFragments(namespace, name)
+		if err != nil {
+			add(name)
+			continue
+		}
+		for _, f := range frags {
+			add(f.Name)
 		}
-		v, err := afero.ReadFile(
-			al.fs,
-			filepath.Join(fragmentPath, fn))
+	}
+	return res
+}
+
+// fileStem returns the filename without the directory and extension
+func fileStem(fn string) string {
+	fn = strings.TrimSuffix(filepath.Base(fn), gzipExt)
+	return strings.TrimSuffix(fn, filepath.Ext(fn))
+}
+
+// fileExt returns the extension of the filename ignoring the .gz
+// extension of compressed files
+func fileExt(fn string) string {
+	return filepath.Ext(strings.TrimSuffix(fn, gzipExt))
+}
+
+// decompressFile returns the contents of the file decompressed if the
+// filename ends in .gz
+func decompressFile(fn string, b []byte) ([]byte, error) {
+	if !strings.HasSuffix(fn, gzipExt) {
+		return b, nil
+	}
+
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+	defer r.Close()
+
+	if b, err = io.ReadAll(r); err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+	return b, nil
+}
+
+func gzipBytes(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
 
-		return string(v), err
+func fileName(namespace, name string) string {
+	if namespace != "" {
+		return namespace + "." + name
 	}
+	return name
 }
 
-func splitName(v string) (string, string) {
+// SplitName splits a query or fragment name as used in filenames into its
+// namespace and name at the last dot.
+//
+//	SplitName("getUser")          // "", "getUser"
+//	SplitName("tenant1.getUser")  // "tenant1", "getUser"
+//	SplitName("a.b.getUser")      // "a.b", "getUser"
+//	SplitName(".getUser")         // "", "getUser"
+//	SplitName("tenant1.")         // "", "" (a trailing dot has no name)
+//	SplitName("")                 // "", ""
+func SplitName(v string) (namespace, name string) {
 	i := strings.LastIndex(v, ".")
 	if i == -1 {
 		return "", v
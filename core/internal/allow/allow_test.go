@@ -1,9 +1,22 @@
 package allow
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
+	"time"
 
 	"github.com/dosco/graphjin/core/internal/graph"
+	"github.com/spf13/afero"
 )
 
 func TestGQLName1(t *testing.T) {
@@ -171,6 +184,38 @@ func TestParse1(t *testing.T) {
 	}
 }
 
+func TestParseBraces(t *testing.T) {
+	q := "variables {\n  \"id\": \"}{\",\n  \"where\": \"{}\"\n}\n\n" +
+		"query getUser($where: String = \"}\") { user(where: $where) { id } } # done }\n"
+
+	item, err := parseQuery(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if item.Vars != "{\n  \"id\": \"}{\",\n  \"where\": \"{}\"\n}" {
+		t.Fatal("unexpected vars: ", item.Vars)
+	}
+
+	if item.Query != `query getUser($where: String = "}") { user(where: $where) { id } }` {
+		t.Fatal("unexpected query: ", item.Query)
+	}
+
+	malformed := []string{
+		`query getUser`,
+		`query getUser { user { id }`,
+		`query getUser { user { id } } }`,
+		`query getUser { user(where: "{") { id } `,
+		`variables "id" query getUser { user { id } }`,
+	}
+
+	for _, v := range malformed {
+		if _, err := parseQuery(v); err == nil {
+			t.Errorf("expected an error for: %s", v)
+		}
+	}
+}
+
 func TestParse2(t *testing.T) {
 	var al = `
  /* Hello world */
@@ -256,3 +301,3580 @@ func TestParse2(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestRemove(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	al, err := New(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q1 := `query getUsers { users { ...User ...Extra } }
+	fragment User on users { id }
+	fragment Extra on users { email }`
+
+	q2 := `query getUser { user { ...User } }
+	fragment User on users { id }`
+
+	if err := al.Set(nil, q1, Metadata{}, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := al.Set(nil, q2, Metadata{}, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := al.Remove("test", "getUsers", true); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, _ := afero.Exists(fs, "/queries/test.getUsers.yaml"); ok {
+		t.Fatal("query file should have been removed")
+	}
+
+	if ok, _ := afero.Exists(fs, "/fragments/test.Extra.gql"); ok {
+		t.Fatal("orphaned fragment should have been removed")
+	}
+
+	if ok, _ := afero.Exists(fs, "/fragments/test.User.gql"); !ok {
+		t.Fatal("fragment in use should not have been removed")
+	}
+
+	if err := al.Remove("test", "getUsers", true); err == nil {
+		t.Fatal("expected an error removing a missing query")
+	}
+}
+
+func TestRemoveNestedFragment(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	al, err := New(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q1 := `query getUser { user { ...A } }
+	fragment A on users { id }`
+
+	q2 := `query getUsers { users { ...B } }
+	fragment B on users { email ...A }
+	fragment A on users { id }`
+
+	for _, q := range []string{q1, q2} {
+		if err := al.SetSync(nil, q, Metadata{}, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := al.Remove("", "getUser", true); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := al.FragmentFetcher("")("B"); err != nil {
+		t.Fatal("expected the fragment used by B to be kept: ", err)
+	}
+
+	if err := al.Remove("", "getUsers", true); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, fn := range []string{"/fragments/A.gql", "/fragments/B.gql"} {
+		if ok, _ := afero.Exists(fs, fn); ok {
+			t.Fatalf("%s: orphaned fragment should have been removed", fn)
+		}
+	}
+}
+
+func TestRemoveWithDuplicates(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	files := map[string]string{
+		"/queries/dup.yaml": "name: dup\nquery: query dup { user { id } }\n",
+		"/queries/dup.gql":  "query dup { user { id } }",
+	}
+	for fn, v := range files {
+		if err := afero.WriteFile(fs, fn, []byte(v), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	al, err := New(Config{EnableIndex: true, OnDuplicate: DuplicateFirstWins}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := `query other { user { ...F } }
+	fragment F on users { id }`
+
+	if err := al.SetSync(nil, q, Metadata{}, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := al.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	// removing does not load the list which fails with the default policy
+	al.conf.OnDuplicate = DuplicateError
+
+	if err := al.Remove("", "other", true); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, _ := afero.Exists(fs, "/fragments/F.gql"); ok {
+		t.Fatal("orphaned fragment should have been removed")
+	}
+
+	if item, err := al.GetByName("other"); err != nil || item.Query != "" {
+		t.Fatal("expected the removed query to be dropped from the index: ", item.Name, err)
+	}
+}
+
+func TestHas(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	if err := afero.WriteFile(fs, "/queries/test.getUser.gql",
+		[]byte(`query getUser { user { id } }`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	al, err := NewReadOnly(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := al.Has("test", "getUser"); err != nil || !ok {
+		t.Fatal("expected query to exist", err)
+	}
+
+	if ok, err := al.Has("", "getUser"); err != nil || ok {
+		t.Fatal("expected query to not exist", err)
+	}
+}
+
+func TestSetSync(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	al, err := New(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := al.SetSync(nil, `query getUser { user { id } }`, Metadata{}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, _ := afero.Exists(fs, "/queries/getUser.yaml"); !ok {
+		t.Fatal("query file should have been written")
+	}
+
+	if err := al.SetSync(nil, `query { user { id } }`, Metadata{}, ""); err == nil {
+		t.Fatal("expected an error saving an unnamed query")
+	}
+}
+
+func TestNameCollision(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	al, err := New(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := al.SetSync(nil, `query getUser { user { id } }`, Metadata{}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := al.SetSync(nil, `query getUser { user { id } }`, Metadata{}, ""); err != nil {
+		t.Fatal("saving the same query again should not fail: ", err)
+	}
+
+	err = al.SetSync(nil, `query getUser { user { id email } }`, Metadata{}, "")
+	if !errors.Is(err, ErrNameCollision) {
+		t.Fatal("expected ErrNameCollision, got ", err)
+	}
+
+	err = al.SetSync(nil, `query getUser { user { id email } }`, Metadata{}, "", WithOverwrite())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	item, err := al.GetByName("getUser")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(item.Query, "email") {
+		t.Fatal("query should have been overwritten")
+	}
+}
+
+func TestJSONFormat(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	al, err := New(Config{Format: FormatJSON}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var md Metadata
+	md.Order.Var = "order"
+	md.Order.Values = []string{"price_asc", "price_desc"}
+
+	err = al.SetSync([]byte(`{"id": 5}`), `query getProducts { products { id } }`, md, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	item, err := al.GetByName("getProducts")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if item.Name != "getProducts" {
+		t.Fatal("unexpected name: ", item.Name)
+	}
+
+	if item.Metadata.Order.Var != "order" || len(item.Metadata.Order.Values) != 2 {
+		t.Fatal("metadata did not round-trip: ", item.Metadata)
+	}
+
+	if item.Vars == "" {
+		t.Fatal("vars did not round-trip")
+	}
+}
+
+func TestSaveUnchanged(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	al, err := New(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := `query getUser { user { id } }`
+	if err := al.SetSync([]byte(`{"id": 1}`), q, Metadata{}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	fi1, err := fs.Stat("/queries/getUser.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q = `query getUser {
+		user {
+			id
+		}
+	}`
+	if err := al.SetSync([]byte(`{"id":   2}`), q, Metadata{}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	fi2, err := fs.Stat("/queries/getUser.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !fi1.ModTime().Equal(fi2.ModTime()) {
+		t.Fatal("unchanged query should not have been rewritten")
+	}
+
+	item, err := al.GetByName("getUser")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if item.Metadata.Hash == "" {
+		t.Fatal("expected content hash to be saved")
+	}
+}
+
+func TestErrors(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	ro, err := NewReadOnly(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ro.Set(nil, `query getUser { user { id } }`, Metadata{}, ""); !errors.Is(err, ErrReadOnly) {
+		t.Fatal("expected ErrReadOnly, got ", err)
+	}
+
+	al, err := New(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := al.Set(nil, "", Metadata{}, ""); !errors.Is(err, ErrEmptyQuery) {
+		t.Fatal("expected ErrEmptyQuery, got ", err)
+	}
+
+	if err := al.SetSync(nil, `query { user { id } }`, Metadata{}, ""); !errors.Is(err, ErrNoQueryName) {
+		t.Fatal("expected ErrNoQueryName, got ", err)
+	}
+
+	if err := al.Remove("", "getUser", false); !errors.Is(err, ErrNotFound) {
+		t.Fatal("expected ErrNotFound, got ", err)
+	}
+
+	if _, err := al.Get("/queries/getUser.txt"); !errors.Is(err, ErrUnknownFileType) {
+		t.Fatal("expected ErrUnknownFileType, got ", err)
+	}
+
+	var pe *os.PathError
+	if _, err := al.Get("/queries/getUser.yaml"); !errors.As(err, &pe) {
+		t.Fatal("expected a wrapped *os.PathError, got ", err)
+	}
+}
+
+func TestCustomDirs(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	conf := Config{QueryDir: "/config/allow/queries", FragmentDir: "/config/allow/fragments"}
+	al, err := New(conf, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := `query getUser { user { ...User } }
+	fragment User on users { id }`
+
+	if err := al.SetSync(nil, q, Metadata{}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, _ := afero.Exists(fs, "/config/allow/queries/getUser.yaml"); !ok {
+		t.Fatal("query should be saved in the configured directory")
+	}
+
+	if v, err := al.FragmentFetcher("")("User"); err != nil || v == "" {
+		t.Fatal("fragment should be read from the configured directory", err)
+	}
+
+	if items, err := al.Load(); err != nil || len(items) != 1 {
+		t.Fatal("expected one item to be loaded", err)
+	}
+
+	conf = Config{QueryDir: "/allow", FragmentDir: "/allow/"}
+	if _, err := New(conf, fs); err == nil {
+		t.Fatal("expected an error when the directories are the same")
+	}
+}
+
+func TestNames(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	files := []string{
+		"/queries/getUser.gql",
+		"/queries/test.getUsers.yaml",
+		"/queries/README.md",
+	}
+	for _, fn := range files {
+		if err := afero.WriteFile(fs, fn, []byte(""), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := fs.MkdirAll("/queries/sub", 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	al, err := NewReadOnly(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names, err := al.Names()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(names) != 2 || names[0] != "getUser" || names[1] != "test.getUsers" {
+		t.Fatal("unexpected names: ", names)
+	}
+
+	if n, err := al.Count(); err != nil || n != 2 {
+		t.Fatal("expected a count of 2, got ", n, err)
+	}
+}
+
+func TestGQLFileInvalid(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	q := "query getUser {\n\tuser {\n\t\tid\n\t}\n"
+	if err := afero.WriteFile(fs, "/queries/getUser.gql", []byte(q), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	al, err := NewReadOnly(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = al.GetByName("getUser")
+	if err == nil {
+		t.Fatal("expected an error loading a broken query")
+	}
+
+	if !strings.Contains(err.Error(), "/queries/getUser.gql") ||
+		!strings.Contains(err.Error(), "line 5") {
+		t.Fatal("expected the filename and line in the error: ", err)
+	}
+
+	if _, err := al.Load(); err == nil {
+		t.Fatal("expected an error loading a broken query")
+	}
+}
+
+func TestGQLFileName(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	q := `query getUserByID { user { id } }`
+	if err := afero.WriteFile(fs, "/queries/test.getUser.gql", []byte(q), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	al, err := NewReadOnly(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	item, err := al.GetByName("test.getUser")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if item.Name != "getUserByID" || item.Namespace != "test" {
+		t.Fatal("expected the query name to be used: ", item.Namespace, item.Name)
+	}
+}
+
+func TestGQLFileBOM(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	files := map[string]string{
+		"/queries/getUser.gql":  "\xEF\xBB\xBFquery getUser {\r\n  user {\r\n    id\r\n  }\r\n}\r\n",
+		"/queries/getUsers.gql": "\xEF\xBB\xBF/*\r\n  Fetch users\r\n*/\r\nquery getUsers { users { ...User } }\r\n",
+		"/fragments/User.gql":   "\xEF\xBB\xBFfragment User on users {\r\n  id\r\n}\r\n",
+	}
+	for fn, v := range files {
+		if err := afero.WriteFile(fs, fn, []byte(v), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	al, err := NewReadOnly(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	item, err := al.GetByName("getUser")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if item.Name != "getUser" || strings.ContainsAny(item.Query, "\r\uFEFF") {
+		t.Fatalf("unexpected item: %s: %q", item.Name, item.Query)
+	}
+
+	item, err = al.GetByName("getUsers")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if item.Name != "getUsers" || item.Comment != "Fetch users" ||
+		strings.ContainsAny(item.Query, "\r\uFEFF") {
+		t.Fatalf("unexpected item: %s: %q: %q", item.Name, item.Comment, item.Query)
+	}
+
+	v, err := al.FragmentFetcher("")("User")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v != "fragment User on users {\n  id\n}\n" {
+		t.Fatalf("unexpected fragment: %q", v)
+	}
+}
+
+func TestGQLFileNameMismatch(t *testing.T) {
+	var buf bytes.Buffer
+	fs := afero.NewMemMapFs()
+
+	q := `query GetUser { user { id } }`
+	if err := afero.WriteFile(fs, "/queries/tenant1.userQueries.gql", []byte(q), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	al, err := NewReadOnly(Config{Log: log.New(&buf, "", 0), EnableIndex: true}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := al.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(list) != 1 || list[0].Name != "GetUser" || list[0].Namespace != "tenant1" {
+		t.Fatal("expected the operation name and the filename namespace: ", list)
+	}
+
+	if !strings.Contains(buf.String(), "'GetUser' does not match the filename") {
+		t.Fatalf("expected the mismatch to be logged, got %q", buf.String())
+	}
+
+	if item, err := al.GetByName("tenant1.GetUser"); err != nil || item.Name != "GetUser" {
+		t.Fatal("expected the item to be found by the operation name: ", item.Name, err)
+	}
+}
+
+func TestGQLFileMultipleOperations(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	q := `
+	query getUser {
+		user { ...User }
+	}
+
+	mutation updateUser {
+		user(update: $data) { ...User email }
+	}
+
+	query getProducts {
+		products { id }
+	}
+
+	fragment User on users { id ...Name }
+	fragment Name on users { full_name }
+	`
+	if err := afero.WriteFile(fs, "/queries/test.users.graphql", []byte(q), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	al, err := NewReadOnly(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items, err := al.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(items) != 3 {
+		t.Fatal("expected 3 items, got ", len(items))
+	}
+
+	for _, v := range items {
+		if v.Namespace != "test" {
+			t.Fatal("expected the namespace of the file: ", v.Namespace)
+		}
+
+		hasFrags := strings.Contains(v.Query, "fragment User on users") &&
+			strings.Contains(v.Query, "fragment Name on users")
+
+		switch v.Name {
+		case "getUser", "updateUser":
+			if !hasFrags || len(v.frags) != 2 {
+				t.Fatal("expected the shared fragments: ", v.Query)
+			}
+		case "getProducts":
+			if strings.Contains(v.Query, "fragment") {
+				t.Fatal("unexpected fragments: ", v.Query)
+			}
+		default:
+			t.Fatal("unexpected item: ", v.Name)
+		}
+	}
+
+	item, err := al.GetByName("test.users")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if item.Name != "getUser" {
+		t.Fatal("expected the first operation, got ", item.Name)
+	}
+}
+
+// blockingFs blocks writes to the queries directory until unblocked
+// and panics when writing a query named panic.
+type blockingFs struct {
+	afero.Fs
+	unblock chan struct{}
+}
+
+func (fs *blockingFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	if flag&os.O_CREATE != 0 && strings.HasPrefix(name, "/queries/") {
+		if strings.Contains(name, "panic.yaml") {
+			panic("write failed")
+		}
+		<-fs.unblock
+	}
+	return fs.Fs.OpenFile(name, flag, perm)
+}
+
+func TestSaveQueue(t *testing.T) {
+	fs := &blockingFs{Fs: afero.NewMemMapFs(), unblock: make(chan struct{})}
+
+	al, err := New(Config{SaveQueueSize: 1}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := al.Set(nil, `query q1 { users { id } }`, Metadata{}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// wait for the worker to pick up the first item
+	for len(al.saveChan) != 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := al.Set(nil, `query q2 { users { id } }`, Metadata{}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := al.Set(nil, `query q3 { users { id } }`, Metadata{}, ""); !errors.Is(err, ErrQueueFull) {
+		t.Fatal("expected ErrQueueFull, got ", err)
+	}
+
+	close(fs.unblock)
+	al.Flush()
+
+	if n, err := al.Count(); err != nil || n != 2 {
+		t.Fatal("expected 2 saved queries, got ", n, err)
+	}
+
+	err = al.SetSync(nil, `query panic { users { id } }`, Metadata{}, "")
+	if err == nil || !strings.Contains(err.Error(), "panic") {
+		t.Fatal("expected the panic to be returned as an error, got ", err)
+	}
+
+	if err := al.SetSync(nil, `query q4 { users { id } }`, Metadata{}, ""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadNamespace(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	files := map[string]string{
+		"/queries/getUser.gql":         `query getUser { user { id } }`,
+		"/queries/tenant1.getUser.gql": `query getUser { user { id } }`,
+		"/queries/tenant2.getUser.gql": `query getUser { user { id } }`,
+		"/queries/tenant2.broken.gql":  `query broken {`,
+	}
+	for fn, q := range files {
+		if err := afero.WriteFile(fs, fn, []byte(q), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	al, err := NewReadOnly(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items, err := al.LoadNamespace("tenant1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].Namespace != "tenant1" {
+		t.Fatal("expected only the tenant1 item: ", items)
+	}
+
+	items, err = al.LoadNamespace("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].Namespace != "" {
+		t.Fatal("expected only the item with no namespace: ", items)
+	}
+}
+
+func TestGQLFileComment(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	q := "/*\n  Fetch a user by id\n*/\nquery getUser { user { id } }"
+	if err := afero.WriteFile(fs, "/queries/getUser.gql", []byte(q), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	al, err := NewReadOnly(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	item, err := al.GetByName("getUser")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if item.Comment != "Fetch a user by id" {
+		t.Fatalf("unexpected comment: %q", item.Comment)
+	}
+
+	al1, err := New(Config{}, afero.NewMemMapFs())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := al1.save(saveReq{item: item}); err != nil {
+		t.Fatal(err)
+	}
+
+	item1, err := al1.GetByName("getUser")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if item1.Comment != item.Comment {
+		t.Fatalf("comment did not round-trip: %q", item1.Comment)
+	}
+}
+
+func TestLoadVars(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	files := map[string]string{
+		"/queries/good.gql": `variables { "id": 1 }
+			query good { user(id: $id) { id } }`,
+		"/queries/bad.gql": `variables { "id": 1
+			query bad { user(id: $id) { id } }`,
+		"/queries/bad.yaml": "name: bad\nquery: 'query bad { user(id: $id) { id } }'\nvars: '{\"id\": }'\n",
+	}
+	for fn, q := range files {
+		if err := afero.WriteFile(fs, fn, []byte(q), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	al, err := NewReadOnly(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items, err := al.Get("/queries/good.gql")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if items[0].Vars != "{\n  \"id\": 1\n}" {
+		t.Fatalf("unexpected vars: %q", items[0].Vars)
+	}
+
+	if strings.Contains(items[0].Query, "variables") {
+		t.Fatalf("variables should not be part of the query: %q", items[0].Query)
+	}
+
+	for _, fn := range []string{"/queries/bad.gql", "/queries/bad.yaml"} {
+		_, err := al.Get(fn)
+		if !errors.Is(err, ErrInvalidVars) || !strings.Contains(err.Error(), fn) {
+			t.Fatal("expected an invalid variables error naming the file, got ", err)
+		}
+	}
+}
+
+func TestIndex(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	if err := afero.WriteFile(fs, "/queries/test.getUser.gql",
+		[]byte(`query getUser { user { id } }`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	al, err := New(Config{EnableIndex: true}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := al.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	// served from the index once the file is gone
+	if err := fs.Remove("/queries/test.getUser.gql"); err != nil {
+		t.Fatal(err)
+	}
+
+	item, err := al.GetByName("test.getUser")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Name != "getUser" {
+		t.Fatal("expected the item from the index, got ", item.Name)
+	}
+
+	if err := al.SetSync(nil, `query getUsers { users { id } }`, Metadata{}, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := al.fromIndex("getUsers"); !ok {
+		t.Fatal("expected the saved item to be indexed")
+	}
+
+	if err := al.Remove("", "getUsers", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := al.fromIndex("getUsers"); ok {
+		t.Fatal("expected the removed item to be dropped from the index")
+	}
+
+	if _, err := al.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := al.fromIndex("test.getUser"); ok {
+		t.Fatal("expected the index to be rebuilt")
+	}
+}
+
+func TestNewFromFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"queries/getUser.gql": &fstest.MapFile{Data: []byte(`query getUser { user { ...User } }`)},
+		"fragments/User":      &fstest.MapFile{Data: []byte(`fragment User on users { id }`)},
+	}
+
+	al, err := NewFromFS(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items, err := al.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].Name != "getUser" {
+		t.Fatal("unexpected items: ", items)
+	}
+
+	if v, err := al.FragmentFetcher("")("User"); err != nil || v == "" {
+		t.Fatal("expected the fragment to be read", err)
+	}
+
+	if err := al.Set(nil, `query getUsers { users { id } }`, Metadata{}, ""); !errors.Is(err, ErrReadOnly) {
+		t.Fatal("expected ErrReadOnly, got ", err)
+	}
+}
+
+func TestFragmentFetcher(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	frags := map[string]string{
+		"/fragments/User":  `fragment User on users { id ...Name ...Email }`,
+		"/fragments/Name":  `fragment Name on users { full_name ...Email }`,
+		"/fragments/Email": `fragment Email on users { email }`,
+		"/fragments/A":     `fragment A on users { id ...B }`,
+		"/fragments/B":     `fragment B on users { id ...A }`,
+	}
+	for fn, v := range frags {
+		if err := afero.WriteFile(fs, fn, []byte(v), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	al, err := NewReadOnly(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fetch := al.FragmentFetcher("")
+
+	v, err := fetch("User")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := frags["/fragments/Email"] + "\n" +
+		frags["/fragments/Name"] + "\n" +
+		frags["/fragments/User"]
+
+	if v != exp {
+		t.Fatalf("expected the fragments in dependency order, got:\n%s", v)
+	}
+
+	if _, err := graph.ParseFragment(v, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := fetch("A"); !errors.Is(err, ErrFragmentCycle) {
+		t.Fatal("expected ErrFragmentCycle, got ", err)
+	}
+}
+
+func TestDeprecationMetadata(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	y := `name: getUser
+query: 'query getUser { user { id } }'
+deprecated: true
+deprecation_reason: use getUserByID
+sunset: 2022-06-01T00:00:00Z
+`
+	if err := afero.WriteFile(fs, "/queries/getUser.yaml", []byte(y), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	al, err := New(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	item, err := al.GetByName("getUser")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	md := item.Metadata
+	if !md.Deprecated || md.DeprecationReason != "use getUserByID" {
+		t.Fatal("deprecation not loaded: ", md)
+	}
+
+	if !md.Sunset.Equal(time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatal("unexpected sunset: ", md.Sunset)
+	}
+
+	if !md.PastSunset(time.Date(2022, 7, 1, 0, 0, 0, 0, time.UTC)) ||
+		md.PastSunset(time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatal("unexpected result from PastSunset")
+	}
+
+	// fields are omitted when not set
+	if err := al.SetSync(nil, `query getUsers { users { id } }`, Metadata{}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := afero.ReadFile(fs, "/queries/getUsers.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(string(b), "deprecat") || strings.Contains(string(b), "sunset") {
+		t.Fatal("unexpected deprecation fields:\n", string(b))
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	q1 := `query getUser($id: ID!) { user(id: $id) { id name } }`
+	q2 := `
+	query getUser(
+		$id: ID!
+	) {
+		user(id: $id) {
+			id
+			name
+		}
+	}`
+
+	n1, err := Normalize(q1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n2, err := Normalize(q2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n1 != n2 {
+		t.Fatalf("expected the same normalized query:\n%s\n%s", n1, n2)
+	}
+
+	if _, err := Normalize(`query getUser {`); err == nil {
+		t.Fatal("expected an error normalizing an invalid query")
+	}
+}
+
+func TestCheckVars(t *testing.T) {
+	item := Item{Name: "getUser"}
+
+	if err := item.CheckVars([]byte(`{ "id": 1, "extra": true }`)); err != nil {
+		t.Fatal("all variables should be allowed: ", err)
+	}
+
+	item.Metadata.AllowedVars = []string{"id", "limit"}
+
+	if err := item.CheckVars([]byte(`{ "id": 1, "limit": 10 }`)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := item.CheckVars(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := item.CheckVars([]byte(`{ "id": 1, "extra": true }`)); !errors.Is(err, ErrVarNotAllowed) {
+		t.Fatal("expected ErrVarNotAllowed, got ", err)
+	}
+
+	if err := item.CheckVars([]byte(`[1, 2]`)); !errors.Is(err, ErrInvalidVars) {
+		t.Fatal("expected ErrInvalidVars, got ", err)
+	}
+}
+
+func TestParseLineComment(t *testing.T) {
+	q := `# Fetch a user by id
+	# and the query "name"
+	query getUser {
+		user(where: { name: "# not a comment" }) { id }
+	}`
+
+	item, err := parseQuery(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if item.Comment != "Fetch a user by id\nand the query \"name\"" {
+		t.Fatalf("unexpected comment: %q", item.Comment)
+	}
+
+	if !strings.HasPrefix(item.Query, "query getUser") ||
+		!strings.Contains(item.Query, `"# not a comment"`) {
+		t.Fatalf("unexpected query: %q", item.Query)
+	}
+
+	q = `/* Fetch a user by id */
+	query getUser { user { id } }`
+
+	if item, err = parseQuery(q); err != nil {
+		t.Fatal(err)
+	}
+
+	if item.Comment != "Fetch a user by id" {
+		t.Fatalf("unexpected comment: %q", item.Comment)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	al, err := New(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = al.Update(nil, `query getUser { user { id } }`, Metadata{}, "", true)
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatal("expected ErrNotFound, got ", err)
+	}
+
+	var md Metadata
+	md.Order.Var = "order"
+	md.Order.Values = []string{"id", "name"}
+	md.AllowedVars = []string{"id"}
+
+	if err := al.SetSync(nil, `query getUser { user { id } }`, md, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	err = al.Update(nil, `query getUser { user { id email } }`, Metadata{Deprecated: true}, "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	item, err := al.GetByName("getUser")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(item.Query, "email") {
+		t.Fatal("query should have been updated")
+	}
+
+	if item.Metadata.Order.Var != "order" || len(item.Metadata.AllowedVars) != 1 {
+		t.Fatal("saved metadata should have been kept")
+	}
+
+	if !item.Metadata.Deprecated {
+		t.Fatal("new metadata should have been saved")
+	}
+
+	err = al.Update(nil, `query getUser { user { id name } }`, Metadata{}, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if item, err = al.GetByName("getUser"); err != nil {
+		t.Fatal(err)
+	}
+
+	if item.Metadata.Order.Var != "" || item.Metadata.Deprecated {
+		t.Fatal("saved metadata should have been replaced")
+	}
+}
+
+func TestCompress(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	al, err := New(Config{Compress: true}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := al.SetSync(nil, `query getUser { user { id } }`, Metadata{}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, _ := afero.Exists(fs, "/queries/getUser.yaml.gz"); !ok {
+		t.Fatal("compressed query file should have been written")
+	}
+
+	gz, err := gzipBytes([]byte("query getProducts { products { id } }"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := afero.WriteFile(fs, "/queries/getProducts.gql.gz", gz, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	item, err := al.GetByName("getUser")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if item.Name != "getUser" {
+		t.Fatal("unexpected item: ", item.Name)
+	}
+
+	names, err := al.Names()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(names) != 2 || names[0] != "getProducts" || names[1] != "getUser" {
+		t.Fatal("unexpected names: ", names)
+	}
+
+	list, err := al.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(list) != 2 || list[0].Name != "getProducts" {
+		t.Fatal("expected both compressed files to be loaded")
+	}
+}
+
+func TestCallbacks(t *testing.T) {
+	var saved []string
+	var saveErrs, loadCount int
+
+	conf := Config{
+		OnSave: func(item Item, err error) {
+			if err != nil {
+				saveErrs++
+				return
+			}
+			saved = append(saved, fileName(item.Namespace, item.Name))
+		},
+		OnLoad: func(count int, dur time.Duration) {
+			loadCount = count
+		},
+	}
+
+	al, err := New(conf, afero.NewMemMapFs())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := al.SetSync(nil, `query getUser { user { id } }`, Metadata{}, "admin"); err != nil {
+		t.Fatal(err)
+	}
+
+	err = al.SetSync(nil, `query getUser { user { id email } }`, Metadata{}, "admin")
+	if !errors.Is(err, ErrNameCollision) {
+		t.Fatal("expected ErrNameCollision, got ", err)
+	}
+
+	if len(saved) != 1 || saved[0] != "admin.getUser" || saveErrs != 1 {
+		t.Fatal("unexpected save callbacks: ", saved, saveErrs)
+	}
+
+	if _, err := al.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	if loadCount != 1 {
+		t.Fatal("unexpected load count: ", loadCount)
+	}
+}
+
+func TestOpType(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	al, err := New(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = al.SetSync(nil, `mutation createUser { user(insert: $data) { id } }`, Metadata{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := afero.ReadFile(fs, "/queries/createUser.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(b), "op_type: mutation") {
+		t.Fatal("operation type should have been saved")
+	}
+
+	files := map[string]string{
+		"/queries/getUser.yaml":    "name: getUser\nquery: query getUser { user { id } }\n",
+		"/queries/newUsers.gql":    "subscription newUsers { users { id } }",
+		"/queries/getProducts.gql": "query getProducts { products { id } }",
+		"/queries/multipleOps.gql": "query getA { a { id } }\nmutation setA { a(update: $data) { id } }",
+	}
+	for fn, v := range files {
+		if err := afero.WriteFile(fs, fn, []byte(v), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	list, err := al.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"createUser":  OpMutation,
+		"getUser":     OpQuery,
+		"newUsers":    OpSubscription,
+		"getProducts": OpQuery,
+		"getA":        OpQuery,
+		"setA":        OpMutation,
+	}
+
+	if len(list) != len(want) {
+		t.Fatal("unexpected number of items: ", len(list))
+	}
+
+	for _, v := range list {
+		if v.OpType != want[v.Name] {
+			t.Errorf("%s: expected %s, got %s", v.Name, want[v.Name], v.OpType)
+		}
+	}
+}
+
+func TestLoadEmptyYaml(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	files := map[string]string{
+		"/queries/getUser.yaml": "name: getUser\nquery: query getUser { user { id } }\n",
+		"/queries/empty.yaml":   "",
+		"/queries/blank.yaml":   "  \n\n",
+	}
+	for fn, v := range files {
+		if err := afero.WriteFile(fs, fn, []byte(v), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	al, err := New(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := al.Load()
+	if err != nil {
+		t.Fatal("expected the empty files to load: ", err)
+	}
+
+	var names []string
+	for _, v := range list {
+		if v.Query != "" {
+			names = append(names, v.Name)
+		}
+	}
+
+	if len(names) != 1 || names[0] != "getUser" {
+		t.Fatal("expected only the getUser query, got ", names)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	al, err := NewReadOnly(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	item, err := al.Validate([]byte(`{ "id": 5 }`), `query getUser { user(id: $id) { id } }`, Metadata{}, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if item.Name != "getUser" || item.Namespace != "admin" || item.OpType != OpQuery {
+		t.Fatalf("unexpected item: %+v", item)
+	}
+
+	if item.Metadata.Hash == "" || strings.Contains(item.Vars, "5") {
+		t.Fatalf("item should have been normalized: %+v", item)
+	}
+
+	if _, err := al.Validate(nil, `query { user { id } }`, Metadata{}, ""); err != ErrNoQueryName {
+		t.Fatal("expected ErrNoQueryName, got ", err)
+	}
+
+	_, err = al.Validate([]byte(`{ "id": `), `query getUser { user(id: $id) { id } }`, Metadata{}, "")
+	if !errors.Is(err, ErrInvalidVars) {
+		t.Fatal("expected ErrInvalidVars, got ", err)
+	}
+
+	if ok, _ := afero.Exists(fs, "/queries"); ok {
+		t.Fatal("validate should not write to the filesystem")
+	}
+}
+
+func TestMigrateFragments(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	frags := map[string]string{
+		"/fragments/admin.User":     `fragment User on users { id ...Name }`,
+		"/fragments/admin.Name":     `fragment Name on users { full_name }`,
+		"/fragments/admin.Name.gql": `fragment Name on users { first_name last_name }`,
+	}
+	for fn, v := range frags {
+		if err := afero.WriteFile(fs, fn, []byte(v), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	al, err := New(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fetch := al.FragmentFetcher("admin")
+
+	v, err := fetch("User")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := frags["/fragments/admin.Name.gql"] + "\n" + frags["/fragments/admin.User"]
+	if v != exp {
+		t.Fatalf("expected %q, got %q", exp, v)
+	}
+
+	n, err := al.MigrateFragments()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 1 {
+		t.Fatal("expected one fragment to be renamed, got ", n)
+	}
+
+	for _, fn := range []string{"/fragments/admin.User", "/fragments/admin.Name"} {
+		if ok, _ := afero.Exists(fs, fn); ok {
+			t.Fatal("fragment file should have been migrated: ", fn)
+		}
+	}
+
+	v1, err := fetch("User")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v1 != v {
+		t.Fatalf("expected %q, got %q", v, v1)
+	}
+}
+
+func TestLoadMap(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	al, err := New(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := al.SetSync(nil, `query getUser { user { id } }`, Metadata{}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := al.SetSync(nil, `query getUser { user { id email } }`, Metadata{}, "Admin"); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := al.LoadMap()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(m) != 2 {
+		t.Fatal("unexpected number of items: ", len(m))
+	}
+
+	if v, ok := m["getuser"]; !ok || v.Namespace != "" {
+		t.Fatal("expected the item without a namespace")
+	}
+
+	if v, ok := m["admin.getuser"]; !ok || v.Namespace != "Admin" {
+		t.Fatal("expected the item in the namespace")
+	}
+}
+
+// caseInsensitiveFs lowercases all paths to behave like a case-insensitive
+// filesystem
+type caseInsensitiveFs struct {
+	afero.Fs
+}
+
+func (c caseInsensitiveFs) Stat(name string) (os.FileInfo, error) {
+	return c.Fs.Stat(strings.ToLower(name))
+}
+
+func (c caseInsensitiveFs) Open(name string) (afero.File, error) {
+	return c.Fs.Open(strings.ToLower(name))
+}
+
+func (c caseInsensitiveFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	return c.Fs.OpenFile(strings.ToLower(name), flag, perm)
+}
+
+func (c caseInsensitiveFs) Remove(name string) error {
+	return c.Fs.Remove(strings.ToLower(name))
+}
+
+func (c caseInsensitiveFs) Rename(oldname, newname string) error {
+	return c.Fs.Rename(strings.ToLower(oldname), strings.ToLower(newname))
+}
+
+func (c caseInsensitiveFs) MkdirAll(name string, perm os.FileMode) error {
+	return c.Fs.MkdirAll(strings.ToLower(name), perm)
+}
+
+func TestCaseInsensitiveLookup(t *testing.T) {
+	tests := []struct {
+		name string
+		fs   afero.Fs
+	}{
+		{"case-sensitive", afero.NewMemMapFs()},
+		{"case-insensitive", caseInsensitiveFs{afero.NewMemMapFs()}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			al, err := New(Config{}, tt.fs)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = al.SetSync(nil, `query GetUser { user { id } }`, Metadata{}, "Admin")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, name := range []string{"Admin.GetUser", "admin.getuser", "ADMIN.GETUSER"} {
+				item, err := al.GetByName(name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if item.Name != "GetUser" {
+					t.Fatalf("%s: expected GetUser, got %q", name, item.Name)
+				}
+			}
+
+			// Has only checks for the exact filename
+			if ok, err := al.Has("Admin", "GetUser"); err != nil || !ok {
+				t.Fatal("expected the query to be found")
+			}
+
+			if ok, err := al.Has("admin", "getUsers"); err != nil || ok {
+				t.Fatal("expected the query not to be found")
+			}
+
+			// saving with a different case replaces the saved file
+			err = al.SetSync(nil, `query getUser { user { id email } }`, Metadata{}, "admin", WithOverwrite())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			names, err := al.Names()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(names) != 1 || !strings.EqualFold(names[0], "admin.getUser") {
+				t.Fatal("unexpected names: ", names)
+			}
+
+			item, err := al.GetByName("admin.getUser")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if item.Name != "getUser" {
+				t.Fatal("query should have been replaced, got ", item.Name)
+			}
+		})
+	}
+}
+
+func TestRange(t *testing.T) {
+	al, err := New(Config{}, afero.NewMemMapFs())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queries := []string{
+		`query getA { a { id } }`,
+		`query getB { b { id } }`,
+		`query getC { c { id } }`,
+	}
+	for _, q := range queries {
+		if err := al.SetSync(nil, q, Metadata{}, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var names []string
+	errStop := errors.New("stop")
+
+	err = al.Range(func(item Item) error {
+		names = append(names, item.Name)
+		if item.Name == "getB" {
+			return errStop
+		}
+		return nil
+	})
+
+	if err != errStop {
+		t.Fatal("expected the callback error, got ", err)
+	}
+
+	if len(names) != 2 || names[0] != "getA" || names[1] != "getB" {
+		t.Fatal("unexpected items: ", names)
+	}
+}
+
+func TestFragmentConflict(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	al, err := New(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q1 := `query getUser { user { ...UserFields } }
+	fragment UserFields on user { id email }`
+
+	q2 := `query getUsers { users { ...UserFields } }
+	fragment UserFields on user { id full_name }`
+
+	q3 := `query getUserIDs { users { ...UserFields } }
+	fragment UserFields on user {
+		id
+		email
+	}`
+
+	if err := al.SetSync(nil, q1, Metadata{}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := al.SetSync(nil, q3, Metadata{}, ""); err != nil {
+		t.Fatal("fragment differing only in formatting should not conflict: ", err)
+	}
+
+	if err := al.SetSync(nil, q2, Metadata{}, ""); !errors.Is(err, ErrFragmentConflict) {
+		t.Fatal("expected ErrFragmentConflict, got ", err)
+	}
+
+	b, err := afero.ReadFile(fs, "/fragments/UserFields.gql")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(b), "email") {
+		t.Fatal("fragment used by getUser should not have been overwritten")
+	}
+
+	if err := al.SetSync(nil, q2, Metadata{}, "", WithOverwrite()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClock(t *testing.T) {
+	now := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	al, err := New(Config{Now: func() time.Time { return now }}, afero.NewMemMapFs())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var item Item
+	item.Metadata.Sunset = now.Add(24 * time.Hour)
+
+	if al.PastSunset(item) {
+		t.Fatal("sunset should not have passed")
+	}
+
+	now = now.Add(48 * time.Hour)
+
+	if !al.PastSunset(item) {
+		t.Fatal("sunset should have passed")
+	}
+
+	al1, err := NewReadOnly(Config{}, afero.NewMemMapFs())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if al1.PastSunset(Item{}) {
+		t.Fatal("items without a sunset never pass it")
+	}
+}
+
+func TestSearch(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	al, err := New(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queries := []struct{ ns, query string }{
+		{"", `query UserByID { user { id } }`},
+		{"", `query users { users { id } }`},
+		{"admin", `query UserEmails { users { email } }`},
+		{"admin", `query products { products { id } }`},
+	}
+	for _, v := range queries {
+		if err := al.SetSync(nil, v.query, Metadata{}, v.ns); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// files not matching the prefix are not read
+	if err := afero.WriteFile(fs, "/queries/invalid.gql", []byte("query {"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	items, err := al.Search("user")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(items) != 3 {
+		t.Fatal("unexpected number of items: ", len(items))
+	}
+
+	if items, err = al.Search("User", "admin"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(items) != 1 || items[0].Name != "UserEmails" {
+		t.Fatal("expected only the item in the namespace")
+	}
+
+	if items, err = al.Search("User", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(items) != 2 {
+		t.Fatal("expected only the items without a namespace")
+	}
+}
+
+func TestQueryFormat(t *testing.T) {
+	q := `query getUser($id: Int! = 5) {
+		user(id: $id, where: { name: { eq: "a \"b\"  c" }, score: { gt: -1.5e3 } }) @skip(if: false) {
+			id
+			... on user { email }
+			...UserFields
+		}
+	}
+	fragment UserFields on user { full_name }`
+
+	exp, err := Normalize(`query getUser($id: Int! = 5) {
+		user(id: $id, where: { name: { eq: "a \"b\"  c" }, score: { gt: -1.5e3 } }) @skip(if: false) {
+			id
+			... on user { email }
+			...UserFields
+		}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range []string{QueryFormatPretty, QueryFormatCompact} {
+		t.Run(format, func(t *testing.T) {
+			al, err := New(Config{QueryFormat: format}, afero.NewMemMapFs())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := al.SetSync(nil, q, Metadata{}, ""); err != nil {
+				t.Fatal(err)
+			}
+
+			item, err := al.GetByName("getUser")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if format == QueryFormatCompact && strings.ContainsAny(item.Query, "\n\t") {
+				t.Fatalf("query should have been compacted: %q", item.Query)
+			}
+
+			if format == QueryFormatPretty && !strings.Contains(item.Query, "\n") {
+				t.Fatalf("query should have been indented: %q", item.Query)
+			}
+
+			v, err := Normalize(item.Query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if v != exp {
+				t.Fatalf("query changed when saved:\n%s\n%s", v, exp)
+			}
+		})
+	}
+
+	if _, err := New(Config{QueryFormat: "tiny"}, afero.NewMemMapFs()); err == nil {
+		t.Fatal("expected an error for an invalid query format")
+	}
+}
+
+func TestSetMany(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	al, err := New(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items := []SetItem{
+		{Query: `query getA { a { id } }`},
+		{Query: `query { b { id } }`},
+		{Query: `query getC { c { id } }`, Vars: []byte(`{ "id": `)},
+		{Query: `query getD { d { id } }`, NS: "admin"},
+	}
+
+	err = al.SetMany(items)
+
+	var berr BatchError
+	if !errors.As(err, &berr) {
+		t.Fatal("expected a BatchError, got ", err)
+	}
+
+	if len(berr) != 2 || berr[0].Index != 1 || berr[1].Index != 2 {
+		t.Fatal("unexpected item errors: ", berr)
+	}
+
+	if !errors.Is(berr[0], ErrNoQueryName) || !errors.Is(berr[1], ErrInvalidVars) {
+		t.Fatal("unexpected item errors: ", berr)
+	}
+
+	if n, _ := al.Count(); n != 0 {
+		t.Fatal("no items should have been saved")
+	}
+
+	// a collision with a saved query fails the whole batch
+	if err := al.SetSync(nil, `query getC { c { id name } }`, Metadata{}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	items = append(items[:1], items[3], SetItem{Query: `query getC { c { id } }`})
+
+	err = al.SetMany(items)
+	if !errors.As(err, &berr) || len(berr) != 1 || !errors.Is(berr[0], ErrNameCollision) {
+		t.Fatal("expected a name collision, got ", err)
+	}
+
+	if n, _ := al.Count(); n != 1 {
+		t.Fatal("no items should have been saved")
+	}
+
+	if err := al.SetMany(items, WithOverwrite()); err != nil {
+		t.Fatal(err)
+	}
+
+	names, err := al.Names()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(names) != 3 || names[0] != "admin.getD" || names[1] != "getA" || names[2] != "getC" {
+		t.Fatal("unexpected names: ", names)
+	}
+}
+
+// noRenameFs fails all renames like filesystems without atomic renames
+type noRenameFs struct {
+	afero.Fs
+}
+
+func (noRenameFs) Rename(oldname, newname string) error {
+	return errors.New("rename not supported")
+}
+
+func TestAtomicWrite(t *testing.T) {
+	for _, fs := range []afero.Fs{afero.NewMemMapFs(), noRenameFs{afero.NewMemMapFs()}} {
+		al, err := New(Config{}, fs)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		q := `query getUser { user { ...User } } fragment User on user { id }`
+		if err := al.SetSync(nil, q, Metadata{}, ""); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, dir := range []string{"/queries", "/fragments"} {
+			fi, err := afero.ReadDir(fs, dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(fi) != 1 || isTempFile(fi[0].Name()) {
+				t.Fatalf("%T: expected only the saved file in %s", fs, dir)
+			}
+		}
+
+		if _, err := al.GetByName("getUser"); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestVarsRef(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	files := map[string]string{
+		"/queries/_defaults.yaml": "pagination:\n  limit: 10\n  offset: 0\nsearch: '{ \"q\": \"\" }'\n",
+		"/queries/getUsers.yaml":  "name: getUsers\nquery: \"query getUsers { users(limit: $limit) { id } }\"\nvars_ref: pagination\n",
+		"/queries/search.yaml":    "name: search\nquery: \"query search { users(search: $q) { id } }\"\nvars_ref: search\n",
+	}
+	for fn, v := range files {
+		if err := afero.WriteFile(fs, fn, []byte(v), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	al, err := NewReadOnly(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := al.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(list) != 2 {
+		t.Fatal("the defaults file should not be loaded as a query, got ", len(list))
+	}
+
+	if !strings.Contains(list[0].Vars, `"limit": 10`) {
+		t.Fatalf("unexpected vars: %s", list[0].Vars)
+	}
+
+	if !strings.Contains(list[1].Vars, `"q": ""`) {
+		t.Fatalf("unexpected vars: %s", list[1].Vars)
+	}
+
+	err = afero.WriteFile(fs, "/queries/getProducts.yaml",
+		[]byte("name: getProducts\nquery: query getProducts { products { id } }\nvars_ref: missing\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := al.GetByName("getProducts"); !errors.Is(err, ErrUnknownVarsRef) {
+		t.Fatal("expected ErrUnknownVarsRef, got ", err)
+	}
+}
+
+// countingFs counts the times the named file is opened
+type countingFs struct {
+	afero.Fs
+	name string
+	n    int
+}
+
+func (fs *countingFs) Open(name string) (afero.File, error) {
+	if name == fs.name {
+		fs.n++
+	}
+	return fs.Fs.Open(name)
+}
+
+func TestVarsRefReadOnce(t *testing.T) {
+	fs := &countingFs{Fs: afero.NewMemMapFs(), name: "/queries/_defaults.yaml"}
+
+	files := map[string]string{
+		"/queries/_defaults.yaml": "pagination:\n  limit: 10\n",
+		"/queries/getUsers.yaml":  "name: getUsers\nquery: \"query getUsers { users(limit: $limit) { id } }\"\nvars_ref: pagination\n",
+		"/queries/getPosts.yaml":  "name: getPosts\nquery: \"query getPosts { posts(limit: $limit) { id } }\"\nvars_ref: pagination\n",
+	}
+	for fn, v := range files {
+		if err := afero.WriteFile(fs, fn, []byte(v), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	al, err := NewReadOnly(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs.n = 0
+	if list, err := al.Load(); err != nil || len(list) != 2 {
+		t.Fatal("expected 2 items, got ", len(list), err)
+	}
+
+	if fs.n != 1 {
+		t.Fatal("expected the defaults file to be read once, got ", fs.n)
+	}
+}
+
+func TestStats(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	al, err := New(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queries := []struct{ ns, query string }{
+		{"", `query getUser { user { ...User } } fragment User on user { id }`},
+		{"admin", `query getUsers { users { id } }`},
+		{"admin", `query getProducts { products { id } }`},
+	}
+	for _, v := range queries {
+		if err := al.SetSync(nil, v.query, Metadata{}, v.ns); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	gql := "query getA { a { id } }\nquery getB { b { id } }"
+	if err := afero.WriteFile(fs, "/queries/ops.gql", []byte(gql), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := al.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if st.Items != 5 || st.Namespaces[""] != 3 || st.Namespaces["admin"] != 2 {
+		t.Fatalf("unexpected item counts: %+v", st)
+	}
+
+	if st.Fragments != 1 || st.Bytes == 0 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+
+	// invalid files are skipped and reported
+	if err := afero.WriteFile(fs, "/queries/bad.gql", []byte("query {"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	st1, err := al.Stats()
+	if err == nil {
+		t.Fatal("expected an error for the invalid file")
+	}
+
+	if st1.Items != st.Items || st1.Bytes <= st.Bytes {
+		t.Fatalf("expected partial stats, got %+v", st1)
+	}
+}
+
+func TestInvalidNamespace(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	al, err := New(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ns := range []string{"../../etc", "a/b", `a\b`, "a.b", "..", "a\nb"} {
+		err := al.SetSync(nil, `query getUser { user { id } }`, Metadata{}, ns)
+		if !errors.Is(err, ErrInvalidNamespace) {
+			t.Errorf("%q: expected ErrInvalidNamespace, got %v", ns, err)
+		}
+
+		if _, err := al.Has(ns, "getUser"); !errors.Is(err, ErrInvalidNamespace) {
+			t.Errorf("%q: expected ErrInvalidNamespace, got %v", ns, err)
+		}
+
+		if err := al.Remove(ns, "getUser", false); !errors.Is(err, ErrInvalidNamespace) {
+			t.Errorf("%q: expected ErrInvalidNamespace, got %v", ns, err)
+		}
+	}
+
+	for _, name := range []string{"../../etc/passwd", "../getUser", "1user"} {
+		if _, err := al.GetByName(name); !errors.Is(err, ErrInvalidName) && !errors.Is(err, ErrInvalidNamespace) {
+			t.Errorf("%q: expected an invalid name error, got %v", name, err)
+		}
+
+		if _, err := al.FragmentFetcher("")(name); !errors.Is(err, ErrInvalidName) && !errors.Is(err, ErrInvalidNamespace) {
+			t.Errorf("%q: expected an invalid name error, got %v", name, err)
+		}
+	}
+
+	if err := al.SetSync(nil, `query getUser { user { id } }`, Metadata{}, "my-app"); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, _ := afero.Exists(fs, "/queries/my-app.getUser.yaml"); !ok {
+		t.Fatal("query file should have been written")
+	}
+}
+
+// failWriteFs fails writes to temporary files like a full disk
+type failWriteFs struct {
+	afero.Fs
+}
+
+type failWriteFile struct {
+	afero.File
+}
+
+func (failWriteFile) Write(b []byte) (int, error) {
+	return 0, errors.New("no space left on device")
+}
+
+func (fs failWriteFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	f, err := fs.Fs.OpenFile(name, flag, perm)
+	if err != nil || !isTempFile(name) {
+		return f, err
+	}
+	return failWriteFile{f}, nil
+}
+
+func TestIsTempFile(t *testing.T) {
+	tests := map[string]bool{
+		"/queries/.getUser.yaml.tmp123456": true,
+		"/queries/.getUser.yaml.tmp":       true,
+		"/queries/.getUser.yaml":           false,
+		"/queries/.hidden":                 false,
+		"/queries/getUser.yaml":            false,
+	}
+
+	for fn, exp := range tests {
+		if v := isTempFile(fn); v != exp {
+			t.Errorf("%s: expected %v, got %v", fn, exp, v)
+		}
+	}
+}
+
+func TestAtomicWriteError(t *testing.T) {
+	mfs := afero.NewMemMapFs()
+
+	if err := afero.WriteFile(mfs, "/queries/getUser.yaml", []byte("name: getUser\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	err := writeFile(failWriteFs{mfs}, "/queries/getUser.yaml", []byte("name: other\n"))
+	if err == nil {
+		t.Fatal("expected the write error")
+	}
+
+	b, err := afero.ReadFile(mfs, "/queries/getUser.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(b) != "name: getUser\n" {
+		t.Fatalf("existing file should not have been changed: %q", b)
+	}
+
+	fi, _ := afero.ReadDir(mfs, "/queries")
+	if len(fi) != 1 {
+		t.Fatal("temporary file should have been removed")
+	}
+}
+
+func TestOverwriteGQLFile(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	files := map[string]string{
+		"/queries/getUser.gql":      "#import \"./user.gql\"\nquery getUser { user { ...User } }",
+		"/queries/user.gql":         "fragment User on user { id }",
+		"/queries/getUsers.graphql": "query getUsers { users { id } }",
+	}
+	for fn, q := range files {
+		if err := afero.WriteFile(fs, fn, []byte(q), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	al, err := New(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, q := range []string{
+		`query getUser { user { id email } }`,
+		`query getUsers { users { id email } }`,
+	} {
+		err := al.SetSync(nil, q, Metadata{}, "", WithOverwrite())
+		if !errors.Is(err, ErrSourceFile) {
+			t.Fatal("expected ErrSourceFile, got ", err)
+		}
+	}
+
+	for fn, q := range files {
+		if b, err := afero.ReadFile(fs, fn); err != nil || string(b) != q {
+			t.Fatalf("%s: expected the file unchanged, got %q: %v", fn, b, err)
+		}
+	}
+
+	if ok, _ := afero.Exists(fs, "/queries/getUser.yaml"); ok {
+		t.Fatal("expected no generated file next to the .gql file")
+	}
+}
+
+func TestSaveUnchangedGQLFile(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	files := map[string]string{
+		"/queries/getUser.gql": "# Fetch a user\n#import \"./user.gql\"\n\nquery getUser { user { ...User } }\n",
+		"/queries/user.gql":    "fragment User on user { id email }\n",
+	}
+	for fn, v := range files {
+		if err := afero.WriteFile(fs, fn, []byte(v), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	al, err := New(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := "query getUser { user { ...User } }\nfragment User on user { id email }"
+	if err := al.SetSync(nil, q, Metadata{}, "", WithOverwrite()); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := afero.ReadFile(fs, "/queries/getUser.gql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != files["/queries/getUser.gql"] {
+		t.Fatalf("expected the .gql file unchanged, got %q", b)
+	}
+
+	if ok, _ := afero.Exists(fs, "/queries/getUser.yaml"); ok {
+		t.Fatal("expected no yaml file saved for the unchanged query")
+	}
+}
+
+func TestUpdateGQLFile(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	q := "/* Fetch a user */\nquery getUser { user { id } }"
+	if err := afero.WriteFile(fs, "/queries/getUser.gql", []byte(q), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	al, err := New(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = al.Update(nil, `query getUser { user { id email } }`, Metadata{}, "", true)
+	if !errors.Is(err, ErrSourceFile) {
+		t.Fatal("expected ErrSourceFile, got ", err)
+	}
+
+	if err := al.Rename("", "getUser", "", "fetchUser"); !errors.Is(err, ErrSourceFile) {
+		t.Fatal("expected ErrSourceFile renaming, got ", err)
+	}
+
+	if b, err := afero.ReadFile(fs, "/queries/getUser.gql"); err != nil || string(b) != q {
+		t.Fatalf("expected the file unchanged, got %q: %v", b, err)
+	}
+}
+
+func TestCompressExisting(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	al, err := New(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := al.SetSync(nil, `query getUser { user { id } }`, Metadata{}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	confs := []struct {
+		conf Config
+		file string
+		q    string
+	}{
+		{Config{Compress: true}, "/queries/getUser.yaml.gz", `query getUser { user { id email } }`},
+		{Config{Format: FormatJSON}, "/queries/getUser.json", `query getUser { user { id name } }`},
+	}
+
+	for _, v := range confs {
+		al, err := New(v.conf, fs)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := al.SetSync(nil, v.q, Metadata{}, "", WithOverwrite()); err != nil {
+			t.Fatal(err)
+		}
+
+		fi, err := afero.ReadDir(fs, "/queries")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(fi) != 1 || "/queries/"+fi[0].Name() != v.file {
+			t.Fatalf("expected only %s to be saved", v.file)
+		}
+
+		item, err := al.GetByName("getUser")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if q, _ := Normalize(v.q); item.Query != q {
+			t.Fatalf("expected the saved query, got %q", item.Query)
+		}
+	}
+}
+
+func TestCache(t *testing.T) {
+	var hits, misses int
+
+	conf := Config{
+		CacheSize: 2,
+		OnCache: func(name string, hit bool) {
+			if hit {
+				hits++
+			} else {
+				misses++
+			}
+		},
+	}
+
+	fs := afero.NewMemMapFs()
+
+	al, err := New(conf, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := al.SetSync(nil, `query getUser { user { id } }`, Metadata{}, "tenant1"); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := al.GetByName("tenant1.getUser"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if hits != 2 || misses != 1 {
+		t.Fatalf("expected 2 hits and 1 miss, got %d and %d", hits, misses)
+	}
+
+	// saving the query invalidates the item looked up with a different case
+	if _, err := al.GetByName("TENANT1.GETUSER"); err != nil {
+		t.Fatal(err)
+	}
+
+	q := `query getUser { user { id email } }`
+	if err := al.SetSync(nil, q, Metadata{}, "tenant1", WithOverwrite()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"tenant1.getUser", "TENANT1.GETUSER"} {
+		item, err := al.GetByName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(item.Query, "email") {
+			t.Fatalf("%s: expected the saved query, got %q", name, item.Query)
+		}
+	}
+
+	if err := al.Remove("tenant1", "getUser", false); err != nil {
+		t.Fatal(err)
+	}
+
+	if item, err := al.GetByName("tenant1.getUser"); err != nil || item.Name != "" {
+		t.Fatal("expected the removed item not to be returned from the cache")
+	}
+}
+
+func TestCacheRenamed(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	// the query name does not match the filename
+	err := afero.WriteFile(fs, "/queries/users.gql", []byte(`query getUsers { users { id } }`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	al, err := New(Config{CacheSize: 10}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if item, err := al.GetByName("users"); err != nil || item.Name != "getUsers" {
+		t.Fatal("unexpected item: ", item.Name, err)
+	}
+
+	al.invalidateCache(Item{Name: "getUsers"})
+
+	if n := al.cache.Len(); n != 0 {
+		t.Fatal("expected the item cached under the filename to be removed, got ", n)
+	}
+}
+
+func TestCacheStaleAdd(t *testing.T) {
+	al, err := NewReadOnly(Config{CacheSize: 10}, afero.NewMemMapFs())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a lookup that read the file before a save must not cache it after
+	_, _, gen := al.fromCache("getuser")
+	al.invalidateCache(Item{Name: "getUser"})
+	al.addCache("getuser", Item{Name: "getUser"}, gen)
+
+	if _, ok, _ := al.fromCache("getuser"); ok {
+		t.Fatal("expected the stale item not to be cached")
+	}
+}
+
+func TestSetFragment(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	al, err := New(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	frag := `fragment User on users { id email }`
+	if err := al.SetFragment("tenant1", "User", frag); err != nil {
+		t.Fatal(err)
+	}
+
+	if v, err := al.FragmentFetcher("tenant1")("User"); err != nil || v != frag {
+		t.Fatalf("expected the saved fragment, got %q: %v", v, err)
+	}
+
+	// queries saved later use the registered fragment
+	err = al.SetSync(nil, `query getUser { user { ...User } }
+	fragment User on users { id email }`, Metadata{}, "tenant1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = al.SetFragment("tenant1", "User", `fragment User on users { id }`)
+	if !errors.Is(err, ErrFragmentConflict) {
+		t.Fatal("expected ErrFragmentConflict, got ", err)
+	}
+
+	err = al.SetFragment("tenant1", "User", `fragment User on users { id }`, WithOverwrite())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	invalid := map[string]string{
+		"User":  `query getUser { user { id } }`,
+		"Other": `fragment User on users { id }`,
+		"Post":  `fragment Post on posts { id`,
+	}
+	for name, body := range invalid {
+		if err := al.SetFragment("", name, body); !errors.Is(err, ErrInvalidFragment) {
+			t.Fatalf("%s: expected ErrInvalidFragment, got %v", name, err)
+		}
+	}
+
+	if err := al.SetFragment("", "../User", frag); !errors.Is(err, ErrInvalidName) {
+		t.Fatal("expected ErrInvalidName, got ", err)
+	}
+}
+
+func TestLayered(t *testing.T) {
+	base := afero.NewMemMapFs()
+	top := afero.NewMemMapFs()
+
+	files := map[string]string{
+		"/queries/getUser.gql":  `query getUser { user { id } }`,
+		"/queries/getUsers.gql": `query getUsers { users { id } }`,
+		"/fragments/User.gql":   `fragment User on users { id }`,
+	}
+	for fn, v := range files {
+		if err := afero.WriteFile(base, fn, []byte(v), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the override shadows the base file with the same path
+	err := afero.WriteFile(top, "/queries/getUser.gql", []byte(`query getUser { user { id email } }`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	al, err := NewLayered(base, top)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := al.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(list) != 2 {
+		t.Fatal("expected the directories to be merged, got ", len(list))
+	}
+
+	item, err := al.GetByName("getUser")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(item.Query, "email") {
+		t.Fatal("expected the override to be read, got ", item.Query)
+	}
+
+	if _, err := al.FragmentFetcher("")("User"); err != nil {
+		t.Fatal("expected the base fragment to be read: ", err)
+	}
+
+	if err := al.SetSync(nil, `query getPosts { posts { id } }`, Metadata{}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, _ := afero.Exists(top, "/queries/getPosts.yaml"); !ok {
+		t.Fatal("expected the query to be saved to the top layer")
+	}
+
+	if ok, _ := afero.Exists(base, "/queries/getPosts.yaml"); ok {
+		t.Fatal("expected the base layer not to be written to")
+	}
+
+	if err := al.Remove("", "getUsers", false); err == nil {
+		t.Fatal("expected removing a query in the base layer to fail")
+	}
+
+	if _, err := NewLayered(); err == nil {
+		t.Fatal("expected an error with no layers")
+	}
+}
+
+func TestClose(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	al, err := New(Config{SaveWorkers: 2}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"getUser", "getUsers", "getPosts"} {
+		q := `query ` + name + ` { users { id } }`
+		if err := al.Set(nil, q, Metadata{}, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := al.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the queued items are saved before the workers stop
+	if n, err := al.Count(); err != nil || n != 3 {
+		t.Fatal("expected 3 queries saved, got ", n, err)
+	}
+
+	if err := al.Set(nil, `query getComments { comments { id } }`, Metadata{}, ""); !errors.Is(err, ErrReadOnly) {
+		t.Fatal("expected ErrReadOnly after close, got ", err)
+	}
+
+	if err := al.Remove("", "getUser", false); !errors.Is(err, ErrReadOnly) {
+		t.Fatal("expected ErrReadOnly after close, got ", err)
+	}
+
+	if err := al.Close(); err != nil {
+		t.Fatal("expected close to be idempotent: ", err)
+	}
+
+	// reads still work
+	if item, err := al.GetByName("getUser"); err != nil || item.Name != "getUser" {
+		t.Fatal("expected the saved item: ", item.Name, err)
+	}
+}
+
+func TestCloseConcurrent(t *testing.T) {
+	al, err := New(Config{SaveQueueSize: 1}, afero.NewMemMapFs())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			q := fmt.Sprintf(`query q%d { users { id } }`, i)
+			if err := al.Set(nil, q, Metadata{}, ""); err != nil &&
+				!errors.Is(err, ErrReadOnly) && !errors.Is(err, ErrQueueFull) {
+				t.Error(err)
+			}
+		}(i)
+	}
+
+	if err := al.Close(); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+}
+
+func TestSplitName(t *testing.T) {
+	tests := []struct {
+		v, ns, name string
+	}{
+		{"", "", ""},
+		{"getUser", "", "getUser"},
+		{"tenant1.getUser", "tenant1", "getUser"},
+		{"a.b.getUser", "a.b", "getUser"},
+		{".getUser", "", "getUser"},
+		{"tenant1.", "", ""},
+		{".", "", ""},
+		{"a..getUser", "a.", "getUser"},
+	}
+
+	for _, v := range tests {
+		ns, name := SplitName(v.v)
+		if ns != v.ns || name != v.name {
+			t.Errorf("%q: expected %q, %q got %q, %q", v.v, v.ns, v.name, ns, name)
+		}
+
+		// names split and joined again are unchanged
+		if name != "" && fileName(ns, name) != strings.TrimPrefix(v.v, ".") {
+			t.Errorf("%q: joined as %q", v.v, fileName(ns, name))
+		}
+	}
+}
+
+func TestFragmentName(t *testing.T) {
+	tests := []struct {
+		v, name string
+	}{
+		{"fragment User on users { id }", "User"},
+		{"fragment  User on users { id }", "User"},
+		{"fragment User_1 on users { id }", "User_1"},
+		{"fragment { id }", ""},
+		{"", ""},
+	}
+
+	for _, v := range tests {
+		if name := fragmentName(v.v); name != v.name {
+			t.Errorf("%q: expected %q got %q", v.v, v.name, name)
+		}
+	}
+}
+
+func TestSubscriptionMetadata(t *testing.T) {
+	for _, format := range []string{FormatYAML, FormatJSON} {
+		fs := afero.NewMemMapFs()
+
+		al, err := New(Config{Format: format}, fs)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var md Metadata
+		md.Subscription = &SubscriptionMetadata{HeartbeatSeconds: 15, MaxLifetimeSeconds: 3600}
+
+		if err := al.SetSync(nil, `subscription newUsers { users(limit: 5) { id } }`, md, ""); err != nil {
+			t.Fatal(err)
+		}
+		if err := al.SetSync(nil, `query getUser { user { id } }`, Metadata{}, ""); err != nil {
+			t.Fatal(err)
+		}
+
+		item, err := al.GetByName("newUsers")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if item.Heartbeat(time.Minute) != 15*time.Second || item.MaxLifetime(time.Hour*2) != time.Hour {
+			t.Fatalf("%s: unexpected subscription metadata: %+v", format, item.Metadata.Subscription)
+		}
+
+		item, err = al.GetByName("getUser")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if item.Heartbeat(time.Minute) != time.Minute || item.MaxLifetime(time.Hour) != time.Hour {
+			t.Fatalf("%s: expected the defaults", format)
+		}
+
+		b, err := afero.ReadFile(fs, "/queries/getUser."+format)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if strings.Contains(string(b), "subscription") {
+			t.Fatalf("%s: expected no subscription metadata to be saved: %s", format, b)
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	for _, format := range []string{FormatYAML, FormatJSON} {
+		fs := afero.NewMemMapFs()
+
+		al, err := New(Config{Format: format}, fs)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var md Metadata
+		md.RateLimit = &RateLimit{RequestsPerSecond: 10, Burst: 20}
+
+		if err := al.SetSync(nil, `query getUsers { users { id } }`, md, ""); err != nil {
+			t.Fatal(err)
+		}
+		if err := al.SetSync(nil, `query getUser { user { id } }`, Metadata{}, ""); err != nil {
+			t.Fatal(err)
+		}
+
+		item, err := al.GetByName("getUsers")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if rl, ok := item.RateLimit(); !ok || rl != (RateLimit{RequestsPerSecond: 10, Burst: 20}) {
+			t.Fatalf("%s: unexpected rate limit: %+v", format, item.Metadata.RateLimit)
+		}
+
+		item, err = al.GetByName("getUser")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if rl, ok := item.RateLimit(); ok || rl != (RateLimit{}) {
+			t.Fatalf("%s: expected no rate limit, got %+v", format, rl)
+		}
+
+		b, err := afero.ReadFile(fs, "/queries/getUser."+format)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if strings.Contains(string(b), "rate_limit") {
+			t.Fatalf("%s: expected no rate limit to be saved: %s", format, b)
+		}
+	}
+
+	item := Item{Metadata: Metadata{RateLimit: &RateLimit{Burst: 5}}}
+	if _, ok := item.RateLimit(); ok {
+		t.Fatal("expected no rate limit without requests per second")
+	}
+}
+
+func TestEmptyFragment(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	if err := afero.WriteFile(fs, "/fragments/User.gql", []byte(" \n\t\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	al, err := New(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = al.FragmentFetcher("")("User")
+	if !errors.Is(err, ErrEmptyFragment) || !strings.Contains(err.Error(), "User") {
+		t.Fatal("expected ErrEmptyFragment naming the fragment, got ", err)
+	}
+
+	// a blank fragment used by another fragment
+	err = afero.WriteFile(fs, "/fragments/Post.gql", []byte(`fragment Post on posts { id author { ...User } }`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := al.FragmentFetcher("")("Post"); !errors.Is(err, ErrEmptyFragment) {
+		t.Fatal("expected ErrEmptyFragment, got ", err)
+	}
+
+	item := Item{Name: "getUser", Query: `query getUser { user { ...Post } }`,
+		frags: []Frag{{Name: "Post", Value: "  "}}}
+
+	if _, err := al.saveItem(item, ""); !errors.Is(err, ErrEmptyFragment) {
+		t.Fatal("expected ErrEmptyFragment, got ", err)
+	}
+
+	if ok, _ := afero.Exists(fs, "/queries/getUser.yaml"); ok {
+		t.Fatal("expected nothing to be written")
+	}
+}
+
+func TestNamespaceMetadata(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	files := map[string]string{
+		"/queries/tenant1._namespace.yaml": "order:\n  var: order\n  values: [asc, desc]\nallowed_vars: [id]\n",
+		"/queries/tenant1.getUsers.yaml":   "namespace: tenant1\nname: getUsers\nquery: \"query getUsers { users { id } }\"\n",
+		"/queries/tenant1.getPosts.yaml":   "namespace: tenant1\nname: getPosts\nquery: \"query getPosts { posts { id } }\"\norder:\n  var: sort\n",
+		"/queries/tenant1.getUser.gql":     "query getUser { user { id } }",
+		"/queries/getComments.yaml":        "name: getComments\nquery: \"query getComments { comments { id } }\"\n",
+	}
+	for fn, v := range files {
+		if err := afero.WriteFile(fs, fn, []byte(v), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	al, err := New(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := al.LoadMap()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(list) != 4 {
+		t.Fatal("expected the namespace file not to be loaded as a query, got ", len(list))
+	}
+
+	for _, k := range []string{"tenant1.getusers", "tenant1.getuser"} {
+		md := list[k].Metadata
+		if md.Order.Var != "order" || len(md.Order.Values) != 2 || len(md.AllowedVars) != 1 {
+			t.Fatalf("%s: expected the namespace metadata, got %+v", k, md)
+		}
+	}
+
+	// the item metadata wins
+	if md := list["tenant1.getposts"].Metadata; md.Order.Var != "sort" || len(md.AllowedVars) != 1 {
+		t.Fatalf("expected the item order, got %+v", md)
+	}
+
+	if md := list["getcomments"].Metadata; md.Order.Var != "" {
+		t.Fatalf("expected no inherited metadata, got %+v", md)
+	}
+
+	if item, err := al.GetByName("tenant1.getUsers"); err != nil || item.Metadata.Order.Var != "order" {
+		t.Fatal("expected the namespace metadata: ", item.Metadata, err)
+	}
+
+	// the inherited metadata is not written to the query file
+	err = al.Update(nil, `query getUsers { users { id name } }`, Metadata{}, "tenant1", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := afero.ReadFile(fs, "/queries/tenant1.getUsers.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(string(b), "order") {
+		t.Fatalf("expected the namespace metadata not to be saved: %s", b)
+	}
+}
+
+func TestNamespaceMetadataIndex(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	files := map[string]string{
+		"/queries/tenant1._namespace.yaml": "tags: [public]\n",
+		"/queries/tenant1.getUsers.yaml":   "namespace: tenant1\nname: getUsers\nquery: \"query getUsers { users { id } }\"\n",
+	}
+	for fn, v := range files {
+		if err := afero.WriteFile(fs, fn, []byte(v), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	al, err := New(Config{EnableIndex: true}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := al.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	err = al.SetSync(nil, `query getUsers { users { id name } }`, Metadata{}, "tenant1", WithOverwrite())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	item, err := al.GetByName("tenant1.getUsers")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(item.Query, "name") || len(item.Metadata.Tags) != 1 {
+		t.Fatalf("expected the saved query with the namespace metadata, got %+v", item)
+	}
+}
+
+func TestReadOnlyView(t *testing.T) {
+	al, err := New(Config{CacheSize: 10}, afero.NewMemMapFs())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if al.IsReadOnly() {
+		t.Fatal("expected the list to be writable")
+	}
+
+	ro := al.ReadOnly()
+	if !ro.IsReadOnly() {
+		t.Fatal("expected the view to be read-only")
+	}
+
+	if err := ro.Set(nil, `query getUser { user { id } }`, Metadata{}, ""); !errors.Is(err, ErrReadOnly) {
+		t.Fatal("expected ErrReadOnly, got ", err)
+	}
+
+	if err := al.SetSync(nil, `query getUser { user { id } }`, Metadata{}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ro.GetByName("getUser"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the view sees the saves to the list
+	if err := al.SetSync(nil, `query getUser { user { id email } }`, Metadata{}, "", WithOverwrite()); err != nil {
+		t.Fatal(err)
+	}
+
+	if item, err := ro.GetByName("getUser"); err != nil || !strings.Contains(item.Query, "email") {
+		t.Fatal("expected the saved query: ", item.Query, err)
+	}
+
+	if ro1, err := NewReadOnly(Config{}, afero.NewMemMapFs()); err != nil || !ro1.IsReadOnly() {
+		t.Fatal("expected NewReadOnly to be read-only: ", err)
+	}
+
+	if err := al.Close(); err != nil || !al.IsReadOnly() {
+		t.Fatal("expected a closed list to be read-only: ", err)
+	}
+}
+
+func TestLoadGlob(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	al, err := New(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queries := []struct{ ns, q string }{
+		{"admin", `query getUsers { users { id } }`},
+		{"admin", `mutation addUser { users(insert: $data) { id } }`},
+		{"public", `query getUser { user { id } }`},
+		{"", `query getPosts { posts { id } }`},
+	}
+	for _, v := range queries {
+		if err := al.SetSync(nil, v.q, Metadata{}, v.ns); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		pattern string
+		n       int
+	}{
+		{"admin.get*", 1},
+		{"*.getUser*", 2},
+		{"get*", 1},
+		{"*", 4},
+		{"*.*", 3},
+		{"admin.*.yaml", 0},
+	}
+
+	for _, v := range tests {
+		items, err := al.LoadGlob(v.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(items) != v.n {
+			t.Errorf("%s: expected %d items, got %d", v.pattern, v.n, len(items))
+		}
+	}
+
+	if _, err := al.LoadGlob("admin.["); !errors.Is(err, filepath.ErrBadPattern) {
+		t.Fatal("expected ErrBadPattern, got ", err)
+	}
+}
+
+func TestItemSource(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	al, err := New(Config{Compress: true}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	item, err := al.Validate(nil, `query getUser { user { id } }`, Metadata{}, "tenant1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if item.Source() != "" {
+		t.Fatal("expected no source for an item not read from a file, got ", item.Source())
+	}
+
+	if err := al.SetSync(nil, `query getUser { user { id } }`, Metadata{}, "tenant1"); err != nil {
+		t.Fatal(err)
+	}
+
+	q := `query getUsers { users { id } } query getPosts { posts { id } }`
+	if err := afero.WriteFile(fs, "/queries/lists.gql", []byte(q), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := al.LoadMap()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sources := map[string]string{
+		"tenant1.getuser": "/queries/tenant1.getUser.yaml.gz",
+		"getusers":        "/queries/lists.gql",
+		"getposts":        "/queries/lists.gql",
+	}
+	for k, v := range sources {
+		if list[k].Source() != v {
+			t.Errorf("%s: expected source %s, got %s", k, v, list[k].Source())
+		}
+	}
+
+	b, err := afero.ReadFile(fs, "/queries/tenant1.getUser.yaml.gz")
+	if err == nil {
+		b, err = decompressFile("/queries/tenant1.getUser.yaml.gz", b)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(string(b), "/queries") {
+		t.Fatalf("expected the source not to be saved: %s", b)
+	}
+}
+
+func TestLoadBundle(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	bundle := `
+query getUser { user { ...User } }
+
+query getUsers { users { ...User posts { ...Post } } }
+
+mutation addPost { posts(insert: $data) { id } }
+
+fragment User on users { id email }
+fragment Post on posts { id title }
+`
+	if err := afero.WriteFile(fs, "/bundle.graphql", []byte(bundle), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	al, err := New(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items, err := al.LoadBundle("/bundle.graphql")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(items) != 3 {
+		t.Fatal("expected an item per operation, got ", len(items))
+	}
+
+	frags := map[string]int{"getUser": 1, "getUsers": 2, "addPost": 0}
+	for _, v := range items {
+		if len(v.frags) != frags[v.Name] {
+			t.Errorf("%s: expected %d fragments, got %d", v.Name, frags[v.Name], len(v.frags))
+		}
+		if v.Source() != "/bundle.graphql" {
+			t.Errorf("%s: unexpected source %s", v.Name, v.Source())
+		}
+	}
+
+	if items[2].OpType != OpMutation {
+		t.Fatal("expected a mutation, got ", items[2].OpType)
+	}
+
+	dup := `query getUser { user { id } } query getUser { user { email } }`
+	if err := afero.WriteFile(fs, "/dup.graphql", []byte(dup), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := al.LoadBundle("/dup.graphql"); err == nil {
+		t.Fatal("expected an error for operations with the same name")
+	}
+}
+
+func TestEmptyVars(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	al, err := New(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vars := map[string]string{
+		"getUser":  "",
+		"getUsers": `{}`,
+		"getPosts": `null`,
+		"getTags":  ` { } `,
+	}
+
+	for name, v := range vars {
+		var vb []byte
+		if v != "" {
+			vb = []byte(v)
+		}
+
+		if err := al.SetSync(vb, `query `+name+` { users { id } }`, Metadata{}, ""); err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := afero.ReadFile(fs, "/queries/"+name+".yaml")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if strings.Contains(string(b), "vars:") {
+			t.Errorf("%s: expected no vars to be saved: %s", name, b)
+		}
+	}
+}
+
+func TestDuplicateOperation(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	if err := afero.WriteFile(fs, "/queries/GetUser.gql",
+		[]byte(`query GetUser { user { id } }`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := afero.WriteFile(fs, "/queries/GetUser.yaml",
+		[]byte("name: GetUser\nquery: query GetUser { user { id email } }\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	al, err := New(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = al.Load()
+	if !errors.Is(err, ErrDuplicateOperation) {
+		t.Fatal("expected ErrDuplicateOperation, got ", err)
+	}
+
+	for _, fn := range []string{"GetUser.gql", "GetUser.yaml"} {
+		if !strings.Contains(err.Error(), fn) {
+			t.Errorf("expected the error to name %s: %s", fn, err)
+		}
+	}
+
+	if _, err := al.Search("get"); !errors.Is(err, ErrDuplicateOperation) {
+		t.Fatal("expected Search to fail with ErrDuplicateOperation, got ", err)
+	}
+
+	policies := map[string]string{
+		DuplicateFirstWins: "/queries/GetUser.gql",
+		DuplicateLastWins:  "/queries/GetUser.yaml",
+	}
+
+	for p, src := range policies {
+		var logs bytes.Buffer
+		al, err := New(Config{OnDuplicate: p, Log: log.New(&logs, "", 0)}, fs)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		items, err := al.Load()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(items) != 1 || items[0].Source() != src {
+			t.Errorf("%s: expected the item from %s, got %v", p, src, items)
+		}
+
+		if !strings.Contains(logs.String(), "using the one in "+src) {
+			t.Errorf("%s: expected the dropped duplicate to be logged: %s", p, logs.String())
+		}
+
+		items, err = al.LoadGlob("Get*")
+		if err != nil || len(items) != 1 || items[0].Source() != src {
+			t.Errorf("%s: expected LoadGlob to return the item from %s, got %v: %v", p, src, items, err)
+		}
+	}
+
+	if _, err := New(Config{OnDuplicate: "random"}, fs); err == nil {
+		t.Fatal("expected an error for an unknown policy")
+	}
+}
+
+func TestFragmentNamer(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	nested := func(namespace, name string) string {
+		if namespace == "" {
+			namespace = "default"
+		}
+		return filepath.Join(namespace, name+".gql")
+	}
+
+	al, err := New(Config{FragmentNamer: nested}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := `query getUser { user { ...User } } fragment User on users { id email }`
+	if err := al.SetSync(nil, q, Metadata{}, "tenant1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := al.SetFragment("", "Post", `fragment Post on posts { id title }`); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, fn := range []string{"/fragments/tenant1/User.gql", "/fragments/default/Post.gql"} {
+		if ok, _ := afero.Exists(fs, fn); !ok {
+			t.Fatal("expected the fragment file ", fn)
+		}
+	}
+
+	if v, err := al.FragmentFetcher("tenant1")("User"); err != nil || !strings.Contains(v, "email") {
+		t.Fatal("expected the nested fragment to be read: ", v, err)
+	}
+
+	var b bytes.Buffer
+	if err := al.Export(&b, FormatYAML); err != nil {
+		t.Fatal(err)
+	}
+
+	al1, err := New(Config{}, afero.NewMemMapFs())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := al1.Import(&b, FormatYAML); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := al1.FragmentFetcher("tenant1")("User"); err != nil {
+		t.Fatal("expected the fragment to be exported: ", err)
+	}
+
+	outside := func(namespace, name string) string {
+		return filepath.Join("..", "queries", name+".gql")
+	}
+
+	al2, err := New(Config{FragmentNamer: outside}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q = `query getPosts { posts { ...Post } } fragment Post on posts { id }`
+	if err := al2.SetSync(nil, q, Metadata{}, ""); !errors.Is(err, ErrInvalidFragment) {
+		t.Fatal("expected ErrInvalidFragment for a path outside the fragment directory, got ", err)
+	}
+
+	if ok, _ := afero.Exists(fs, "/queries/getPosts.yaml"); ok {
+		t.Fatal("expected the query not to be saved")
+	}
+}
+
+// cancelFs cancels the context when the named file is opened
+type cancelFs struct {
+	afero.Fs
+	name   string
+	cancel func()
+}
+
+func (fs *cancelFs) Open(name string) (afero.File, error) {
+	if name == fs.name {
+		fs.cancel()
+	}
+	return fs.Fs.Open(name)
+}
+
+func TestLoadCtx(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	fs := &cancelFs{Fs: afero.NewMemMapFs(), name: "/queries/getPosts.yaml", cancel: cancel}
+
+	al, err := New(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, q := range []string{
+		`query getPosts { posts { id } }`,
+		`query getTags { tags { id } }`,
+		`query getUsers { users { id } }`,
+	} {
+		if err := al.SetSync(nil, q, Metadata{}, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var n int
+	al.conf.OnLoad = func(count int, _ time.Duration) { n++ }
+
+	if _, err := al.LoadCtx(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatal("expected context.Canceled, got ", err)
+	}
+
+	if n != 0 {
+		t.Fatal("expected OnLoad not to be called")
+	}
+
+	if _, err := al.GetCtx(ctx, "/queries/getUsers.yaml"); !errors.Is(err, context.Canceled) {
+		t.Fatal("expected context.Canceled, got ", err)
+	}
+
+	items, err := al.LoadCtx(context.Background())
+	if err != nil || len(items) != 3 {
+		t.Fatal("expected all the items, got ", len(items), err)
+	}
+}
+
+// slowWriteFs writes files in two halves with a pause in between
+type slowWriteFs struct {
+	afero.Fs
+}
+
+func (fs slowWriteFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	f, err := fs.Fs.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return slowWriteFile{f}, nil
+}
+
+type slowWriteFile struct {
+	afero.File
+}
+
+func (f slowWriteFile) Write(b []byte) (int, error) {
+	n, err := f.File.Write(b[:len(b)/2])
+	if err != nil {
+		return n, err
+	}
+	time.Sleep(time.Millisecond)
+
+	n1, err := f.File.Write(b[len(b)/2:])
+	return n + n1, err
+}
+
+func TestConcurrentSetGet(t *testing.T) {
+	// without atomic renames the files are written in place
+	fs := slowWriteFs{noRenameFs{afero.NewMemMapFs()}}
+
+	al, err := New(Config{SaveWorkers: 4}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer al.Close()
+
+	queries := []string{
+		`query getUser { user { id } }`,
+		`query getUser { user { id email full_name avatar created_at updated_at } }`,
+	}
+
+	if err := al.SetSync(nil, queries[0], Metadata{}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				items, err := al.Get("/queries/getUser.yaml")
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if len(items) != 1 || items[0].Name != "getUser" {
+					t.Error("read a partially written file: ", items)
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 50; i++ {
+		err := al.SetSync(nil, queries[i%2], Metadata{}, "", WithOverwrite())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	close(done)
+	wg.Wait()
+}
+
+func TestSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	qdir := filepath.Join(dir, "queries")
+	shared := filepath.Join(dir, "shared")
+
+	for _, d := range []string{qdir, filepath.Join(shared, "dir.yaml")} {
+		if err := os.MkdirAll(d, 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	q := "name: getUser\nquery: query getUser { user { id } }\n"
+	if err := os.WriteFile(filepath.Join(shared, "getUser.yaml"), []byte(q), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	links := map[string]string{
+		"getUser.yaml": filepath.Join(shared, "getUser.yaml"),
+		"dir.yaml":     filepath.Join(shared, "dir.yaml"),
+		"loop1.yaml":   "loop2.yaml",
+		"loop2.yaml":   "loop1.yaml",
+		"broken.yaml":  "missing.yaml",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(qdir, name)); err != nil {
+			t.Skip("symlinks not supported: ", err)
+		}
+	}
+
+	var logs bytes.Buffer
+	fs := afero.NewBasePathFs(afero.NewOsFs(), dir)
+
+	al, err := NewReadOnly(Config{Log: log.New(&logs, "", 0)}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items, err := al.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(items) != 1 || items[0].Name != "getUser" {
+		t.Fatal("expected the symlinked query, got ", items)
+	}
+
+	if n := strings.Count(logs.String(), "skipping symlink"); n != 3 {
+		t.Fatalf("expected the loops and the broken link to be logged: %s", logs.String())
+	}
+
+	if _, err := al.Get("/queries/getUser.yaml"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMultiDocYaml(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	doc := `name: GetUser
+query: query GetUser { user { id } }
+---
+namespace: admin
+name: GetUsers
+query: query GetUsers { users { id } }
+---
+name: CreateUser
+query: mutation CreateUser { user { id } }
+---
+`
+	if err := afero.WriteFile(fs, "/queries/GetUser.yaml", []byte(doc), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	al, err := New(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items, err := al.Get("/queries/GetUser.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 3 {
+		t.Fatalf("expected 3 items, got %d", len(items))
+	}
+
+	list, err := al.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := make(map[string]string)
+	for _, v := range list {
+		keys[fileName(v.Namespace, v.Name)] = v.OpType
+	}
+	exp := map[string]string{
+		"GetUser":        "query",
+		"admin.GetUsers": "query",
+		"CreateUser":     "mutation",
+	}
+	if !reflect.DeepEqual(keys, exp) {
+		t.Fatalf("expected %v, got %v", exp, keys)
+	}
+
+	err = al.SetSync(nil, `query GetUser { user { id email } }`, Metadata{}, "", WithOverwrite())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items, err = al.Get("/queries/GetUser.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 3 {
+		t.Fatalf("expected the other operations to be kept, got %d items", len(items))
+	}
+	for _, v := range items {
+		if v.Name == "GetUser" && !strings.Contains(v.Query, "email") {
+			t.Fatal("expected the query to be updated: ", v.Query)
+		}
+	}
+}
+
+func TestSkipInvalid(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	files := map[string]string{
+		"/queries/GetUser.gql":    `query GetUser { user { id } }`,
+		"/queries/GetPosts.yaml":  "name: GetPosts\nquery: query GetPosts { posts { id } }\n",
+		"/queries/Broken.yaml":    "name: Broken\nquery: [\n",
+		"/queries/BadVars.gql":    "variables { \"id\": }\nquery BadVars { users { id } }",
+		"/queries/GetTags.gql":    `query GetTags { tags { id } }`,
+		"/queries/GetInvalid.gql": `query GetInvalid { tags { id }`,
+	}
+	for fn, v := range files {
+		if err := afero.WriteFile(fs, fn, []byte(v), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	al, err := New(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if items, err := al.Load(); err == nil || items != nil {
+		t.Fatal("expected Load to fail by default")
+	}
+
+	var logs bytes.Buffer
+	al, err = New(Config{SkipInvalid: true, Log: log.New(&logs, "", 0)}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items, err := al.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 3 {
+		t.Fatal("expected the 3 valid items, got ", len(items))
+	}
+
+	for _, fn := range []string{"Broken.yaml", "BadVars.gql", "GetInvalid.gql"} {
+		if !strings.Contains(logs.String(), fn) {
+			t.Errorf("expected the skipped file %s to be logged: %s", fn, logs.String())
+		}
+	}
+
+	var n int
+	err = al.Range(func(Item) error {
+		n++
+		return nil
+	})
+	if err != nil || n != 3 {
+		t.Fatal("expected Range to skip the invalid files: ", n, err)
+	}
+
+	loads := map[string]func() ([]Item, error){
+		"LoadNamespace": func() ([]Item, error) { return al.LoadNamespace("") },
+		"LoadGlob":      func() ([]Item, error) { return al.LoadGlob("*") },
+		"Search":        func() ([]Item, error) { return al.Search("") },
+	}
+	for k, load := range loads {
+		if items, err := load(); err != nil || len(items) != 3 {
+			t.Errorf("%s: expected the invalid files to be skipped: %d: %v", k, len(items), err)
+		}
+	}
+
+	// the unclosed query is not parsed when reading the metadata
+	meta, err := al.LoadMeta()
+	if err != nil || len(meta) != 4 {
+		t.Fatal("expected LoadMeta to skip the invalid files: ", len(meta), err)
+	}
+}
+
+func TestAnonymousOperation(t *testing.T) {
+	tests := []struct {
+		fn, data string
+		err      error
+	}{
+		{"GetUser.gql", `query GetUser { user { id } }`, nil},
+		{"GetUser.yaml", "name: GetUser\nquery: query getuser { user { id } }\n", nil},
+		{"GetUser.gql", `query { user { id } }`, ErrAnonymousOperation},
+		{"GetUser.gql", `{ user { id } }`, ErrAnonymousOperation},
+		{"GetUser.yaml", "name: GetUser\nquery: query { user { id } }\n", ErrAnonymousOperation},
+		{"GetUser.json", `{"name": "GetUser", "query": "mutation { user { id } }"}`, ErrAnonymousOperation},
+		{"GetUser.yaml", "name: GetUser\nquery: query GetUsers { users { id } }\n", ErrInvalidName},
+		{"GetUser.gql", "query GetUser { user { id } }\nquery { users { id } }", ErrAnonymousOperation},
+	}
+
+	for _, tt := range tests {
+		fs := afero.NewMemMapFs()
+
+		if err := afero.WriteFile(fs, "/queries/"+tt.fn, []byte(tt.data), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		al, err := New(Config{}, fs)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = al.Load()
+		if tt.err == nil && err != nil {
+			t.Fatalf("%s: %s", tt.data, err)
+		}
+		if tt.err != nil && !errors.Is(err, tt.err) {
+			t.Fatalf("%s: expected %s, got %v", tt.data, tt.err, err)
+		}
+
+		if _, err := al.LoadMeta(); tt.err == ErrAnonymousOperation && !errors.Is(err, tt.err) {
+			t.Fatalf("%s: expected LoadMeta to fail with %s, got %v", tt.data, tt.err, err)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package allow
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// GetByHash returns the item with the query matching the hex encoded sha256
+// hash of an automatic persisted query (APQ). The hashes are read with the
+// items on the first call (or by Load) and kept updated as items are saved,
+// reading them leaves the index and cache as they are. The APQ protocol
+// always uses sha256 so the lookups do too and not the content hash
+// algorithm (Config.Hasher), ErrInvalidHash is returned for other hashes.
+func (al *List) GetByHash(hash string) (Item, error) {
+	hash = strings.ToLower(hash)
+
+	if b, err := hex.DecodeString(hash); err != nil || len(b) != sha256.Size {
+		return Item{}, fmt.Errorf("%w: %s", ErrInvalidHash, hash)
+	}
+
+	al.indexMu.RLock()
+	loaded := al.hashes != nil
+	al.indexMu.RUnlock()
+
+	if !loaded {
+		items, err := al.loadFiles(nil)
+		if err != nil {
+			return Item{}, err
+		}
+		al.setHashes(items)
+	}
+
+	al.indexMu.RLock()
+	key, ok := al.hashes[hash]
+	al.indexMu.RUnlock()
+
+	if !ok {
+		return Item{}, fmt.Errorf("%w: hash %s", ErrNotFound, hash)
+	}
+
+	item, err := al.GetByName(key)
+	if err != nil {
+		return item, err
+	}
+	if item.Metadata.APQHash == "" {
+		item.Metadata.APQHash = hash
+	}
+	return item, nil
+}
+
+// apqHash returns the hex encoded sha256 hash of the normalized query
+func apqHash(query string) string {
+	if v, err := Normalize(query); err == nil {
+		query = v
+	}
+	h := sha256.Sum256([]byte(query))
+	return hex.EncodeToString(h[:])
+}
+
+// itemHash returns the saved hash of the item or the hash of its query
+// for items saved without one (.gql files)
+func itemHash(item Item) string {
+	if item.Metadata.APQHash != "" {
+		return item.Metadata.APQHash
+	}
+	return apqHash(item.Query)
+}
+
+func (al *List) setHashes(items []Item) {
+	hashes := make(map[string]string, len(items))
+	for _, v := range items {
+		hashes[itemHash(v)] = fileName(v.Namespace, v.Name)
+	}
+
+	al.indexMu.Lock()
+	al.hashes = hashes
+	al.indexMu.Unlock()
+}
+
+func (al *List) updateHashes(item Item, remove bool) {
+	al.indexMu.Lock()
+	defer al.indexMu.Unlock()
+
+	if al.hashes == nil {
+		return
+	}
+
+	key := fileName(item.Namespace, item.Name)
+	for k, v := range al.hashes {
+		if strings.EqualFold(v, key) {
+			delete(al.hashes, k)
+		}
+	}
+
+	if !remove {
+		al.hashes[itemHash(item)] = key
+	}
+}
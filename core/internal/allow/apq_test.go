@@ -0,0 +1,162 @@
+package allow
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"hash/fnv"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+func sha256Hex(v string) string {
+	h := sha256.Sum256([]byte(v))
+	return hex.EncodeToString(h[:])
+}
+
+func TestGetByHash(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	q := `query getUser { user { id } }`
+	if err := afero.WriteFile(fs, "/queries/getUser.gql", []byte(q), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	al, err := New(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// items in .gql files are hashed when loaded
+	nq, _ := Normalize(q)
+	item, err := al.GetByHash(sha256Hex(nq))
+	if err != nil || item.Name != "getUser" {
+		t.Fatal("expected the .gql item: ", item.Name, err)
+	}
+
+	q1 := `query getUsers {
+		users { id }
+	}`
+	if err := al.SetSync(nil, q1, Metadata{}, "tenant1"); err != nil {
+		t.Fatal(err)
+	}
+
+	nq1, _ := Normalize(q1)
+	item, err = al.GetByHash(sha256Hex(nq1))
+	if err != nil || item.Name != "getUsers" || item.Namespace != "tenant1" {
+		t.Fatal("expected the saved item: ", item.Name, err)
+	}
+
+	if item.Metadata.APQHash != sha256Hex(nq1) {
+		t.Fatal("expected the hash to be saved, got ", item.Metadata.APQHash)
+	}
+
+	// the old hash is dropped when the query is replaced
+	q2 := `query getUsers { users { id email } }`
+	if err := al.SetSync(nil, q2, Metadata{}, "tenant1", WithOverwrite()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := al.GetByHash(sha256Hex(nq1)); !errors.Is(err, ErrNotFound) {
+		t.Fatal("expected ErrNotFound for the replaced query, got ", err)
+	}
+
+	nq2, _ := Normalize(q2)
+	if item, err := al.GetByHash(sha256Hex(nq2)); err != nil || item.Name != "getUsers" {
+		t.Fatal("expected the replaced item: ", item.Name, err)
+	}
+
+	if err := al.Remove("tenant1", "getUsers", false); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := al.GetByHash(sha256Hex(nq2)); !errors.Is(err, ErrNotFound) {
+		t.Fatal("expected ErrNotFound for the removed query, got ", err)
+	}
+}
+
+func TestGetByHashNoLoad(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	q := `query getUser { user { id } }`
+	if err := afero.WriteFile(fs, "/queries/getUser.gql", []byte(q), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var loads int
+	al, err := New(Config{EnableIndex: true, OnLoad: func(int, time.Duration) { loads++ }}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := al.GetByHash(sha256Hex("query getUsers { users { id } }")); !errors.Is(err, ErrNotFound) {
+		t.Fatal("expected ErrNotFound, got ", err)
+	}
+
+	if loads != 0 || al.index != nil {
+		t.Fatalf("expected the hashes to be read without loading the list: %d loads", loads)
+	}
+}
+
+func TestHasher(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	fnv64a := func() hash.Hash { return fnv.New64a() }
+
+	if _, err := New(Config{Hasher: fnv64a}, fs); err == nil {
+		t.Fatal("expected an error for a hasher without an algorithm name")
+	}
+
+	al, err := New(Config{Hasher: fnv64a, HashAlgorithm: "fnv64a"}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := `query GetUser { user { id } }`
+	if err := al.SetSync(nil, query, Metadata{}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	item, err := al.GetByName("GetUser")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	md := item.Metadata
+	if md.HashAlgorithm != "fnv64a" || len(md.Hash) != 16 {
+		t.Fatalf("expected a fnv64a content hash, got %s %q", md.HashAlgorithm, md.Hash)
+	}
+
+	if len(md.APQHash) != 64 {
+		t.Fatal("expected the APQ hash to be sha256: ", md.APQHash)
+	}
+
+	if v, err := al.GetByHash(md.APQHash); err != nil || v.Name != "GetUser" {
+		t.Fatal("expected the item by its APQ hash: ", err)
+	}
+
+	if _, err := al.GetByHash(md.Hash); !errors.Is(err, ErrInvalidHash) {
+		t.Fatal("expected ErrInvalidHash for a fnv64a hash, got ", err)
+	}
+
+	// the hash saved with another algorithm is not a name collision
+	al, err = New(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := al.SetSync(nil, query, Metadata{}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if item, err = al.GetByName("GetUser"); err != nil {
+		t.Fatal(err)
+	}
+
+	md = item.Metadata
+	if md.HashAlgorithm != "sha256" || md.Hash != al.contentHash(item.Query, "") {
+		t.Fatalf("expected the sha256 content hash, got %s %q", md.HashAlgorithm, md.Hash)
+	}
+}
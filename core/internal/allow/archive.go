@@ -0,0 +1,259 @@
+package allow
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+const (
+	archiveQueryDir    = "queries"
+	archiveFragmentDir = "fragments"
+)
+
+// Archive writes the files in the query and fragment directories to a tar
+// archive with the paths queries/<file> and fragments/<file>, the fragments
+// in subdirectories (like scoped fragments) keep their relative paths. The
+// archive is gzipped when Config.Compress is set. Only regular files are
+// added, symlinks and other file types are skipped.
+func (al *List) Archive(w io.Writer) error {
+	var gz *gzip.Writer
+
+	if al.conf.Compress {
+		gz = gzip.NewWriter(w)
+		w = gz
+	}
+	tw := tar.NewWriter(w)
+
+	dirs := []struct {
+		kind, name string
+		nested     bool
+	}{
+		{KindQuery, archiveQueryDir, false},
+		{KindFragment, archiveFragmentDir, true},
+	}
+
+	for _, d := range dirs {
+		if err := al.archiveDir(tw, d.kind, d.name, d.nested); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("allow list: %w", err)
+	}
+
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return fmt.Errorf("allow list: %w", err)
+		}
+	}
+	return nil
+}
+
+// archiveDir adds the files of the kind to the archive under name, those
+// in subdirectories when nested keeping their relative paths
+func (al *List) archiveDir(tw *tar.Writer, kind, name string, nested bool) error {
+	fi, err := al.listFiles(kind, nested)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range fi {
+		if f.link {
+			continue
+		}
+
+		b, err := al.readRawFile(f.path)
+		if err != nil {
+			return fmt.Errorf("allow list: %w", err)
+		}
+
+		hdr := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     path.Join(name, f.rel),
+			Mode:     0600,
+			Size:     int64(len(b)),
+			ModTime:  f.ModTime,
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("allow list: %w", err)
+		}
+		if _, err := tw.Write(b); err != nil {
+			return fmt.Errorf("allow list: %w", err)
+		}
+	}
+	return nil
+}
+
+// Unarchive restores the files in an archive written by Archive (gzipped
+// or not) to the query and fragment directories replacing the files with
+// the same names. Entries that are not regular files or are outside the
+// queries and fragments directories of the archive are skipped.
+func (al *List) Unarchive(r io.Reader) error {
+	if al.IsReadOnly() {
+		return ErrReadOnly
+	}
+
+	if err := al.extract(r); err != nil {
+		return err
+	}
+
+	// the restored files replace the items read before
+	if al.conf.EnableIndex {
+		_, err := al.Load()
+		return err
+	}
+	al.purgeCache()
+	al.resetLibrary()
+	return nil
+}
+
+// NewFromTarGz returns a read-only allow list with the files in an archive
+// written by Archive (gzipped or not) kept in memory. The queries are read
+// from the archive files when looked up or loaded.
+func NewFromTarGz(r io.Reader) (*List, error) {
+	fs := afero.NewMemMapFs()
+
+	al, err := NewReadOnly(Config{}, fs)
+	if err != nil {
+		return nil, err
+	}
+
+	_ = fs.MkdirAll(al.conf.QueryDir, os.ModePerm)
+	_ = fs.MkdirAll(al.conf.FragmentDir, os.ModePerm)
+
+	if err := al.extract(r); err != nil {
+		return nil, err
+	}
+	return al, nil
+}
+
+// NewFromZip returns a read-only allow list with the files in the queries
+// and fragments directories of a zip archive kept in memory. Entries that
+// are not regular files or are outside those directories are skipped.
+func NewFromZip(r io.ReaderAt, size int64) (*List, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("allow list: %w", err)
+	}
+
+	fs := afero.NewMemMapFs()
+
+	al, err := NewReadOnly(Config{}, fs)
+	if err != nil {
+		return nil, err
+	}
+
+	_ = fs.MkdirAll(al.conf.QueryDir, os.ModePerm)
+	_ = fs.MkdirAll(al.conf.FragmentDir, os.ModePerm)
+
+	for _, f := range zr.File {
+		fn := al.archivePath(f.Name, f.Mode().IsRegular())
+		if fn == "" {
+			continue
+		}
+
+		b, err := readZipFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("allow list: %s: %w", f.Name, err)
+		}
+
+		if err := al.writeFile(fn, b); err != nil {
+			return nil, fmt.Errorf("allow list: %w", err)
+		}
+	}
+	return al, nil
+}
+
+func readZipFile(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	return io.ReadAll(rc)
+}
+
+// extract writes the files in the archive to the query and fragment
+// directories
+func (al *List) extract(r io.Reader) error {
+	br := bufio.NewReader(r)
+
+	// gzip magic number
+	if b, err := br.Peek(2); err == nil && bytes.Equal(b, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return fmt.Errorf("allow list: %w", err)
+		}
+		defer gz.Close()
+		r = gz
+	} else {
+		r = br
+	}
+
+	tr := tar.NewReader(r)
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("allow list: %w", err)
+		}
+
+		fn := al.archivePath(hdr.Name, hdr.Typeflag == tar.TypeReg)
+		if fn == "" {
+			continue
+		}
+
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			return fmt.Errorf("allow list: %w", err)
+		}
+
+		if err := al.writeFile(fn, b); err != nil {
+			return fmt.Errorf("allow list: %w", err)
+		}
+	}
+	return nil
+}
+
+// archivePath returns the path in the allow list of the archive entry or
+// an empty string if the entry is to be skipped.
+func (al *List) archivePath(entry string, regular bool) string {
+	if !regular {
+		return ""
+	}
+
+	// cleaning leaves no .. in the path relative to the archive directory
+	dir, rel, ok := strings.Cut(path.Clean(strings.TrimPrefix(entry, "./")), "/")
+	if !ok || rel == "" || isTempFile(path.Base(rel)) {
+		return ""
+	}
+
+	switch dir {
+	case archiveQueryDir:
+		// queries are only read from the query directory itself
+		if strings.Contains(rel, "/") {
+			return ""
+		}
+		return filepath.Join(al.conf.QueryDir, rel)
+	case archiveFragmentDir:
+		return filepath.Join(al.conf.FragmentDir, filepath.FromSlash(rel))
+	}
+	return ""
+}
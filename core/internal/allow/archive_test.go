@@ -0,0 +1,313 @@
+package allow
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestArchive(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		testArchive(t, compress)
+	}
+}
+
+func testArchive(t *testing.T, compress bool) {
+	al, err := New(Config{Compress: compress}, afero.NewMemMapFs())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := `query getUser { user { ...User } }
+	fragment User on users { id }`
+
+	if err := al.SetSync(nil, q, Metadata{}, "tenant1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := al.SetSync(nil, `query getUsers { users { id } }`, Metadata{}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := al.Archive(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := afero.NewMemMapFs()
+
+	al1, err := New(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := al1.Unarchive(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := al1.Count(); err != nil || n != 2 {
+		t.Fatal("expected 2 queries, got ", n, err)
+	}
+
+	item, err := al1.GetByName("tenant1.getUser")
+	if err != nil || item.Name != "getUser" {
+		t.Fatal("expected the query to be restored: ", item.Name, err)
+	}
+
+	if _, err := al1.FragmentFetcher("tenant1")("User"); err != nil {
+		t.Fatal("expected the fragment to be restored: ", err)
+	}
+}
+
+func TestArchiveScopedFragments(t *testing.T) {
+	al, err := New(Config{ScopedFragments: true}, afero.NewMemMapFs())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ns := range []string{"", "acme"} {
+		q := `query getUser { user { ...User } }
+		fragment User on users { id }`
+
+		if err := al.SetSync(nil, q, Metadata{}, ns); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if st, err := al.Stats(); err != nil || st.Fragments != 2 {
+		t.Fatal("expected 2 fragments, got ", st.Fragments, err)
+	}
+
+	if err := al.WriteManifest(); err != nil {
+		t.Fatal(err)
+	}
+	if files, err := al.VerifyManifest(); err != nil || len(files) != 0 {
+		t.Fatal("expected no changes: ", files, err)
+	}
+
+	var buf bytes.Buffer
+	if err := al.Archive(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := afero.NewMemMapFs()
+
+	al1, err := New(Config{ScopedFragments: true}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := al1.Unarchive(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, fn := range []string{"/fragments/_global/User.gql", "/fragments/acme/User.gql"} {
+		if ok, _ := afero.Exists(fs, fn); !ok {
+			t.Fatal("expected the fragment to be restored: ", fn)
+		}
+	}
+
+	if _, err := al1.FragmentFetcher("acme")("User"); err != nil {
+		t.Fatal("expected the fragment to be restored: ", err)
+	}
+}
+
+func TestUnarchiveSkip(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+
+	entries := []*tar.Header{
+		{Name: "queries/getUser.gql", Typeflag: tar.TypeReg},
+		{Name: "queries/../../etc/getUser.gql", Typeflag: tar.TypeReg},
+		{Name: "other/getUser.gql", Typeflag: tar.TypeReg},
+		{Name: "queries/link.gql", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"},
+		{Name: "queries/sub/getUser.gql", Typeflag: tar.TypeReg},
+	}
+
+	q := []byte(`query getUser { user { id } }`)
+	for _, hdr := range entries {
+		if hdr.Typeflag == tar.TypeReg {
+			hdr.Size = int64(len(q))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			if _, err := tw.Write(q); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := afero.NewMemMapFs()
+
+	al, err := New(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := al.Unarchive(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var files []string
+	err = afero.Walk(fs, "/", func(p string, fi os.FileInfo, err error) error {
+		if err == nil && !fi.IsDir() {
+			files = append(files, p)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) != 1 || files[0] != "/queries/getUser.gql" {
+		t.Fatal("expected only the query file to be restored, got ", files)
+	}
+}
+
+func TestArchiveSymlink(t *testing.T) {
+	dir := t.TempDir()
+	fs := afero.NewBasePathFs(afero.NewOsFs(), dir)
+
+	al, err := New(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := al.SetSync(nil, `query getUser { user { id } }`, Metadata{}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	target := filepath.Join(dir, "queries", "getUser.yaml")
+	if err := os.Symlink(target, filepath.Join(dir, "queries", "link.yaml")); err != nil {
+		t.Skip("symlinks not supported: ", err)
+	}
+
+	var buf bytes.Buffer
+	if err := al.Archive(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	tr := tar.NewReader(&buf)
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+		names = append(names, hdr.Name)
+	}
+
+	if len(names) != 1 || names[0] != "queries/getUser.yaml" {
+		t.Fatal("expected the symlink to be skipped, got ", names)
+	}
+}
+
+func TestNewFromTarGz(t *testing.T) {
+	al, err := New(Config{Compress: true}, afero.NewMemMapFs())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := `query getUser { user { ...User } }
+	fragment User on users { id }`
+
+	if err := al.SetSync(nil, q, Metadata{}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := al.Archive(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	al1, err := NewFromTarGz(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !al1.IsReadOnly() {
+		t.Fatal("expected a read-only list")
+	}
+
+	if item, err := al1.GetByName("getUser"); err != nil || item.Name != "getUser" {
+		t.Fatal("expected the archived query: ", item.Name, err)
+	}
+
+	if _, err := al1.FragmentFetcher("")("User"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := al1.Set(nil, `query getUsers { users { id } }`, Metadata{}, ""); !errors.Is(err, ErrReadOnly) {
+		t.Fatal("expected ErrReadOnly, got ", err)
+	}
+
+	if _, err := NewFromTarGz(strings.NewReader("not an archive")); err == nil {
+		t.Fatal("expected an error for an invalid archive")
+	}
+}
+
+func TestNewFromZip(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	files := []struct{ name, data string }{
+		{"queries/", ""},
+		{"queries/getUser.gql", `query getUser { user { ...User } }`},
+		{"fragments/User.gql", `fragment User on users { id }`},
+		{"queries/sub/getPosts.gql", `query getPosts { posts { id } }`},
+		{"../queries/getTags.gql", `query getTags { tags { id } }`},
+		{"README.md", "allow list"},
+	}
+	for _, f := range files {
+		w, err := zw.Create(f.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(f.data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	al, err := NewFromZip(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !al.IsReadOnly() {
+		t.Fatal("expected a read-only list")
+	}
+
+	items, err := al.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].Name != "getUser" {
+		t.Fatal("expected only the query in the queries directory, got ", len(items))
+	}
+
+	if _, err := al.FragmentFetcher("")("User"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := al.Set(nil, `query getUsers { users { id } }`, Metadata{}, ""); !errors.Is(err, ErrReadOnly) {
+		t.Fatal("expected ErrReadOnly, got ", err)
+	}
+
+	if _, err := NewFromZip(strings.NewReader("not an archive"), 14); err == nil {
+		t.Fatal("expected an error for an invalid archive")
+	}
+}
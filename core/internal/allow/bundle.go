@@ -0,0 +1,278 @@
+package allow
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/chirino/graphql/schema"
+)
+
+// StreamBundle reads the operations and fragments in a .graphql bundle as
+// LoadBundle does but one definition at a time from the reader, calling fn
+// with the item of each operation once it and the fragments it uses are
+// read. Only the fragments are kept in memory, along with the operations
+// using fragments not yet read, so bundles with the fragments first use the
+// least memory. Operations using fragments not in the bundle are passed to
+// fn at the end without them. It stops and returns the error if the bundle
+// cannot be parsed or fn returns an error. The #import lines are not read.
+func StreamBundle(r io.Reader, fn func(Item) error) error {
+	sb := &bundleStream{
+		frags: make(map[string]string),
+		seen:  make(map[string]struct{}),
+		fn:    fn,
+	}
+
+	dr := newDefReader(r)
+	for {
+		def, err := dr.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("allow list: %w", err)
+		}
+		if err := sb.add(def); err != nil {
+			return err
+		}
+	}
+
+	// operations using fragments not in the bundle
+	for _, op := range sb.pending {
+		if err := sb.emit(op); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type bundleStream struct {
+	frags   map[string]string
+	pending []string
+	seen    map[string]struct{}
+	fn      func(Item) error
+}
+
+// add reads the definition emitting the operations that have all their
+// fragments
+func (sb *bundleStream) add(def string) error {
+	qd := &schema.QueryDocument{}
+	if err := qd.Parse(def); err != nil {
+		return fmt.Errorf("allow list: %w", err)
+	}
+
+	if len(qd.Operations)+len(qd.Fragments) != 1 {
+		return fmt.Errorf("allow list: invalid bundle definition: %s", strings.TrimSpace(def))
+	}
+
+	if len(qd.Operations) == 1 {
+		if sb.missing(def) {
+			sb.pending = append(sb.pending, def)
+			return nil
+		}
+		return sb.emit(def)
+	}
+
+	sb.frags[qd.Fragments[0].Name] = def
+
+	var pending []string
+	for _, op := range sb.pending {
+		if sb.missing(op) {
+			pending = append(pending, op)
+			continue
+		}
+		if err := sb.emit(op); err != nil {
+			return err
+		}
+	}
+	sb.pending = pending
+	return nil
+}
+
+// missing reports whether the operation uses fragments not yet read
+func (sb *bundleStream) missing(op string) bool {
+	for _, name := range sb.used(op) {
+		if _, ok := sb.frags[name]; !ok {
+			return true
+		}
+	}
+	return false
+}
+
+// used returns the names of the fragments spread in the definition
+// including those spread by the fragments read
+func (sb *bundleStream) used(def string) []string {
+	var names []string
+	seen := make(map[string]struct{})
+	st := fragmentSpreads(def)
+
+	for len(st) != 0 {
+		name := st[0]
+		st = st[1:]
+
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		seen[name] = struct{}{}
+		names = append(names, name)
+
+		if v, ok := sb.frags[name]; ok {
+			st = append(st, fragmentSpreads(v)...)
+		}
+	}
+	return names
+}
+
+// emit calls fn with the item of the operation and the fragments it uses
+func (sb *bundleStream) emit(op string) error {
+	var doc strings.Builder
+
+	doc.WriteString(op)
+	for _, name := range sb.used(op) {
+		if v, ok := sb.frags[name]; ok {
+			doc.WriteString("\n")
+			doc.WriteString(v)
+		}
+	}
+
+	qd := &schema.QueryDocument{}
+	if err := qd.Parse(doc.String()); err != nil {
+		return fmt.Errorf("allow list: %w", err)
+	}
+
+	items, err := splitOperations(qd)
+	if err != nil {
+		return fmt.Errorf("allow list: %w", err)
+	}
+	item := items[0]
+
+	if _, ok := sb.seen[item.key]; ok {
+		return fmt.Errorf("allow list: %w: %s", ErrNameCollision, item.Name)
+	}
+	sb.seen[item.key] = struct{}{}
+
+	return sb.fn(item)
+}
+
+// defReader splits the text read into the top level definitions
+type defReader struct {
+	r *bufio.Reader
+}
+
+func newDefReader(r io.Reader) *defReader {
+	return &defReader{r: bufio.NewReader(r)}
+}
+
+// next returns the text of the next definition, up to the brace closing
+// its selection set. Braces and parentheses in strings and comments are
+// skipped. It returns io.EOF when there are no more definitions.
+func (dr *defReader) next() (string, error) {
+	var sb strings.Builder
+	var braces, parens int
+	var text bool
+
+	for {
+		c, _, err := dr.r.ReadRune()
+		if err == io.EOF {
+			if text {
+				return "", errors.New("invalid bundle: unexpected end of file")
+			}
+			return "", io.EOF
+		}
+		if err != nil {
+			return "", err
+		}
+		sb.WriteRune(c)
+
+		switch c {
+		case ' ', '\t', '\n', '\r', ',':
+			continue
+
+		case '#':
+			if err := dr.copyComment(&sb); err != nil {
+				return "", err
+			}
+			continue
+
+		case '"':
+			if err := dr.copyString(&sb); err != nil {
+				return "", err
+			}
+
+		case '(':
+			parens++
+
+		case ')':
+			parens--
+
+		case '{':
+			braces++
+
+		case '}':
+			braces--
+			if braces == 0 && parens == 0 {
+				return sb.String(), nil
+			}
+		}
+
+		if braces < 0 || parens < 0 {
+			return "", fmt.Errorf("invalid bundle: unexpected '%c'", c)
+		}
+		text = true
+	}
+}
+
+// copyComment copies the rest of the line comment
+func (dr *defReader) copyComment(sb *strings.Builder) error {
+	v, err := dr.r.ReadString('\n')
+	sb.WriteString(v)
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+// copyString copies the rest of the string or block string after the
+// opening quote
+func (dr *defReader) copyString(sb *strings.Builder) error {
+	block := false
+	if b, err := dr.r.Peek(2); err == nil && string(b) == `""` {
+		block = true
+		_, _ = dr.r.Discard(2)
+		sb.WriteString(`""`)
+	}
+
+	for quotes := 0; ; {
+		c, _, err := dr.r.ReadRune()
+		if err == io.EOF {
+			return errors.New("invalid bundle: unterminated string")
+		}
+		if err != nil {
+			return err
+		}
+		sb.WriteRune(c)
+
+		switch {
+		case c == '\\':
+			c, _, err := dr.r.ReadRune()
+			if err != nil {
+				return errors.New("invalid bundle: unterminated string")
+			}
+			sb.WriteRune(c)
+			quotes = 0
+
+		case c == '"' && !block:
+			return nil
+
+		case c == '"':
+			if quotes++; quotes == 3 {
+				return nil
+			}
+
+		default:
+			quotes = 0
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package allow
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += n
+	return n, err
+}
+
+// largeBundle returns a bundle with n operations using fragments defined
+// both before and after them
+func largeBundle(n int) string {
+	var sb strings.Builder
+
+	sb.WriteString("fragment Name on users { first_name last_name }\n\n")
+
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&sb, "# operation %d { uses ...User%d\n", i, i%50)
+		fmt.Fprintf(&sb, "query getUser%d($id: Int = %d, $f: Filter = {name: \"}\"}) {\n", i, i)
+		fmt.Fprintf(&sb, "\tuser(id: $id, where: $f) { ...User%d ...Name note(v: \"{ \\\" }\") }\n}\n\n", i%50)
+
+		if i%50 == 49 {
+			for j := 0; j < 50; j++ {
+				fmt.Fprintf(&sb, "fragment User%d on users { id ...Name }\n", j)
+			}
+		}
+	}
+	return sb.String()
+}
+
+func TestStreamBundle(t *testing.T) {
+	bundle := largeBundle(2000)
+
+	fs := afero.NewMemMapFs()
+	if err := afero.WriteFile(fs, "/bundle.graphql", []byte(bundle), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	al, err := New(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp, err := al.LoadBundle("/bundle.graphql")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cr := &countingReader{r: strings.NewReader(bundle)}
+	firstRead := -1
+	items := make(map[string]Item)
+
+	err = StreamBundle(cr, func(item Item) error {
+		if firstRead == -1 {
+			firstRead = cr.n
+		}
+		items[item.Name] = item
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if firstRead >= len(bundle)/2 {
+		t.Fatalf("expected the first item before the bundle was read, read %d of %d bytes",
+			firstRead, len(bundle))
+	}
+
+	if len(items) != len(exp) {
+		t.Fatalf("expected %d items, got %d", len(exp), len(items))
+	}
+
+	for _, v := range exp {
+		v1, ok := items[v.Name]
+		if !ok {
+			t.Fatal("expected the operation: ", v.Name)
+		}
+		if v1.Query != v.Query || v1.OpType != v.OpType || !reflect.DeepEqual(v1.frags, v.frags) {
+			t.Fatalf("%s: expected the item read by LoadBundle, got %q", v.Name, v1.Query)
+		}
+	}
+}
+
+func TestStreamBundleErrors(t *testing.T) {
+	noop := func(Item) error { return nil }
+
+	tests := []struct {
+		bundle string
+		err    error
+	}{
+		{`query getUser { user { id } } query getUser { user { email } }`, ErrNameCollision},
+		{`query { user { id } } query getUser { user { email } }`, ErrAnonymousOperation},
+		{`query getUser { user { id }`, nil},
+		{`query getUser { user(name: "id) { id } }`, nil},
+		{`query getUser { user { id } } }`, nil},
+	}
+
+	for _, tt := range tests {
+		err := StreamBundle(strings.NewReader(tt.bundle), noop)
+		if err == nil || (tt.err != nil && !errors.Is(err, tt.err)) {
+			t.Fatalf("%s: expected an error, got %v", tt.bundle, err)
+		}
+	}
+
+	// fragments not in the bundle are left out
+	var items []Item
+	err := StreamBundle(strings.NewReader(`query getUser { user { ...User } }`), func(item Item) error {
+		items = append(items, item)
+		return nil
+	})
+	if err != nil || len(items) != 1 || len(items[0].frags) != 0 {
+		t.Fatal("expected the operation without the missing fragment: ", err)
+	}
+
+	errStop := errors.New("stop")
+	n := 0
+	err = StreamBundle(strings.NewReader(largeBundle(100)), func(Item) error {
+		if n++; n == 3 {
+			return errStop
+		}
+		return nil
+	})
+	if !errors.Is(err, errStop) || n != 3 {
+		t.Fatal("expected the callback error to stop reading: ", n, err)
+	}
+}
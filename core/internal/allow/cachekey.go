@@ -0,0 +1,75 @@
+package allow
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// CacheKey returns the hex encoded key to cache the result of the query
+// called with the variables. Queries differing only in formatting and
+// variables differing only in whitespace or key order have the same key.
+// Empty, {} and null variables are the same.
+func CacheKey(query string, vars []byte) (string, error) {
+	k, err := CacheKeyBytes(query, vars)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(k), nil
+}
+
+// CacheKeyBytes is CacheKey returning the sha256 sum.
+func CacheKeyBytes(query string, vars []byte) ([]byte, error) {
+	q, err := Normalize(query)
+	if err != nil {
+		return nil, err
+	}
+
+	vj, err := canonicalVars(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	// the query length separates the query from the variables
+	var n [8]byte
+	binary.BigEndian.PutUint64(n[:], uint64(len(q)))
+
+	h := sha256.New()
+	_, _ = h.Write(n[:])
+	_, _ = h.Write([]byte(q))
+	_, _ = h.Write(vj)
+	return h.Sum(nil), nil
+}
+
+// canonicalVars returns the variables json compacted with the object keys
+// sorted and the numbers kept as written
+func canonicalVars(vars []byte) ([]byte, error) {
+	if len(bytes.TrimSpace(vars)) == 0 {
+		return nil, nil
+	}
+
+	var v interface{}
+
+	dec := json.NewDecoder(bytes.NewReader(vars))
+	dec.UseNumber()
+
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidVars, err)
+	}
+
+	if dec.More() {
+		return nil, fmt.Errorf("%w: unexpected data after the variables", ErrInvalidVars)
+	}
+
+	vj, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidVars, err)
+	}
+	if emptyVars(vj) {
+		return nil, nil
+	}
+	return vj, nil
+}
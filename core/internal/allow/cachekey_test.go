@@ -0,0 +1,74 @@
+package allow
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCacheKey(t *testing.T) {
+	k1, err := CacheKey(`query getUser { user(id: $id) { id email } }`,
+		[]byte(`{"id": 1, "where": {"b": "x", "a": [1, 2]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	same := []struct {
+		query, vars string
+	}{
+		{"query getUser {\n  user(id: $id) {\n    id\n    email\n  }\n}", `{"where":{"a":[1,2],"b":"x"},"id":1}`},
+		{`query getUser{user(id:$id){id email}}`, " {\n \"id\" : 1 , \"where\": {\"a\": [1,2], \"b\": \"x\"}}\n"},
+	}
+
+	for _, v := range same {
+		k, err := CacheKey(v.query, []byte(v.vars))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if k != k1 {
+			t.Errorf("expected the same key for: %s %s", v.query, v.vars)
+		}
+	}
+
+	different := []struct {
+		query, vars string
+	}{
+		{`query getUser { user(id: $id) { id email } }`, `{"id": 2, "where": {"b": "x", "a": [1, 2]}}`},
+		{`query getUser { user(id: $id) { id email } }`, `{"id": 1, "where": {"b": "x", "a": [2, 1]}}`},
+		{`query getUser { user(id: $id) { id } }`, `{"id": 1, "where": {"b": "x", "a": [1, 2]}}`},
+	}
+
+	for _, v := range different {
+		k, err := CacheKey(v.query, []byte(v.vars))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if k == k1 {
+			t.Errorf("expected a different key for: %s %s", v.query, v.vars)
+		}
+	}
+
+	q := `query getUsers { users { id } }`
+	k2, _ := CacheKey(q, nil)
+	for _, v := range []string{`{}`, `{ }`, `null`, ` `} {
+		if k, _ := CacheKey(q, []byte(v)); k != k2 {
+			t.Errorf("expected %q to be the same as no variables", v)
+		}
+	}
+
+	b, err := CacheKeyBytes(q, nil)
+	if err != nil || len(b) != 32 {
+		t.Fatal("expected a sha256 sum: ", b, err)
+	}
+
+	if _, err := CacheKey(q, []byte(`{"id": `)); !errors.Is(err, ErrInvalidVars) {
+		t.Fatal("expected ErrInvalidVars, got ", err)
+	}
+
+	if _, err := CacheKey(q, []byte(`{} {}`)); !errors.Is(err, ErrInvalidVars) {
+		t.Fatal("expected ErrInvalidVars, got ", err)
+	}
+
+	if _, err := CacheKey(`query getUsers {`, nil); err == nil {
+		t.Fatal("expected an error for an invalid query")
+	}
+}
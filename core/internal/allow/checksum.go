@@ -0,0 +1,136 @@
+package allow
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ChecksumFile is the file in the parent directory of the query directory
+// listing the sha256 hash of each query and fragment file in the format
+// used by sha256sum (<hash>  <path relative to the parent directory>).
+const ChecksumFile = "manifest.sha256"
+
+// WriteManifest writes the checksum file (ChecksumFile) with the hashes of
+// all the query and fragment files.
+func (al *List) WriteManifest() error {
+	if al.IsReadOnly() {
+		return ErrReadOnly
+	}
+
+	sums, err := al.checksums()
+	if err != nil {
+		return err
+	}
+
+	files := make([]string, 0, len(sums))
+	for fn := range sums {
+		files = append(files, fn)
+	}
+	sort.Strings(files)
+
+	var b bytes.Buffer
+	for _, fn := range files {
+		fmt.Fprintf(&b, "%s  %s\n", sums[fn], fn)
+	}
+
+	if err := al.writeFile(al.checksumFile(), b.Bytes()); err != nil {
+		return fmt.Errorf("allow list: %w", err)
+	}
+	return nil
+}
+
+// VerifyManifest compares the query and fragment files with the hashes in
+// the checksum file (ChecksumFile) and returns the files that changed, are
+// missing or are not listed in it. No files are returned if the allow list
+// is unchanged.
+func (al *List) VerifyManifest() ([]string, error) {
+	var files []string
+
+	b, err := al.readRawFile(al.checksumFile())
+	if err != nil {
+		return nil, fmt.Errorf("allow list: %w", err)
+	}
+
+	sums, err := al.checksums()
+	if err != nil {
+		return nil, err
+	}
+
+	listed := make(map[string]struct{})
+	s := bufio.NewScanner(bytes.NewReader(b))
+
+	for s.Scan() {
+		hash, fn, ok := cutChecksum(s.Text())
+		if !ok {
+			continue
+		}
+		listed[fn] = struct{}{}
+
+		if v, ok := sums[fn]; !ok || v != hash {
+			files = append(files, fn)
+		}
+	}
+
+	for fn := range sums {
+		if _, ok := listed[fn]; !ok {
+			files = append(files, fn)
+		}
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+// cutChecksum splits a line of the checksum file into the hash and path
+func cutChecksum(line string) (string, string, bool) {
+	i := strings.Index(line, "  ")
+	if i == -1 {
+		return "", "", false
+	}
+	return line[:i], line[i+2:], true
+}
+
+func (al *List) checksumFile() string {
+	return filepath.Join(filepath.Dir(al.conf.QueryDir), ChecksumFile)
+}
+
+// checksums returns the hex encoded sha256 hash of each file in the query
+// and fragment directories (including the fragment subdirectories) keyed
+// by the path relative to the parent of the query directory
+func (al *List) checksums() (map[string]string, error) {
+	root := filepath.Dir(al.conf.QueryDir)
+	sums := make(map[string]string)
+
+	for _, kind := range []string{KindQuery, KindFragment} {
+		fi, err := al.listFiles(kind, kind == KindFragment)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, f := range fi {
+			if f.link {
+				continue
+			}
+
+			b, err := al.readRawFile(f.path)
+			if err != nil {
+				return nil, fmt.Errorf("allow list: %w", err)
+			}
+
+			rel, err := filepath.Rel(root, f.path)
+			if err != nil {
+				return nil, fmt.Errorf("allow list: %w", err)
+			}
+
+			h := sha256.Sum256(b)
+			sums[filepath.ToSlash(rel)] = hex.EncodeToString(h[:])
+		}
+	}
+	return sums, nil
+}
@@ -0,0 +1,70 @@
+package allow
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestManifest(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	al, err := New(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := `query getUser { user { ...User } }
+	fragment User on users { id }`
+
+	if err := al.SetSync(nil, q, Metadata{}, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := al.SetSync(nil, `query getUsers { users { id } }`, Metadata{}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := al.WriteManifest(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := afero.ReadFile(fs, "/"+ChecksumFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(b), "  queries/getUser.yaml\n") ||
+		!strings.Contains(string(b), "  fragments/User.gql\n") {
+		t.Fatalf("unexpected manifest: %s", b)
+	}
+
+	if files, err := al.VerifyManifest(); err != nil || len(files) != 0 {
+		t.Fatal("expected no changes: ", files, err)
+	}
+
+	// the manifest is not loaded as a query
+	if n, err := al.Count(); err != nil || n != 2 {
+		t.Fatal("expected 2 queries, got ", n, err)
+	}
+
+	if err := afero.WriteFile(fs, "/queries/getUser.yaml", []byte("name: getUser\nquery: query getUser { user { email } }\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Remove("/fragments/User.gql"); err != nil {
+		t.Fatal(err)
+	}
+	if err := afero.WriteFile(fs, "/queries/getPosts.gql", []byte(`query getPosts { posts { id } }`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := al.VerifyManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []string{"fragments/User.gql", "queries/getPosts.gql", "queries/getUser.yaml"}
+	if strings.Join(files, ",") != strings.Join(exp, ",") {
+		t.Fatal("unexpected changed files: ", files)
+	}
+}
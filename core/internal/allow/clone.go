@@ -0,0 +1,48 @@
+package allow
+
+// Clone returns a deep copy of the item so changing it, including its
+// fragments and the slices and settings in its metadata, does not change
+// the item it was copied from, such as one shared by the index or cache.
+// The strings are immutable and shared.
+func (i Item) Clone() Item {
+	c := i
+
+	if i.frags != nil {
+		c.frags = append([]Frag(nil), i.frags...)
+	}
+	c.Metadata = i.Metadata.clone()
+
+	// the clone gets its own header cache so parsing a changed query does
+	// not replace the header cached for the original
+	if h := i.header; h != nil {
+		h.mu.Lock()
+		c.header = &headerCache{query: h.query, ok: h.ok, h: h.h, err: h.err}
+		h.mu.Unlock()
+	}
+	return c
+}
+
+func (md Metadata) clone() Metadata {
+	c := md
+
+	c.Order.Values = cloneStrings(md.Order.Values)
+	c.AllowedVars = cloneStrings(md.AllowedVars)
+	c.Tags = cloneStrings(md.Tags)
+
+	if md.Subscription != nil {
+		v := *md.Subscription
+		c.Subscription = &v
+	}
+	if md.RateLimit != nil {
+		v := *md.RateLimit
+		c.RateLimit = &v
+	}
+	return c
+}
+
+func cloneStrings(v []string) []string {
+	if v == nil {
+		return nil
+	}
+	return append([]string(nil), v...)
+}
@@ -0,0 +1,58 @@
+package allow
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestItemClone(t *testing.T) {
+	item, err := parseQuery(`query GetUser { user { id ...Name } }
+fragment Name on users { full_name }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	item.Metadata.Order.Values = []string{"a", "b"}
+	item.Metadata.AllowedVars = []string{"id"}
+	item.Metadata.Tags = []string{"users"}
+	item.Metadata.Subscription = &SubscriptionMetadata{HeartbeatSeconds: 10}
+	item.Metadata.RateLimit = &RateLimit{RequestsPerSecond: 5}
+	item.header = &headerCache{}
+
+	if _, err := item.Header(); err != nil {
+		t.Fatal(err)
+	}
+
+	c := item.Clone()
+	if !reflect.DeepEqual(c.frags, item.frags) || !reflect.DeepEqual(c.Metadata, item.Metadata) {
+		t.Fatal("expected the clone to be equal")
+	}
+
+	c.frags[0].Value = "fragment Name on users { id }"
+	c.Metadata.Order.Values[0] = "c"
+	c.Metadata.AllowedVars[0] = "email"
+	c.Metadata.Tags[0] = "admin"
+	c.Metadata.Subscription.HeartbeatSeconds = 20
+	c.Metadata.RateLimit.RequestsPerSecond = 50
+
+	c.Query = `query GetUsers { users { id } }`
+	if h, err := c.Header(); err != nil || h.Name != "GetUsers" {
+		t.Fatal("unexpected header: ", h, err)
+	}
+
+	md := item.Metadata
+	switch {
+	case item.frags[0].Value != "fragment Name on users { full_name }":
+		t.Fatal("the fragments of the item were changed")
+	case md.Order.Values[0] != "a" || md.AllowedVars[0] != "id" || md.Tags[0] != "users":
+		t.Fatal("the metadata of the item was changed: ", md)
+	case md.Subscription.HeartbeatSeconds != 10 || md.RateLimit.RequestsPerSecond != 5:
+		t.Fatal("the metadata settings of the item were changed")
+	case item.header.query != item.Query || item.header.h.Name != "GetUser":
+		t.Fatal("the header cached for the item was changed")
+	}
+
+	if c := (Item{}).Clone(); c.frags != nil || c.Metadata.Tags != nil || c.header != nil {
+		t.Fatal("expected the nil fields to stay nil")
+	}
+}
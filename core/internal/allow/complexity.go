@@ -0,0 +1,73 @@
+package allow
+
+import (
+	"strings"
+
+	"github.com/chirino/graphql/schema"
+)
+
+// ExceedsLimits reports whether the depth or number of fields of the query
+// are over the limits. A limit of zero is not checked.
+func (i Item) ExceedsLimits(maxDepth, maxFields int) bool {
+	return (maxDepth > 0 && i.Metadata.Depth > maxDepth) ||
+		(maxFields > 0 && i.Metadata.FieldCount > maxFields)
+}
+
+// complexity returns the depth of the most nested field and the number of
+// fields in the query of the item including those in its fragments.
+// Fragments not defined with the query are not counted.
+func complexity(item Item) (int, int, error) {
+	var sb strings.Builder
+
+	sb.WriteString(item.Query)
+	for _, f := range item.frags {
+		sb.WriteString("\n" + f.Value)
+	}
+
+	qd := &schema.QueryDocument{}
+	if err := qd.Parse(sb.String()); err != nil {
+		return 0, 0, err
+	}
+
+	var depth, fields int
+	for _, op := range qd.Operations {
+		d, n := walkSelections(qd, op.Selections, 0, make(map[string]struct{}))
+		if d > depth {
+			depth = d
+		}
+		fields += n
+	}
+	return depth, fields, nil
+}
+
+func walkSelections(qd *schema.QueryDocument, sel schema.SelectionList, depth int, seen map[string]struct{}) (int, int) {
+	max, fields := depth, 0
+
+	for _, s := range sel {
+		var d, n int
+
+		switch v := s.(type) {
+		case *schema.FieldSelection:
+			d, n = walkSelections(qd, v.Selections, depth+1, seen)
+			n++
+
+		case *schema.InlineFragment:
+			d, n = walkSelections(qd, v.Selections, depth, seen)
+
+		case *schema.FragmentSpread:
+			f := qd.Fragments.Get(v.Name)
+			if _, ok := seen[v.Name]; ok || f == nil {
+				continue
+			}
+			seen[v.Name] = struct{}{}
+			d, n = walkSelections(qd, f.Selections, depth, seen)
+			delete(seen, v.Name)
+		}
+
+		if d > max {
+			max = d
+		}
+		fields += n
+	}
+	return max, fields
+}
@@ -0,0 +1,81 @@
+package allow
+
+import (
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestComplexity(t *testing.T) {
+	al, err := New(Config{}, afero.NewMemMapFs())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		query  string
+		depth  int
+		fields int
+	}{
+		{`query getUser { user { id } }`, 2, 2},
+		{`query getUser { user { id email posts { id comments { id body author { id } } } } }`, 5, 10},
+		{`query getUser { user { ...User } }
+		fragment User on users { id posts { ...Post } }
+		fragment Post on posts { id title }`, 3, 5},
+		{`query getUser { user { ... on users { id } } }`, 2, 2},
+		{`query getUser { user { ...Missing } }`, 1, 1},
+	}
+
+	for _, v := range tests {
+		item, err := al.Validate(nil, v.query, Metadata{}, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if item.Metadata.Depth != v.depth || item.Metadata.FieldCount != v.fields {
+			t.Errorf("%s: expected depth %d and %d fields, got %d and %d", v.query,
+				v.depth, v.fields, item.Metadata.Depth, item.Metadata.FieldCount)
+		}
+	}
+}
+
+func TestExceedsLimits(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	al, err := New(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := `query getUser { user { posts { comments { author { id } } } } }`
+	if err := al.SetSync(nil, q, Metadata{}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// the limits are checked with the saved metadata
+	item, err := al.GetByName("getUser")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if item.Metadata.Depth != 5 || item.Metadata.FieldCount != 5 {
+		t.Fatal("expected the complexity to be saved, got ", item.Metadata.Depth, item.Metadata.FieldCount)
+	}
+
+	limits := []struct {
+		depth, fields int
+		exceeds       bool
+	}{
+		{0, 0, false},
+		{5, 5, false},
+		{4, 0, true},
+		{0, 4, true},
+		{10, 10, false},
+	}
+
+	for _, v := range limits {
+		if item.ExceedsLimits(v.depth, v.fields) != v.exceeds {
+			t.Errorf("limits %d, %d: expected %v", v.depth, v.fields, v.exceeds)
+		}
+	}
+}
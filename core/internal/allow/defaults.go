@@ -0,0 +1,146 @@
+package allow
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultsFile is the file in the query directory with the shared
+// variables that items can use by name with VarsRef
+const DefaultsFile = "_defaults.yaml"
+
+// NamespaceFile is the suffix of the optional file in the query directory
+// (<namespace>._namespace.yaml) with the metadata inherited by the items
+// in the namespace. The metadata of an item takes precedence, only the
+// fields it does not set (zero values) are taken from the namespace file.
+const NamespaceFile = "._namespace.yaml"
+
+// defaults holds the shared variables and namespace metadata so the files
+// are read at most once when reading many items (Load, Range).
+type defaults struct {
+	defs map[string]string
+	err  error
+	read bool
+
+	// namespace metadata, nil for namespaces without a file
+	ns map[string]*Metadata
+
+	// saved skips the namespace metadata to return items as saved
+	saved bool
+}
+
+func (al *List) defaults(d *defaults) (map[string]string, error) {
+	if !d.read {
+		d.defs, d.err = al.readDefaults()
+		d.read = true
+	}
+	return d.defs, d.err
+}
+
+// resolveVarsRef sets the variables of the item to the shared variables
+// named by its VarsRef. A VarsRef missing from the defaults file is an error.
+func (al *List) resolveVarsRef(item Item, d *defaults) (Item, error) {
+	if item.VarsRef == "" || item.Vars != "" {
+		return item, nil
+	}
+
+	defs, err := al.defaults(d)
+	if err != nil {
+		return item, err
+	}
+
+	v, ok := defs[item.VarsRef]
+	if !ok {
+		return item, fmt.Errorf("%w: %s", ErrUnknownVarsRef, item.VarsRef)
+	}
+	item.Vars = v
+	return item, nil
+}
+
+// readDefaults returns the shared variables as json keyed by name. The
+// variables can be a json string or a yaml mapping.
+func (al *List) readDefaults() (map[string]string, error) {
+	fn := filepath.Join(al.conf.QueryDir, DefaultsFile)
+
+	b, err := al.readRawFile(fn)
+	if err != nil {
+		return nil, fmt.Errorf("allow list: %w", err)
+	}
+
+	var dm map[string]interface{}
+	if err := yaml.Unmarshal(b, &dm); err != nil {
+		return nil, fmt.Errorf("allow list: %s: %w", fn, err)
+	}
+
+	defs := make(map[string]string, len(dm))
+	for k, v := range dm {
+		if s, ok := v.(string); ok {
+			defs[k] = s
+			continue
+		}
+		vj, err := json.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("allow list: %s: %s: %w", fn, k, err)
+		}
+		defs[k] = string(vj)
+	}
+	return defs, nil
+}
+
+func isDefaultsFile(fn string) bool {
+	return filepath.Base(fn) == DefaultsFile
+}
+
+// inheritMetadata sets the fields of the item metadata that are not set
+// from the metadata of its namespace.
+func (al *List) inheritMetadata(item Item, d *defaults) (Item, error) {
+	if item.Namespace == "" || d.saved {
+		return item, nil
+	}
+
+	if d.ns == nil {
+		d.ns = make(map[string]*Metadata)
+	}
+
+	md, ok := d.ns[item.Namespace]
+	if !ok {
+		var err error
+		if md, err = al.readNamespaceFile(item.Namespace); err != nil {
+			return item, err
+		}
+		d.ns[item.Namespace] = md
+	}
+
+	if md != nil {
+		item.Metadata = mergeMetadata(*md, item.Metadata)
+	}
+	return item, nil
+}
+
+// readNamespaceFile returns the metadata in the namespace file or nil if
+// there is no file for the namespace
+func (al *List) readNamespaceFile(namespace string) (*Metadata, error) {
+	fn := filepath.Join(al.conf.QueryDir, namespace+NamespaceFile)
+
+	b, err := al.readRawFile(fn)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("allow list: %w", err)
+	}
+
+	var md Metadata
+	if err := yaml.Unmarshal(b, &md); err != nil {
+		return nil, fmt.Errorf("allow list: %s: %w", fn, err)
+	}
+	return &md, nil
+}
+
+func isNamespaceFile(fn string) bool {
+	return strings.HasSuffix(filepath.Base(fn), NamespaceFile)
+}
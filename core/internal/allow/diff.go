@@ -0,0 +1,100 @@
+package allow
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Diff compares two lists of items by their namespace and name (ignoring
+// case) and returns the items only in b (added), only in a (removed) and
+// those in both that are not equal (changed, as in b). See Item.Equal. A
+// renamed query is both removed and added.
+func Diff(a, b []Item) (added, removed, changed []Item) {
+	am := make(map[string]Item, len(a))
+	for _, v := range a {
+		am[indexKey(v.Namespace, v.Name)] = v
+	}
+
+	bm := make(map[string]struct{}, len(b))
+	for _, v := range b {
+		k := indexKey(v.Namespace, v.Name)
+		bm[k] = struct{}{}
+
+		old, ok := am[k]
+		switch {
+		case !ok:
+			added = append(added, v)
+		case !old.Equal(v):
+			changed = append(changed, v)
+		}
+	}
+
+	for _, v := range a {
+		if _, ok := bm[indexKey(v.Namespace, v.Name)]; !ok {
+			removed = append(removed, v)
+		}
+	}
+	return added, removed, changed
+}
+
+// Equal reports whether the items have the same namespace and name
+// (ignoring case), query and variables (ignoring formatting) and metadata.
+// The comments, source files and the metadata computed when saving (hashes,
+// complexity and times) are not compared.
+func (i Item) Equal(other Item) bool {
+	return indexKey(i.Namespace, i.Name) == indexKey(other.Namespace, other.Name) &&
+		sameQuery(i.Query, other.Query) &&
+		sameVars(i.Vars, other.Vars) &&
+		sameMetadata(i.Metadata, other.Metadata)
+}
+
+// sameMetadata reports whether the metadata set by the user is the same
+// ignoring the fields computed when saving, which .gql files do not have.
+// Times are equal if they are the same instant in any location since they
+// are read back in UTC.
+func sameMetadata(a, b Metadata) bool {
+	ua, ub := userMetadata(a), userMetadata(b)
+	if !ua.Sunset.UTC().Equal(ub.Sunset.UTC()) {
+		return false
+	}
+	ua.Sunset, ub.Sunset = time.Time{}, time.Time{}
+	return reflect.DeepEqual(ua, ub)
+}
+
+// userMetadata returns the metadata without the fields set when saving
+// and with empty lists set to nil
+func userMetadata(md Metadata) Metadata {
+	md.Hash = ""
+	md.HashAlgorithm = ""
+	md.CreatedAt = time.Time{}
+	md.UpdatedAt = time.Time{}
+	md.APQHash = ""
+	md.Depth = 0
+	md.FieldCount = 0
+
+	if len(md.Order.Values) == 0 {
+		md.Order.Values = nil
+	}
+	if len(md.AllowedVars) == 0 {
+		md.AllowedVars = nil
+	}
+	if len(md.Tags) == 0 {
+		md.Tags = nil
+	}
+	return md
+}
+
+// sameVars reports whether the variables json has the same values
+// ignoring formatting and the order of the keys
+func sameVars(a, b string) bool {
+	var va, vb interface{}
+
+	err1 := json.Unmarshal([]byte(a), &va)
+	err2 := json.Unmarshal([]byte(b), &vb)
+	if err1 != nil || err2 != nil {
+		return strings.TrimSpace(a) == strings.TrimSpace(b)
+	}
+	return reflect.DeepEqual(va, vb)
+}
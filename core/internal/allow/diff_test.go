@@ -0,0 +1,97 @@
+package allow
+
+import (
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	a := []Item{
+		{Name: "getUser", Query: `query getUser { user { id } }`},
+		{Name: "getUsers", Query: `query getUsers { users { id } }`, Vars: `{"limit": 10}`},
+		{Name: "getPosts", Query: `query getPosts { posts { id } }`},
+		{Namespace: "tenant1", Name: "getUser", Query: `query getUser { user { id } }`},
+		{Name: "getComments", Query: `query getComments { comments { id } }`},
+	}
+
+	b := []Item{
+		// formatting changes are ignored
+		{Name: "GETUSER", Query: "query getUser {\n  user {\n    id\n  }\n}"},
+		{Name: "getUsers", Query: `query getUsers { users { id } }`, Vars: `{"limit": 20}`},
+		{Name: "getPosts", Query: `query getPosts { posts { id title } }`},
+		{Namespace: "tenant1", Name: "getUser", Query: `query getUser { user { id } }`},
+		// renamed from getComments
+		{Name: "listComments", Query: `query listComments { comments { id } }`},
+	}
+
+	added, removed, changed := Diff(a, b)
+
+	if len(added) != 1 || added[0].Name != "listComments" {
+		t.Fatal("unexpected added: ", names(added))
+	}
+
+	if len(removed) != 1 || removed[0].Name != "getComments" {
+		t.Fatal("unexpected removed: ", names(removed))
+	}
+
+	if len(changed) != 2 || changed[0].Name != "getUsers" || changed[1].Name != "getPosts" {
+		t.Fatal("unexpected changed: ", names(changed))
+	}
+
+	if changed[0].Vars != `{"limit": 20}` {
+		t.Fatal("expected the changed item from b, got ", changed[0].Vars)
+	}
+
+	if added, removed, changed := Diff(a, a); len(added)+len(removed)+len(changed) != 0 {
+		t.Fatal("expected no differences")
+	}
+}
+
+func names(items []Item) []string {
+	var n []string
+	for _, v := range items {
+		n = append(n, fileName(v.Namespace, v.Name))
+	}
+	return n
+}
+
+func TestItemEqual(t *testing.T) {
+	a := Item{
+		Namespace: "tenant1",
+		Name:      "getUser",
+		Comment:   "Fetch a user",
+		Query:     `query getUser { user(id: $id) { id email } }`,
+		Vars:      `{"id": 1, "limit": 10}`,
+	}
+	a.Metadata.Order.Var = "order"
+
+	b := a
+	b.Name = "GetUser"
+	b.Comment = ""
+	b.Query = "query getUser {\n  user(id: $id) {\n    id\n    email\n  }\n}"
+	b.Vars = "{\n  \"limit\": 10,\n  \"id\": 1\n}"
+	b.source = "/queries/tenant1.getUser.yaml"
+	b.Metadata.Hash = "abc"
+	b.Metadata.Depth = 2
+
+	if !a.Equal(b) || !b.Equal(a) {
+		t.Fatal("expected items differing in formatting to be equal")
+	}
+
+	changes := []func(*Item){
+		func(i *Item) { i.Namespace = "" },
+		func(i *Item) { i.Name = "getUsers" },
+		func(i *Item) { i.Query = `query getUser { user(id: $id) { id } }` },
+		func(i *Item) { i.Vars = `{"id": 2, "limit": 10}` },
+		func(i *Item) { i.Vars = `{"id": 1}` },
+		func(i *Item) { i.Metadata.Order.Var = "sort" },
+		func(i *Item) { i.Metadata.Deprecated = true },
+	}
+
+	for n, fn := range changes {
+		c := a
+		fn(&c)
+		if a.Equal(c) {
+			t.Errorf("change %d: expected the items not to be equal", n)
+		}
+	}
+}
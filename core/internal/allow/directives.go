@@ -0,0 +1,186 @@
+package allow
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"text/scanner"
+)
+
+// applyDirectives sets the metadata from the @directive(...) lines in the
+// leading comment of a .gql file and returns the comment without them.
+// Supported are:
+//
+//	# @order(var: "sort", values: [asc, desc])
+//	# @deprecated(reason: "use getUsers")
+//	# @tags(values: [public, reporting])
+//
+// Unknown or invalid directives are logged and kept in the comment.
+func (al *List) applyDirectives(filePath, comment string, md *Metadata) string {
+	var lines []string
+
+	for _, line := range strings.Split(comment, "\n") {
+		v := strings.TrimSpace(line)
+		if !strings.HasPrefix(v, "@") {
+			lines = append(lines, line)
+			continue
+		}
+
+		if err := setDirective(v, md); err != nil {
+			if al.conf.Log != nil {
+				al.conf.Log.Printf("WRN allow list: %s: %s", filePath, err)
+			}
+			lines = append(lines, line)
+		}
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// setDirective sets the metadata field for the directive
+func setDirective(v string, md *Metadata) error {
+	name, args, err := parseDirective(v)
+	if err != nil {
+		return err
+	}
+
+	switch name {
+	case "order":
+		if args["var"].str == "" || len(args["values"].list) == 0 {
+			return fmt.Errorf("invalid directive: @order needs a var and values: %s", v)
+		}
+		md.Order.Var = args["var"].str
+		md.Order.Values = args["values"].list
+
+	case "deprecated":
+		md.Deprecated = true
+		md.DeprecationReason = args["reason"].str
+
+	case "tags":
+		md.Tags = normalizeTags(args["values"].list)
+
+	default:
+		return fmt.Errorf("unknown directive: @%s", name)
+	}
+	return nil
+}
+
+// directiveArg is a directive argument value, either a string or a list
+type directiveArg struct {
+	str  string
+	list []string
+}
+
+// parseDirective returns the name and arguments of a directive like
+// @name(arg: "value", list: [a, "b"]).
+func parseDirective(v string) (string, map[string]directiveArg, error) {
+	var s scanner.Scanner
+	var serr error
+
+	s.Init(strings.NewReader(v))
+	s.Mode = scanner.ScanIdents | scanner.ScanInts | scanner.ScanFloats | scanner.ScanStrings
+	s.Error = func(s *scanner.Scanner, msg string) {
+		if serr == nil {
+			serr = fmt.Errorf("invalid directive: %s: %s", msg, v)
+		}
+	}
+
+	invalid := fmt.Errorf("invalid directive: %s", v)
+	args := make(map[string]directiveArg)
+
+	if s.Scan() != '@' || s.Scan() != scanner.Ident {
+		return "", nil, invalid
+	}
+	name := s.TokenText()
+
+	tok := s.Scan()
+	if tok == scanner.EOF {
+		return name, args, serr
+	}
+	if tok != '(' {
+		return "", nil, invalid
+	}
+
+	for tok = s.Scan(); tok != ')'; tok = s.Scan() {
+		if tok == ',' {
+			continue
+		}
+		if tok != scanner.Ident {
+			return "", nil, invalid
+		}
+		k := s.TokenText()
+
+		if s.Scan() != ':' {
+			return "", nil, invalid
+		}
+
+		var a directiveArg
+
+		switch tok = s.Scan(); tok {
+		case '[':
+			for tok = s.Scan(); tok != ']'; tok = s.Scan() {
+				if tok == ',' {
+					continue
+				}
+				val, ok := directiveValue(&s, tok)
+				if !ok {
+					return "", nil, invalid
+				}
+				a.list = append(a.list, val)
+			}
+
+		default:
+			val, ok := directiveValue(&s, tok)
+			if !ok {
+				return "", nil, invalid
+			}
+			a.str = val
+		}
+		args[k] = a
+	}
+
+	if s.Scan() != scanner.EOF {
+		return "", nil, invalid
+	}
+	return name, args, serr
+}
+
+// directiveValue returns the value of the scanned token
+func directiveValue(s *scanner.Scanner, tok rune) (string, bool) {
+	switch tok {
+	case scanner.String:
+		v, err := strconv.Unquote(s.TokenText())
+		return v, err == nil
+
+	case scanner.Ident, scanner.Int, scanner.Float:
+		return s.TokenText(), true
+	}
+	return "", false
+}
+
+// directiveLines returns the directives setting the metadata, the inverse
+// of applyDirectives for the metadata that has a directive.
+func directiveLines(md Metadata) []string {
+	var lines []string
+
+	if md.Order.Var != "" && len(md.Order.Values) != 0 {
+		lines = append(lines, fmt.Sprintf("@order(var: %s, values: %s)",
+			strconv.Quote(md.Order.Var), directiveList(md.Order.Values)))
+	}
+	if md.Deprecated {
+		lines = append(lines, fmt.Sprintf("@deprecated(reason: %s)",
+			strconv.Quote(md.DeprecationReason)))
+	}
+	if len(md.Tags) != 0 {
+		lines = append(lines, fmt.Sprintf("@tags(values: %s)", directiveList(md.Tags)))
+	}
+	return lines
+}
+
+// directiveList returns the values as a directive list argument
+func directiveList(values []string) string {
+	q := make([]string, len(values))
+	for i, v := range values {
+		q[i] = strconv.Quote(v)
+	}
+	return "[" + strings.Join(q, ", ") + "]"
+}
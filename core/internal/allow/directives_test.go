@@ -0,0 +1,93 @@
+package allow
+
+import (
+	"bytes"
+	"log"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestGQLDirectives(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	gql := `# Fetch the users
+# @order(var: "sort", values: [asc, "desc"])
+# @deprecated(reason: "use getMembers")
+# @tags(values: [public, reporting])
+# @cache(ttl: 10)
+# @order(var: "sort")
+query getUsers { users(order_by: $sort) { id } }
+`
+	if err := afero.WriteFile(fs, "/queries/getUsers.gql", []byte(gql), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var logs bytes.Buffer
+	al, err := New(Config{Log: log.New(&logs, "", 0)}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items, err := al.Get("/queries/getUsers.gql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	md := items[0].Metadata
+
+	if md.Order.Var != "sort" || !reflect.DeepEqual(md.Order.Values, []string{"asc", "desc"}) {
+		t.Error("unexpected order: ", md.Order)
+	}
+
+	if !md.Deprecated || md.DeprecationReason != "use getMembers" {
+		t.Error("expected the query to be deprecated: ", md.DeprecationReason)
+	}
+
+	if !reflect.DeepEqual(md.Tags, []string{"public", "reporting"}) {
+		t.Error("unexpected tags: ", md.Tags)
+	}
+
+	exp := "Fetch the users\n@cache(ttl: 10)\n@order(var: \"sort\")"
+	if items[0].Comment != exp {
+		t.Errorf("expected the comment without the applied directives: %q", items[0].Comment)
+	}
+
+	for _, v := range []string{"unknown directive: @cache", "@order needs a var and values"} {
+		if !strings.Contains(logs.String(), v) {
+			t.Errorf("expected a warning '%s': %s", v, logs.String())
+		}
+	}
+}
+
+func TestParseDirective(t *testing.T) {
+	name, args, err := parseDirective(`@order(var: "sort", values: [asc, desc, 1])`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if name != "order" || args["var"].str != "sort" ||
+		!reflect.DeepEqual(args["values"].list, []string{"asc", "desc", "1"}) {
+		t.Fatal("unexpected directive: ", name, args)
+	}
+
+	if name, _, err := parseDirective(`@deprecated`); err != nil || name != "deprecated" {
+		t.Fatal("expected a directive without arguments: ", name, err)
+	}
+
+	invalid := []string{
+		`@`,
+		`@order(var "sort")`,
+		`@order(var: "sort"`,
+		`@order(var: [asc)`,
+		`@deprecated since v2`,
+		`@order(var: "sort") extra`,
+	}
+
+	for _, v := range invalid {
+		if _, _, err := parseDirective(v); err == nil {
+			t.Errorf("expected an error for: %s", v)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package allow
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+type exportDoc struct {
+	Queries   []Item       `yaml:"queries" json:"queries"`
+	Fragments []exportFrag `yaml:"fragments,omitempty" json:"fragments,omitempty"`
+}
+
+type exportFrag struct {
+	Namespace string `yaml:",omitempty" json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	Value     string `json:"value"`
+}
+
+// Export writes all the queries and fragments in the allow list to a single
+// document in the format (FormatYAML or FormatJSON).
+func (al *List) Export(w io.Writer, format string) error {
+	var doc exportDoc
+	var err error
+
+	if doc.Queries, err = al.Load(); err != nil {
+		return err
+	}
+
+	if doc.Fragments, err = al.exportFragments(doc.Queries); err != nil {
+		return err
+	}
+
+	switch format {
+	case FormatYAML:
+		y := yaml.NewEncoder(w)
+		y.SetIndent(al.conf.YAMLIndent)
+		if err := y.Encode(&doc); err != nil {
+			return err
+		}
+		return y.Close()
+
+	case FormatJSON:
+		e := json.NewEncoder(w)
+		e.SetIndent("", "  ")
+		return e.Encode(&doc)
+
+	default:
+		return fmt.Errorf("invalid allow list format: %s", format)
+	}
+}
+
+func (al *List) exportFragments(items []Item) ([]exportFrag, error) {
+	var frags []exportFrag
+
+	// fragments saved with a FragmentNamer cannot be listed so the ones
+	// used by the queries are exported
+	if al.conf.FragmentNamer != nil {
+		return al.usedFragments(items)
+	}
+
+	fi, err := al.listFiles(KindFragment, false)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]struct{})
+
+	for _, f := range fi {
+		if isLibraryFile(f.name()) {
+			continue
+		}
+
+		// skip fragments saved both with and without an extension
+		stem := fragmentStem(f.name())
+		if _, ok := seen[stem]; ok {
+			continue
+		}
+		seen[stem] = struct{}{}
+
+		ns, name := SplitName(stem)
+
+		v, err := al.readFragment(ns, name)
+		if err != nil {
+			return nil, fmt.Errorf("allow list: %w", err)
+		}
+		frags = append(frags, exportFrag{Namespace: ns, Name: name, Value: v})
+	}
+
+	// fragments in library files are exported on their own unless
+	// shadowed by a fragment file
+	for _, f := range fi {
+		if !isLibraryFile(f.name()) {
+			continue
+		}
+
+		ns, lf, err := al.readLibraryFile(f.path)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, v := range lf {
+			if _, ok := seen[fileName(ns, v.Name)]; ok {
+				continue
+			}
+			seen[fileName(ns, v.Name)] = struct{}{}
+			frags = append(frags, exportFrag{Namespace: ns, Name: v.Name, Value: v.Value})
+		}
+	}
+	return frags, nil
+}
+
+// Import reads a document written by Export in the format (FormatYAML or
+// FormatJSON) and saves the queries in it with SetMany along with the
+// fragments they use, so nothing is written unless every query is valid.
+func (al *List) Import(r io.Reader, format string, opts ...SetOption) error {
+	var doc exportDoc
+
+	if al.IsReadOnly() {
+		return ErrReadOnly
+	}
+
+	switch format {
+	case FormatYAML:
+		if err := yaml.NewDecoder(r).Decode(&doc); err != nil && err != io.EOF {
+			return fmt.Errorf("allow list: %w", err)
+		}
+
+	case FormatJSON:
+		if err := json.NewDecoder(r).Decode(&doc); err != nil {
+			return fmt.Errorf("allow list: %w", err)
+		}
+
+	default:
+		return fmt.Errorf("invalid allow list format: %s", format)
+	}
+
+	frags := make(map[string]string, len(doc.Fragments))
+	for _, v := range doc.Fragments {
+		frags[fileName(v.Namespace, v.Name)] = v.Value
+	}
+
+	used := make(map[string]struct{})
+	items := make([]SetItem, 0, len(doc.Queries))
+
+	for _, v := range doc.Queries {
+		if v.Name == "" {
+			return ErrNoQueryName
+		}
+
+		var sb strings.Builder
+		if v.Comment != "" {
+			sb.WriteString(gqlComment(v.Comment) + "\n")
+		}
+		sb.WriteString(v.Query)
+
+		for _, k := range importFragments(frags, v.Namespace, v.Query, used) {
+			sb.WriteString("\n\n" + frags[k])
+		}
+
+		var vars []byte
+		if v.Vars != "" {
+			vars = []byte(v.Vars)
+		}
+
+		items = append(items, SetItem{
+			Vars:  vars,
+			Query: sb.String(),
+			MD:    v.Metadata,
+			NS:    v.Namespace,
+		})
+	}
+
+	for _, v := range doc.Fragments {
+		if _, ok := used[fileName(v.Namespace, v.Name)]; !ok && al.conf.Log != nil {
+			al.conf.Log.Printf("WRN allow list: import: fragment not used by any query: %s",
+				fileName(v.Namespace, v.Name))
+		}
+	}
+
+	if len(items) == 0 {
+		return nil
+	}
+	return al.SetMany(items, opts...)
+}
+
+// importFragments returns the keys of the fragments spread in the query
+// including the ones spread in those fragments
+func importFragments(frags map[string]string, ns, query string, used map[string]struct{}) []string {
+	var keys []string
+	seen := make(map[string]struct{})
+	next := fragmentSpreads(query)
+
+	for len(next) != 0 {
+		name := next[0]
+		next = next[1:]
+
+		k := fileName(ns, name)
+		if _, ok := seen[k]; ok {
+			continue
+		}
+		seen[k] = struct{}{}
+
+		v, ok := frags[k]
+		if !ok {
+			continue
+		}
+		used[k] = struct{}{}
+		keys = append(keys, k)
+		next = append(next, fragmentSpreads(v)...)
+	}
+	return keys
+}
+
+// usedFragments returns the fragments used by the items and the
+// fragments they use
+func (al *List) usedFragments(items []Item) ([]exportFrag, error) {
+	var frags []exportFrag
+	seen := make(map[string]struct{})
+
+	for _, item := range items {
+		for _, f := range fragmentSpreads(item.Query) {
+			fl, err := al.resolveFragments(item.Namespace, f)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, v := range fl {
+				if _, ok := seen[fileName(item.Namespace, v.Name)]; ok {
+					continue
+				}
+				seen[fileName(item.Namespace, v.Name)] = struct{}{}
+				frags = append(frags, exportFrag{Namespace: item.Namespace, Name: v.Name, Value: v.Value})
+			}
+		}
+	}
+	return frags, nil
+}
+
+// ExportGQL writes the saved query as a .gql file with the comment, the
+// variables and the query followed by the fragments it uses, the format
+// read from .gql query files and by Set.
+func (al *List) ExportGQL(namespace, name string, w io.Writer) error {
+	item, err := al.GetByName(fileName(namespace, name))
+	if err != nil {
+		return err
+	}
+	if item.Query == "" {
+		return fmt.Errorf("%w: %s", ErrNotFound, fileName(namespace, name))
+	}
+
+	var sb strings.Builder
+	sb.Write(gqlBytes(item))
+
+	seen := make(map[string]struct{})
+
+	for _, fn := range fragmentSpreads(item.Query) {
+		frags, err := al.resolveFragments(item.Namespace, fn)
+		if err != nil {
+			return err
+		}
+		for _, f := range frags {
+			if _, ok := seen[f.Name]; ok {
+				continue
+			}
+			seen[f.Name] = struct{}{}
+			sb.WriteString("\n")
+			sb.WriteString(strings.TrimSpace(f.Value))
+			sb.WriteString("\n")
+		}
+	}
+	_, err = io.WriteString(w, sb.String())
+	return err
+}
@@ -0,0 +1,193 @@
+package allow
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestExportImport(t *testing.T) {
+	for _, format := range []string{FormatYAML, FormatJSON} {
+		t.Run(format, func(t *testing.T) {
+			testExportImport(t, format)
+		})
+	}
+}
+
+func testExportImport(t *testing.T, format string) {
+	al, err := New(Config{}, afero.NewMemMapFs())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var md Metadata
+	md.Order.Var = "order"
+	md.Order.Values = []string{"asc", "desc"}
+
+	q := `query getUser { user { ...User } }
+	fragment User on users { id }`
+
+	if err := al.SetSync([]byte(`{"id": 1}`), q, md, "tenant1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := al.SetSync(nil, `query getUsers { users { id } }`, Metadata{}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := al.Export(&buf, format); err != nil {
+		t.Fatal(err)
+	}
+
+	al1, err := New(Config{}, afero.NewMemMapFs())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := al1.Import(&buf, format); err != nil {
+		t.Fatal(err)
+	}
+
+	item, err := al1.GetByName("tenant1.getUser")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if item.Namespace != "tenant1" || item.Name != "getUser" {
+		t.Fatal("unexpected item: ", item.Namespace, item.Name)
+	}
+
+	if item.Metadata.Order.Var != "order" || len(item.Metadata.Order.Values) != 2 {
+		t.Fatal("metadata did not round-trip: ", item.Metadata)
+	}
+
+	if v, err := al1.FragmentFetcher("tenant1")("User"); err != nil || v == "" {
+		t.Fatal("expected the fragment to be imported", err)
+	}
+
+	if n, err := al1.Count(); err != nil || n != 2 {
+		t.Fatal("expected 2 queries, got ", n, err)
+	}
+}
+
+func TestImportComment(t *testing.T) {
+	al, err := New(Config{}, afero.NewMemMapFs())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc := "queries:\n- name: getUser\n  comment: users */ query getUsers { users { id } }\n  query: query getUser { user { id } }\n"
+	if err := al.Import(strings.NewReader(doc), FormatYAML); err != nil {
+		t.Fatal(err)
+	}
+
+	item, err := al.GetByName("getUser")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if item.Comment != "users * / query getUsers { users { id } }" || strings.Contains(item.Query, "getUsers") {
+		t.Fatalf("expected the comment not to end early, got %q: %q", item.Comment, item.Query)
+	}
+}
+
+func TestImportInvalid(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	al, err := New(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc := `
+queries:
+  - name: getUser
+    query: "query getUser { user { ...User } }"
+  - name: bad
+    query: "query bad { users { id }"
+fragments:
+  - name: User
+    value: "fragment User on users { id }"
+`
+	err = al.Import(strings.NewReader(doc), FormatYAML)
+
+	var berr BatchError
+	if !errors.As(err, &berr) || len(berr) != 1 || berr[0].Index != 1 {
+		t.Fatal("expected the invalid query to fail the import: ", err)
+	}
+
+	if n, err := al.Count(); err != nil || n != 0 {
+		t.Fatal("expected no queries to be saved, got ", n, err)
+	}
+
+	if ok, _ := afero.Exists(fs, "/fragments/User.gql"); ok {
+		t.Fatal("expected no fragments to be saved")
+	}
+}
+
+func TestExportGQL(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	al, err := New(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src := `/* Fetch a user with the name */
+query GetUser($id: Int!) {
+	user(id: $id) { id ...Name }
+}
+
+fragment Name on users { full_name ...Email }
+
+fragment Email on users { email }`
+
+	if err := al.SetSync([]byte(`{"id": 5}`), src, Metadata{}, "admin"); err != nil {
+		t.Fatal(err)
+	}
+
+	item, err := al.GetByName("admin.GetUser")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := al.ExportGQL("admin", "GetUser", &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := parseQuery(buf.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v.Comment != item.Comment || v.Vars != item.Vars || !sameQuery(v.Query, item.Query) {
+		t.Fatalf("expected the exported query to parse to the saved item, got:\n%s", buf.String())
+	}
+
+	orig, err := parseQuery(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(v.frags) != len(orig.frags) {
+		t.Fatalf("expected %d fragments, got %d", len(orig.frags), len(v.frags))
+	}
+	for _, f := range orig.frags {
+		found := false
+		for _, f1 := range v.frags {
+			found = found || (f1.Name == f.Name && sameQuery(f1.Value, f.Value))
+		}
+		if !found {
+			t.Fatal("expected the fragment to be exported: ", f.Name)
+		}
+	}
+
+	err = al.ExportGQL("admin", "GetUsers", &buf)
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatal("expected ErrNotFound, got ", err)
+	}
+}
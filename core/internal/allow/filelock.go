@@ -0,0 +1,160 @@
+package allow
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/spf13/afero"
+)
+
+// fileLock returns the lock of the file, held for writing while the file
+// is written or removed and for reading while it is read so a file is
+// never read half written. Names differing only in case share a lock
+// as they are the same file on case-insensitive filesystems.
+func (al *List) fileLock(fn string) *sync.RWMutex {
+	v, _ := al.files.LoadOrStore(strings.ToLower(filepath.Clean(fn)), &sync.RWMutex{})
+	return v.(*sync.RWMutex)
+}
+
+// readFile is readRawFile decompressing the file if the filename ends
+// in .gz
+func (al *List) readFile(fn string) ([]byte, error) {
+	b, err := al.readRawFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	return decompressFile(fn, b)
+}
+
+// readRawFile reads the file from the store holding the file lock,
+// compressed files are not decompressed
+func (al *List) readRawFile(fn string) ([]byte, error) {
+	l := al.fileLock(fn)
+	l.RLock()
+	defer l.RUnlock()
+
+	key, ok := al.storeKey(fn)
+	if !ok {
+		if fs := al.fsys(); fs != nil {
+			return afero.ReadFile(fs, fn)
+		}
+		return nil, &os.PathError{Op: "open", Path: fn, Err: errStorePath}
+	}
+
+	b, err := al.store.Get(key)
+	return b, pathError("open", fn, err)
+}
+
+// readGQL is parseGQLFile reading the file and its imports holding their
+// file locks
+func (al *List) readGQL(fn string) (string, error) {
+	query, err := parseGQLFile(al.readFile, fn)
+	if err != nil {
+		return "", fmt.Errorf("allow list: %w", err)
+	}
+	return query, nil
+}
+
+// writeFile writes the file to the store holding the file lock
+func (al *List) writeFile(fn string, data []byte) error {
+	l := al.fileLock(fn)
+	l.Lock()
+	defer l.Unlock()
+
+	key, ok := al.storeKey(fn)
+	if !ok {
+		if fs := al.fsys(); fs != nil {
+			return writeFile(fs, fn, data)
+		}
+		return &os.PathError{Op: "write", Path: fn, Err: errStorePath}
+	}
+	return pathError("write", fn, al.store.Put(key, data))
+}
+
+// removeFile removes the file from the store holding the file lock
+func (al *List) removeFile(fn string) error {
+	l := al.fileLock(fn)
+	l.Lock()
+	defer l.Unlock()
+
+	key, ok := al.storeKey(fn)
+	if !ok {
+		return &os.PathError{Op: "remove", Path: fn, Err: errStorePath}
+	}
+	return pathError("remove", fn, al.store.Delete(key))
+}
+
+// renameFile moves the file in the store holding the locks of both files,
+// stores that cannot rename files get a copy of the file
+func (al *List) renameFile(oldFn, newFn string) error {
+	oldKey, ok1 := al.storeKey(oldFn)
+	newKey, ok2 := al.storeKey(newFn)
+	if !ok1 || !ok2 {
+		return &os.LinkError{Op: "rename", Old: oldFn, New: newFn, Err: errStorePath}
+	}
+
+	l1, l2 := al.fileLock(oldFn), al.fileLock(newFn)
+	l1.Lock()
+	defer l1.Unlock()
+
+	// names differing only in case share a lock
+	if l2 != l1 {
+		l2.Lock()
+		defer l2.Unlock()
+	}
+
+	if s, ok := al.store.(storeRenamer); ok {
+		return pathError("rename", oldFn, s.Rename(oldKey, newKey))
+	}
+
+	data, err := al.store.Get(oldKey)
+	if err != nil {
+		return pathError("rename", oldFn, err)
+	}
+
+	if err := al.store.Put(newKey, data); err != nil {
+		return pathError("rename", newFn, err)
+	}
+
+	if oldKey == newKey {
+		return nil
+	}
+	return pathError("rename", oldFn, al.store.Delete(oldKey))
+}
+
+// exists reports whether the file is in the store without reading it
+func (al *List) exists(fn string) (bool, error) {
+	l := al.fileLock(fn)
+	l.RLock()
+	defer l.RUnlock()
+
+	key, ok := al.storeKey(fn)
+	if !ok {
+		if fs := al.fsys(); fs != nil {
+			return afero.Exists(fs, fn)
+		}
+		return false, nil
+	}
+	return al.store.Exists(key)
+}
+
+// pathError returns the store error as an *os.PathError so missing files
+// are reported with os.ErrNotExist by all the stores
+func pathError(op, fn string, err error) error {
+	var pe *os.PathError
+	var le *os.LinkError
+
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, ErrNotFound):
+		return &os.PathError{Op: op, Path: fn, Err: os.ErrNotExist}
+	case errors.As(err, &pe), errors.As(err, &le):
+		return err
+	}
+	return &os.PathError{Op: op, Path: fn, Err: err}
+}
@@ -0,0 +1,82 @@
+package allow
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+func TestYAMLGolden(t *testing.T) {
+	tests := []struct {
+		golden string
+		conf   Config
+	}{
+		{"default.yaml", Config{}},
+		{"indent4.yaml", Config{YAMLIndent: 4}},
+		{"sorted.yaml", Config{YAMLSortKeys: true}},
+	}
+
+	now := func() time.Time {
+		return time.Date(2022, 3, 4, 5, 6, 7, 0, time.UTC)
+	}
+
+	query := `# Fetch the user
+query GetUser($id: Int!) {
+	user(id: $id) { id full_name }
+}`
+
+	var md Metadata
+	md.Tags = []string{"users", "admin"}
+	md.AllowedVars = []string{"id"}
+	md.Order.Var = "order"
+	md.Order.Values = []string{"asc", "desc"}
+	md.RateLimit = &RateLimit{RequestsPerSecond: 10, Burst: 20}
+
+	for _, tt := range tests {
+		fs := afero.NewMemMapFs()
+
+		tt.conf.Now = now
+		al, err := New(tt.conf, fs)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := al.SetSync([]byte(`{"id": 1}`), query, md, "admin"); err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := afero.ReadFile(fs, "/queries/admin.GetUser.yaml")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fn := filepath.Join("testdata", "golden", tt.golden)
+		if *updateSchema {
+			if err := os.WriteFile(fn, b, 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+
+		exp, err := os.ReadFile(fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, exp) {
+			t.Fatalf("%s: unexpected output, run: go test -run TestYAMLGolden -update\n%s", tt.golden, b)
+		}
+
+		item, err := al.GetByName("admin.GetUser")
+		if err != nil || item.Metadata.RateLimit == nil || len(item.Metadata.Tags) != 2 {
+			t.Fatalf("%s: expected the file to be read back: %v", tt.golden, err)
+		}
+	}
+
+	if _, err := New(Config{YAMLIndent: -1}, afero.NewMemMapFs()); err == nil {
+		t.Fatal("expected an error for a negative indent")
+	}
+}
@@ -3,44 +3,198 @@ package allow
 import (
 	"bufio"
 	"bytes"
+	"encoding/json"
+	"fmt"
 	"path/filepath"
 	"regexp"
 	"strings"
 
-	"github.com/spf13/afero"
+	"github.com/chirino/graphql/schema"
 )
 
 var incRe = regexp.MustCompile(`(?m)#import \"(.+)\"`)
 
-func parseGQLFile(fs afero.Fs, fname string) (string, error) {
+// parseGQLFile returns the contents of the .gql file with the imported
+// files in place of the #import lines, read with read
+func parseGQLFile(read func(string) ([]byte, error), fname string) (string, error) {
 	var sb strings.Builder
 
-	if err := parseGQL(fs, fname, &sb); err != nil {
+	if err := parseGQL(read, fname, &sb); err != nil {
 		return "", err
 	}
 
 	return sb.String(), nil
 }
 
-func parseGQL(fs afero.Fs, fname string, sb *strings.Builder) error {
-	b, err := afero.ReadFile(fs, fname)
+func parseGQL(read func(string) ([]byte, error), fname string, sb *strings.Builder) error {
+	b, err := read(fname)
 	if err != nil {
 		return err
 	}
+	b = normalizeText(b)
 
 	s := bufio.NewScanner(bytes.NewReader(b))
 	for s.Scan() {
 		m := incRe.FindStringSubmatch(s.Text())
 		if len(m) == 0 {
 			sb.Write(s.Bytes())
+			sb.WriteByte('\n')
 			continue
 		}
 
 		fn := filepath.Join(filepath.Dir(fname), m[1])
-		if err := parseGQL(fs, fn, sb); err != nil {
+		if err := parseGQL(read, fn, sb); err != nil {
 			return err
 		}
 	}
 
 	return nil
 }
+
+// leadingVars returns the json object following the variables keyword at
+// the start of b and the text following it.
+func leadingVars(b string) (string, string, error) {
+	v := strings.TrimSpace(b)
+	if !strings.HasPrefix(v, "variables") {
+		return "", b, nil
+	}
+	v = v[len("variables"):]
+
+	var vars json.RawMessage
+	dec := json.NewDecoder(strings.NewReader(v))
+
+	if err := dec.Decode(&vars); err != nil {
+		return "", b, fmt.Errorf("%w: %s", ErrInvalidVars, err)
+	}
+	return string(vars), v[dec.InputOffset():], nil
+}
+
+// LoadBundle returns an item for each named operation in a single .graphql
+// file with many operations and fragments such as those written by code
+// generators. The fragments in the file are shared by all the operations,
+// each item has the fragments it uses. The items are not saved.
+func (al *List) LoadBundle(path string) ([]Item, error) {
+	query, err := parseGQLFile(al.readFile, path)
+	if err != nil {
+		return nil, fmt.Errorf("allow list: %w", err)
+	}
+
+	qd := &schema.QueryDocument{}
+	if err := qd.Parse(query); err != nil {
+		return nil, fmt.Errorf("allow list: %s: %w", path, err)
+	}
+
+	items, err := splitOperations(qd)
+	if err != nil {
+		return nil, fmt.Errorf("allow list: %s: %w", path, err)
+	}
+
+	seen := make(map[string]struct{}, len(items))
+	for i, v := range items {
+		if _, ok := seen[v.key]; ok {
+			return nil, fmt.Errorf("allow list: %s: %w: %s", path, ErrNameCollision, v.Name)
+		}
+		seen[v.key] = struct{}{}
+		items[i].source = path
+	}
+	return items, nil
+}
+
+// splitOperations returns an item for each named operation in the
+// query document. The fragments used by an operation are added to it.
+func splitOperations(qd *schema.QueryDocument) ([]Item, error) {
+	items := make([]Item, 0, len(qd.Operations))
+
+	for _, op := range qd.Operations {
+		if op.Name == "" {
+			return nil, fmt.Errorf("%w in a document with multiple operations", ErrAnonymousOperation)
+		}
+
+		var sb strings.Builder
+		op.WriteTo(&sb)
+		sb.WriteString("\n")
+
+		item := Item{
+			Name:   op.Name,
+			OpType: string(op.Type),
+			key:    strings.ToLower(op.Name),
+		}
+
+		for _, fn := range usedFragments(qd, sb.String()) {
+			var fb strings.Builder
+			qd.Fragments.Get(fn).WriteTo(&fb)
+			item.frags = append(item.frags, Frag{Name: fn, Value: fb.String()})
+
+			sb.WriteString(fb.String())
+			sb.WriteString("\n")
+		}
+
+		item.Query = sb.String()
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+// usedFragments returns the names of the fragments in the query document
+// that are spread in the query, including those spread by other fragments.
+func usedFragments(qd *schema.QueryDocument, query string) []string {
+	var names []string
+	seen := make(map[string]struct{})
+	st := fragmentSpreads(query)
+
+	for len(st) != 0 {
+		fn := st[0]
+		st = st[1:]
+
+		if _, ok := seen[fn]; ok {
+			continue
+		}
+		seen[fn] = struct{}{}
+
+		f := qd.Fragments.Get(fn)
+		if f == nil {
+			continue
+		}
+		names = append(names, fn)
+
+		var sb strings.Builder
+		f.WriteTo(&sb)
+		st = append(st, fragmentSpreads(sb.String())...)
+	}
+
+	return names
+}
+
+// gqlComment returns the text as a /* */ comment, a */ in the text would
+// end it early so it is broken up
+func gqlComment(s string) string {
+	return "/* " + strings.ReplaceAll(s, "*/", "* /") + " */"
+}
+
+// gqlBytes returns the item as a .gql file with the comment and the
+// directives for its metadata followed by the variables and the query,
+// the layout read by itemFromGQL.
+func gqlBytes(item Item) []byte {
+	var sb strings.Builder
+
+	lines := directiveLines(item.Metadata)
+	if item.Comment != "" {
+		lines = append([]string{item.Comment}, lines...)
+	}
+
+	if len(lines) != 0 {
+		sb.WriteString(gqlComment(strings.Join(lines, "\n")))
+		sb.WriteString("\n\n")
+	}
+
+	if item.Vars != "" {
+		sb.WriteString("variables ")
+		sb.WriteString(item.Vars)
+		sb.WriteString("\n\n")
+	}
+
+	sb.WriteString(strings.TrimSpace(item.Query))
+	sb.WriteString("\n")
+	return []byte(sb.String())
+}
@@ -0,0 +1,41 @@
+package allow
+
+import (
+	"sync"
+
+	"github.com/dosco/graphjin/core/internal/graph"
+)
+
+// headerCache is the parsed header of the query shared by the copies
+// of an item
+type headerCache struct {
+	mu    sync.Mutex
+	query string
+	ok    bool
+	h     graph.Header
+	err   error
+}
+
+// Header returns the operation type and name of the query. The header
+// is parsed on the first call and cached for the items read or saved by
+// the allow list, it is parsed again if the query is changed.
+func (i Item) Header() (graph.Header, error) {
+	c := i.header
+	if c == nil {
+		return graph.FastParse(i.Query)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.ok || c.query != i.Query {
+		c.h, c.err = graph.FastParse(i.Query)
+		c.query, c.ok = i.Query, true
+	}
+	return c.h, c.err
+}
+
+// setHeader caches the header already parsed from the item query
+func (i *Item) setHeader(h graph.Header) {
+	i.header = &headerCache{query: i.Query, ok: true, h: h}
+}
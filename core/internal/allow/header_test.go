@@ -0,0 +1,65 @@
+package allow
+
+import (
+	"testing"
+
+	"github.com/dosco/graphjin/core/internal/graph"
+	"github.com/spf13/afero"
+)
+
+func TestItemHeader(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	al, err := New(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := al.SetSync(nil, `mutation addUser { users(insert: $data) { id } }`, Metadata{}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	items, err := al.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	item := items[0]
+
+	// the header is parsed to check the operation name when read
+	if item.header == nil || !item.header.ok {
+		t.Fatal("expected the header parsed when read to be cached")
+	}
+
+	h, err := item.Header()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if h.Name != "addUser" || h.Type != graph.OpMutate {
+		t.Fatal("unexpected header: ", h)
+	}
+
+	// copies share the parsed header
+	cp := item
+	if !cp.header.ok || cp.header.h != h {
+		t.Fatal("expected the header to be cached")
+	}
+
+	cp.Query = `subscription getUsers { users { id } }`
+	if h, err := cp.Header(); err != nil || h.Name != "getUsers" || h.Type != graph.OpSub {
+		t.Fatal("expected the changed query to be parsed again: ", h, err)
+	}
+
+	if h, err := (Item{Query: `query getPosts { posts { id } }`}).Header(); err != nil || h.Name != "getPosts" {
+		t.Fatal("unexpected header: ", h, err)
+	}
+
+	v, err := al.prepareItem(Item{Query: `query getTags { tags { id } }`})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v.header == nil || !v.header.ok || v.header.h.Name != "getTags" {
+		t.Fatal("expected the header parsed when saving to be cached")
+	}
+}
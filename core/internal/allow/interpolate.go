@@ -0,0 +1,56 @@
+package allow
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// interpolateVars returns the variables json with each ${NAME} replaced
+// by the value of the environment variable and each ${NAME:-default} by
+// the value or the default when it's not set. The values are escaped to
+// be used in json strings.
+func interpolateVars(vars string) (string, error) {
+	var sb strings.Builder
+
+	for {
+		i := strings.Index(vars, "${")
+		if i == -1 {
+			break
+		}
+
+		j := strings.IndexByte(vars[i:], '}')
+		if j == -1 {
+			break
+		}
+
+		ref := vars[(i + 2):(i + j)]
+		name, def, hasDef := ref, "", false
+		if k := strings.Index(ref, ":-"); k != -1 {
+			name, def, hasDef = ref[:k], ref[(k+2):], true
+		}
+
+		v, ok := os.LookupEnv(name)
+		switch {
+		case ok:
+		case hasDef:
+			v = def
+		default:
+			return "", fmt.Errorf("%w: %s", ErrUnsetEnvVar, name)
+		}
+
+		sb.WriteString(vars[:i])
+		sb.WriteString(escapeJSON(v))
+		vars = vars[(i + j + 1):]
+	}
+
+	sb.WriteString(vars)
+	return sb.String(), nil
+}
+
+// escapeJSON returns the value escaped as in a json string
+func escapeJSON(v string) string {
+	b, _ := json.Marshal(v)
+	return string(b[1:(len(b) - 1)])
+}
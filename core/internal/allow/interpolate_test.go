@@ -0,0 +1,67 @@
+package allow
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestInterpolateVars(t *testing.T) {
+	t.Setenv("ALLOW_TENANT", `acme "inc"`)
+
+	fs := afero.NewMemMapFs()
+
+	files := map[string]string{
+		"/queries/GetSet.yaml": "name: GetSet\nquery: query GetSet { users { id } }\n" +
+			"vars: '{\"tenant\": \"${ALLOW_TENANT}\"}'\n",
+		"/queries/GetDefault.yaml": "name: GetDefault\nquery: query GetDefault { users { id } }\n" +
+			"vars: '{\"tenant\": \"${ALLOW_MISSING:-none}\", \"limit\": ${ALLOW_LIMIT:-10}}'\n",
+		"/queries/GetUnset.yaml": "name: GetUnset\nquery: query GetUnset { users { id } }\n" +
+			"vars: '{\"tenant\": \"${ALLOW_MISSING}\"}'\n",
+	}
+	for fn, v := range files {
+		if err := afero.WriteFile(fs, fn, []byte(v), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	al, err := New(Config{InterpolateVars: true}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	item, err := al.GetByName("GetSet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := "{\n  \"tenant\": \"acme \\\"inc\\\"\"\n}"; item.Vars != exp {
+		t.Fatalf("expected %q, got %q", exp, item.Vars)
+	}
+
+	item, err = al.GetByName("GetDefault")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := "{\n  \"tenant\": \"none\",\n  \"limit\": 10\n}"; item.Vars != exp {
+		t.Fatalf("expected %q, got %q", exp, item.Vars)
+	}
+
+	_, err = al.GetByName("GetUnset")
+	if !errors.Is(err, ErrUnsetEnvVar) {
+		t.Fatal("expected ErrUnsetEnvVar, got ", err)
+	}
+
+	al, err = New(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	item, err = al.GetByName("GetSet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := "{\n  \"tenant\": \"${ALLOW_TENANT}\"\n}"; item.Vars != exp {
+		t.Fatalf("expected the vars not to be interpolated by default, got %q", item.Vars)
+	}
+}
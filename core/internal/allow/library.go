@@ -0,0 +1,107 @@
+package allow
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/chirino/graphql/schema"
+)
+
+// libraryExt is the extension of fragment library files. A library file in
+// the fragment directory holds any number of fragment definitions used by
+// the queries in the namespace of its filename (<namespace>.<name>.graphqls)
+// or by the queries with no namespace. A fragment saved in its own file
+// takes precedence over one with the same name in a library file.
+const libraryExt = ".graphqls"
+
+func isLibraryFile(fn string) bool {
+	return strings.HasSuffix(fn, libraryExt)
+}
+
+// libraryFragment returns the named fragment from the library files
+func (al *List) libraryFragment(namespace, name string) (string, bool, error) {
+	al.libMu.Lock()
+	defer al.libMu.Unlock()
+
+	if al.library == nil {
+		lib, err := al.readLibrary()
+		if err != nil {
+			return "", false, err
+		}
+		al.library = lib
+	}
+
+	v, ok := al.library[fileName(namespace, name)]
+	return v, ok, nil
+}
+
+// resetLibrary drops the fragments read from the library files so they
+// are read again when next used
+func (al *List) resetLibrary() {
+	al.libMu.Lock()
+	al.library = nil
+	al.libMu.Unlock()
+}
+
+// readLibrary returns the fragments in all the library files keyed by
+// their namespace and name
+func (al *List) readLibrary() (map[string]string, error) {
+	lib := make(map[string]string)
+
+	fi, err := al.listFiles(KindFragment, false)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range fi {
+		if !isLibraryFile(f.name()) {
+			continue
+		}
+
+		ns, frags, err := al.readLibraryFile(f.path)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, v := range frags {
+			k := fileName(ns, v.Name)
+			if _, ok := lib[k]; ok && al.conf.Log != nil {
+				al.conf.Log.Printf("WRN allow list: %s: fragment '%s' is defined in another library file",
+					f.name(), v.Name)
+			}
+			lib[k] = v.Value
+		}
+	}
+	return lib, nil
+}
+
+// readLibraryFile returns the namespace and the fragments of the library file
+func (al *List) readLibraryFile(fn string) (string, []Frag, error) {
+	var frags []Frag
+
+	b, err := al.readRawFile(fn)
+	if err != nil {
+		return "", nil, fmt.Errorf("allow list: %w", err)
+	}
+
+	qd := &schema.QueryDocument{}
+	if err := qd.Parse(string(normalizeText(b))); err != nil {
+		return "", nil, fmt.Errorf("allow list: %s: %w", fn, err)
+	}
+
+	if len(qd.Operations) != 0 {
+		return "", nil, fmt.Errorf("allow list: %s: %w: library files can only have fragments",
+			fn, ErrInvalidFragment)
+	}
+
+	for _, f := range qd.Fragments {
+		var sb strings.Builder
+		f.WriteTo(&sb)
+		frags = append(frags, Frag{Name: f.Name, Value: sb.String()})
+	}
+
+	// the last part of the filename is the name of the library
+	ns, _ := SplitName(strings.TrimSuffix(filepath.Base(fn), libraryExt))
+	return ns, frags, nil
+}
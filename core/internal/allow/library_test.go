@@ -0,0 +1,82 @@
+package allow
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestLibrary(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	files := map[string]string{
+		"/fragments/common.graphqls": `
+fragment User on users { id ...Address }
+fragment Address on addresses { city }
+`,
+		"/fragments/tenant1.common.graphqls": `fragment Post on posts { id title }`,
+		"/fragments/Address.gql":             `fragment Address on addresses { city country }`,
+	}
+	for fn, v := range files {
+		if err := afero.WriteFile(fs, fn, []byte(v), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	al, err := New(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the fragment file shadows the library fragment
+	v, err := al.FragmentFetcher("")("User")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(v, "fragment User on users") || !strings.Contains(v, "country") {
+		t.Fatalf("unexpected fragments: %q", v)
+	}
+
+	if v, err := al.FragmentFetcher("tenant1")("Post"); err != nil || !strings.Contains(v, "title") {
+		t.Fatalf("expected the namespaced library fragment, got %q: %v", v, err)
+	}
+
+	if _, err := al.FragmentFetcher("")("Post"); err == nil {
+		t.Fatal("expected the namespaced fragment not to be found without the namespace")
+	}
+
+	if items, err := al.Get("/fragments/common.graphqls"); err != nil || len(items) != 0 {
+		t.Fatal("expected no items from a library file: ", len(items), err)
+	}
+
+	st, err := al.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if st.Fragments != 4 {
+		t.Fatal("expected the library fragments to be counted, got ", st.Fragments)
+	}
+
+	if n, err := al.MigrateFragments(); err != nil || n != 0 {
+		t.Fatal("expected the library files not to be migrated: ", n, err)
+	}
+
+	var buf bytes.Buffer
+	if err := al.Export(&buf, FormatYAML); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"name: User", "name: Address", "name: Post"} {
+		if !strings.Contains(buf.String(), name) {
+			t.Fatalf("expected %s to be exported", name)
+		}
+	}
+
+	if strings.Count(buf.String(), "name: Address") != 1 {
+		t.Fatal("expected the shadowed library fragment not to be exported")
+	}
+}
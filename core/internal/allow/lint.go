@@ -0,0 +1,104 @@
+package allow
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/chirino/graphql/schema"
+)
+
+// LintPascalCase is a linter (Config.Linters) that requires the query
+// name to be in PascalCase (GetUser).
+func LintPascalCase(item Item) error {
+	n := item.Name
+	if n == "" || n[0] < 'A' || n[0] > 'Z' || strings.Contains(n, "_") {
+		return fmt.Errorf("%w: query name '%s' is not PascalCase", ErrLint, n)
+	}
+	return nil
+}
+
+// listLimitArgs are the arguments that bound the rows returned by a field
+var listLimitArgs = []string{"limit", "first", "last", "id"}
+
+// LintUnboundedLists is a linter (Config.Linters) that requires the fields
+// selecting a list to have a limit, first, last or id argument. Fields with
+// plural names (ending in 's') and nested selections are taken to be lists
+// following the table naming convention used by GraphJin, a schema is not
+// used.
+func LintUnboundedLists(item Item) error {
+	var sb strings.Builder
+
+	sb.WriteString(item.Query)
+	for _, f := range item.frags {
+		sb.WriteString("\n" + f.Value)
+	}
+
+	qd := &schema.QueryDocument{}
+	if err := qd.Parse(sb.String()); err != nil {
+		return err
+	}
+
+	for _, op := range qd.Operations {
+		if op.Type == schema.Mutation {
+			continue
+		}
+		if f := unboundedList(qd, op.Selections, make(map[string]struct{})); f != "" {
+			return fmt.Errorf("%w: list field '%s' has no limit", ErrLint, f)
+		}
+	}
+	return nil
+}
+
+func unboundedList(qd *schema.QueryDocument, sel schema.SelectionList, seen map[string]struct{}) string {
+	for _, s := range sel {
+		switch v := s.(type) {
+		case *schema.FieldSelection:
+			if len(v.Selections) == 0 {
+				continue
+			}
+			if strings.HasSuffix(v.Name, "s") && !hasArg(v.Arguments, listLimitArgs) {
+				return v.Name
+			}
+			if f := unboundedList(qd, v.Selections, seen); f != "" {
+				return f
+			}
+
+		case *schema.InlineFragment:
+			if f := unboundedList(qd, v.Selections, seen); f != "" {
+				return f
+			}
+
+		case *schema.FragmentSpread:
+			fd := qd.Fragments.Get(v.Name)
+			if _, ok := seen[v.Name]; ok || fd == nil {
+				continue
+			}
+			seen[v.Name] = struct{}{}
+			if f := unboundedList(qd, fd.Selections, seen); f != "" {
+				return f
+			}
+		}
+	}
+	return ""
+}
+
+func hasArg(args schema.ArgumentList, names []string) bool {
+	for _, a := range args {
+		for _, n := range names {
+			if a.Name == n {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// lint runs the linters on the item returning the first error
+func (al *List) lint(item Item) error {
+	for _, fn := range al.conf.Linters {
+		if err := fn(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package allow
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestLintPascalCase(t *testing.T) {
+	tests := map[string]bool{
+		"GetUser":  true,
+		"getUser":  false,
+		"Get_User": false,
+		"G":        true,
+	}
+
+	for name, ok := range tests {
+		err := LintPascalCase(Item{Name: name})
+		if (err == nil) != ok {
+			t.Errorf("%s: expected ok %v, got %v", name, ok, err)
+		}
+	}
+}
+
+func TestLintUnboundedLists(t *testing.T) {
+	tests := []struct {
+		query string
+		ok    bool
+	}{
+		{`query GetUser { user(id: 1) { id } }`, true},
+		{`query GetUsers { users(limit: 10) { id } }`, true},
+		{`query GetUsers { users { id } }`, false},
+		{`query GetUser { user { id posts(first: 5) { id } } }`, true},
+		{`query GetUser { user { id posts { id } } }`, false},
+		{`query GetUser { user { ...User } } fragment User on users { posts { id } }`, false},
+		{`query GetUser { user { id status } }`, true},
+		{`mutation AddUsers { users(insert: $data) { id } }`, true},
+	}
+
+	for _, v := range tests {
+		item, err := parseQuery(v.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = LintUnboundedLists(item)
+		if (err == nil) != v.ok {
+			t.Errorf("%s: expected ok %v, got %v", v.query, v.ok, err)
+		}
+	}
+}
+
+func TestLinters(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	conf := Config{Linters: []func(Item) error{LintPascalCase, LintUnboundedLists}}
+	al, err := New(conf, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := al.SetSync(nil, `query GetUsers { users(limit: 10) { id } }`, Metadata{}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, q := range []string{
+		`query getUsers { users(limit: 10) { id } }`,
+		`query GetPosts { posts { id } }`,
+	} {
+		if err := al.SetSync(nil, q, Metadata{}, ""); !errors.Is(err, ErrLint) {
+			t.Fatalf("%s: expected ErrLint, got %v", q, err)
+		}
+	}
+
+	if n, err := al.Count(); err != nil || n != 1 {
+		t.Fatal("expected only the query passing the linters to be saved, got ", n, err)
+	}
+}
@@ -0,0 +1,163 @@
+package allow
+
+import (
+	"fmt"
+	"strings"
+	"text/scanner"
+
+	"github.com/dosco/graphjin/core/internal/graph"
+)
+
+// ItemMeta is a saved item without its query, variables and fragments
+type ItemMeta struct {
+	Namespace string   `json:"namespace,omitempty"`
+	Name      string   `json:"name"`
+	Comment   string   `json:"comment,omitempty"`
+	OpType    string   `json:"op_type,omitempty"`
+	VarsRef   string   `json:"vars_ref,omitempty"`
+	Metadata  Metadata `json:"metadata"`
+
+	// Source is the file the item was read from
+	Source string `json:"source,omitempty"`
+}
+
+func itemMeta(item Item) ItemMeta {
+	return ItemMeta{
+		Namespace: item.Namespace,
+		Name:      item.Name,
+		Comment:   item.Comment,
+		OpType:    item.OpType,
+		VarsRef:   item.VarsRef,
+		Metadata:  item.Metadata,
+		Source:    item.source,
+	}
+}
+
+// LoadMeta returns the metadata of all the saved items. The .gql files
+// with a single operation are not parsed, only their leading comment and
+// the operation name and type are read without the imported files.
+func (al *List) LoadMeta() ([]ItemMeta, error) {
+	var res []ItemMeta
+
+	files, err := al.queryFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	var d defaults
+
+	for _, f := range files {
+		if ext := fileExt(f); ext == ".gql" || ext == ".graphql" {
+			m, ok, err := al.gqlMeta(f, &d)
+			if err != nil {
+				if al.skipInvalid(err) {
+					continue
+				}
+				return nil, err
+			}
+			if ok {
+				res = append(res, m)
+				continue
+			}
+		}
+
+		items, err := al.get(f, &d)
+		if err != nil {
+			if al.skipInvalid(err) {
+				continue
+			}
+			return nil, err
+		}
+		for _, v := range items {
+			res = append(res, itemMeta(v))
+		}
+	}
+	return res, nil
+}
+
+// gqlMeta returns the metadata of the single operation in the .gql file,
+// false if the file has many operations
+func (al *List) gqlMeta(filePath string, d *defaults) (ItemMeta, bool, error) {
+	var md Metadata
+
+	queryNS, queryName := SplitName(fileStem(filePath))
+	if queryName == "" {
+		return ItemMeta{}, false, fmt.Errorf("invalid filename: %s", filePath)
+	}
+
+	// the imported files hold fragments so only the file itself is read
+	b, err := al.readFile(filePath)
+	if err != nil {
+		return ItemMeta{}, false, fmt.Errorf("allow list: %w", err)
+	}
+	query := incRe.ReplaceAllString(string(normalizeText(b)), "")
+
+	comment, rest := leadingComment(query)
+	if comment != "" {
+		query = rest
+		comment = al.applyDirectives(filePath, comment, &md)
+	}
+
+	if _, rest, err = leadingVars(query); err != nil {
+		return ItemMeta{}, false, fmt.Errorf("allow list: %s: %w", filePath, err)
+	}
+	query = rest
+
+	if countOperations(query) > 1 {
+		return ItemMeta{}, false, nil
+	}
+
+	h, err := graph.FastParse(query)
+	if err != nil {
+		return ItemMeta{}, false, fmt.Errorf("allow list: %s: %w", filePath, err)
+	}
+	if h.Name == "" {
+		return ItemMeta{}, false, fmt.Errorf("allow list: %s: %w: %s", filePath, ErrAnonymousOperation, queryName)
+	}
+	queryName = h.Name
+
+	item := Item{
+		Namespace: queryNS,
+		Name:      queryName,
+		Comment:   comment,
+		OpType:    opType(h.Type),
+		Metadata:  md,
+		source:    filePath,
+	}
+
+	if item, err = al.inheritMetadata(item, d); err != nil {
+		return ItemMeta{}, false, fmt.Errorf("allow list: %s: %w", filePath, err)
+	}
+	return itemMeta(item), true, nil
+}
+
+// countOperations returns the number of operations in the query counting
+// the operation keywords outside of the selections
+func countOperations(query string) int {
+	var s scanner.Scanner
+	s.Init(strings.NewReader(query))
+	s.Error = func(*scanner.Scanner, string) {}
+
+	n, depth := 0, 0
+
+	for tok := s.Scan(); tok != scanner.EOF; tok = s.Scan() {
+		switch tok {
+		case '#':
+			for ch := s.Peek(); ch != '\n' && ch != scanner.EOF; ch = s.Peek() {
+				s.Next()
+			}
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case scanner.Ident:
+			switch s.TokenText() {
+			case OpQuery, OpMutation, OpSubscription:
+				if depth == 0 {
+					n++
+				}
+			}
+		}
+	}
+	return n
+}
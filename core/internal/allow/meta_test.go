@@ -0,0 +1,86 @@
+package allow
+
+import (
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestLoadMeta(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	al, err := New(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := `# Fetch the users
+query getUsers { users { id } }`
+	if err := al.SetSync(nil, q, Metadata{Tags: []string{"public"}}, "tenant1"); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		"/queries/tenant1._namespace.yaml": "deprecated: true\n",
+		// the body is not parsed so the missing brace is not an error
+		"/queries/tenant1.getPosts.gql": "# Fetch the posts\n# @tags(values: [internal])\n" +
+			"variables { \"limit\": 10 }\n\nsubscription getPosts { posts(limit: $limit) { id ",
+		"/queries/bundle.gql": "query getTags { tags { id } }\nmutation addTag { tags(insert: $data) { id } }\n",
+		// the imported files are not read
+		"/queries/getUser.gql": "# Fetch a user\n#import \"./missing.gql\"\nquery getUser { user { ...User } }\n",
+	}
+	for fn, v := range files {
+		if err := afero.WriteFile(fs, fn, []byte(v), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	items, err := al.LoadMeta()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := make(map[string]ItemMeta, len(items))
+	for _, v := range items {
+		m[fileName(v.Namespace, v.Name)] = v
+	}
+
+	if len(m) != 5 {
+		t.Fatal("unexpected items: ", items)
+	}
+
+	v := m["tenant1.getUsers"]
+	if v.Comment != "Fetch the users" || !v.Metadata.HasTag("public") || !v.Metadata.Deprecated ||
+		v.OpType != OpQuery || v.Source != "/queries/tenant1.getUsers.yaml" {
+		t.Error("unexpected yaml item: ", v)
+	}
+
+	v = m["tenant1.getPosts"]
+	if v.Comment != "Fetch the posts" || !v.Metadata.HasTag("internal") || !v.Metadata.Deprecated ||
+		v.OpType != OpSubscription {
+		t.Error("unexpected gql item: ", v)
+	}
+
+	if v := m["getUser"]; v.Comment != "Fetch a user" || v.OpType != OpQuery {
+		t.Error("unexpected gql item with an import: ", v)
+	}
+
+	if v := m["addTag"]; v.OpType != OpMutation || v.Source != "/queries/bundle.gql" {
+		t.Error("expected an item per operation in the bundle: ", v)
+	}
+}
+
+func TestCountOperations(t *testing.T) {
+	tests := map[string]int{
+		`query getUser { user { id } }`:                                  1,
+		"# query a\nquery getUser { query { id } }":                      1,
+		`query a { a { id } } subscription b { b { id } }`:               2,
+		`fragment F on users { id } query a { a { ...F } } mutation b {`: 2,
+	}
+
+	for q, n := range tests {
+		if v := countOperations(q); v != n {
+			t.Errorf("%s: expected %d operations, got %d", q, n, v)
+		}
+	}
+}
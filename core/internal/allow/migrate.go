@@ -0,0 +1,188 @@
+package allow
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Migrate rewrites the files saved by earlier versions in the current
+// format and returns a line describing each change. Fragment files without
+// an extension are renamed to .gql files, fragments are saved without a
+// byte order mark or CRLF line endings and moved to the directory of their
+// namespace when the fragments are scoped (see ScopedFragments), and the
+// yaml and json query files are saved with the queries and variables
+// normalized as Set saves them. The .gql query files are written by hand
+// and are not changed. Files that cannot be parsed are logged and skipped.
+// Running it again changes nothing.
+func (al *List) Migrate() (report []string, err error) {
+	if al.IsReadOnly() {
+		return nil, ErrReadOnly
+	}
+
+	err = al.migrateFragments(func(oldFn, newFn string, renamed bool) {
+		if renamed {
+			report = append(report, fmt.Sprintf("renamed %s to %s", oldFn, newFn))
+		} else {
+			report = append(report, fmt.Sprintf("removed %s replaced by %s", oldFn, newFn))
+		}
+	})
+	if err != nil {
+		return report, err
+	}
+
+	fi, err := al.listFiles(KindFragment, false)
+	if err != nil {
+		return report, err
+	}
+
+	for _, f := range fi {
+		if !strings.HasSuffix(f.name(), fragmentExt) {
+			continue
+		}
+
+		fn := f.path
+		ok, err := al.migrateFragment(fn)
+		if err != nil {
+			return report, err
+		}
+		if ok {
+			report = append(report, "reformatted "+fn)
+		}
+	}
+
+	if al.conf.ScopedFragments {
+		err = al.migrateScopedFragments(func(oldFn, newFn string, moved bool) {
+			if moved {
+				report = append(report, fmt.Sprintf("moved %s to %s", oldFn, newFn))
+			} else {
+				report = append(report, fmt.Sprintf("removed %s replaced by %s", oldFn, newFn))
+			}
+		})
+		if err != nil {
+			return report, err
+		}
+	}
+
+	files, err := al.queryFiles()
+	if err != nil {
+		return report, err
+	}
+
+	for _, fn := range files {
+		ok, err := al.migrateQueryFile(fn)
+		if err != nil {
+			if al.conf.Log != nil {
+				al.conf.Log.Printf("WRN allow list: migrate: %s", err)
+			}
+			continue
+		}
+		if ok {
+			report = append(report, "reformatted "+fn)
+		}
+	}
+
+	if len(report) != 0 {
+		al.purgeCache()
+	}
+	return report, nil
+}
+
+// migrateFragment removes the byte order mark and CRLF line endings from
+// the fragment file and reports whether it was changed
+func (al *List) migrateFragment(fn string) (bool, error) {
+	b, err := al.readFile(fn)
+	if err != nil {
+		return false, fmt.Errorf("allow list: %w", err)
+	}
+
+	v := normalizeText(b)
+	if bytes.Equal(v, b) {
+		return false, nil
+	}
+
+	if err := al.writeFile(fn, v); err != nil {
+		return false, fmt.Errorf("allow list: %w", err)
+	}
+	return true, nil
+}
+
+// migrateQueryFile saves the items in the yaml or json file with the
+// queries and variables normalized and reports whether it was changed
+func (al *List) migrateQueryFile(fn string) (bool, error) {
+	var items []Item
+	var format string
+
+	switch fileExt(fn) {
+	case ".yml", ".yaml":
+		v, err := al.itemsFromYaml(fn)
+		if err != nil {
+			return false, err
+		}
+		items, format = v, FormatYAML
+
+	case ".json":
+		v, err := al.itemFromJSON(fn)
+		if err != nil {
+			return false, err
+		}
+		items, format = []Item{v}, FormatJSON
+
+	default:
+		return false, nil
+	}
+
+	if len(items) == 1 && items[0].Name == "" && items[0].Query == "" {
+		return false, nil
+	}
+
+	for i, v := range items {
+		item, err := al.migrateItem(v)
+		if err != nil {
+			return false, fmt.Errorf("%s: %w", fn, err)
+		}
+		items[i] = item
+	}
+
+	data, err := al.encodeItems(format, items)
+	if err != nil {
+		return false, err
+	}
+
+	b, err := al.readFile(fn)
+	if err != nil {
+		return false, fmt.Errorf("allow list: %w", err)
+	}
+	if bytes.Equal(data, b) {
+		return false, nil
+	}
+
+	if strings.HasSuffix(fn, gzipExt) {
+		if data, err = gzipBytes(data); err != nil {
+			return false, err
+		}
+	}
+
+	if err := al.writeFile(fn, data); err != nil {
+		return false, fmt.Errorf("allow list: %w", err)
+	}
+	return true, nil
+}
+
+// migrateItem returns the item with the query and variables normalized
+func (al *List) migrateItem(item Item) (Item, error) {
+	query, err := Normalize(item.Query)
+	if err != nil {
+		return item, err
+	}
+
+	if item.Query, err = formatQuery(query, al.conf.QueryFormat); err != nil {
+		return item, err
+	}
+
+	if item.Vars, err = normalizeVars(item.Vars); err != nil {
+		return item, err
+	}
+	item.Metadata.Tags = normalizeTags(item.Metadata.Tags)
+	return item, nil
+}
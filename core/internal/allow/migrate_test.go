@@ -0,0 +1,96 @@
+package allow
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestMigrate(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	files := map[string]string{
+		"/queries/GetUser.yaml": "name: GetUser\n" +
+			"query:     query GetUser { user { id ...Name } }\n" +
+			"vars: '{\"id\": 5}'\n",
+		"/queries/GetPosts.json":     `{"name":"GetPosts","query":"query GetPosts { posts { id } }"}`,
+		"/queries/GetTags.gql":       "query GetTags {\n tags { id }\n}",
+		"/queries/Broken.yaml":       "name: Broken\nquery: query Broken { users {\n",
+		"/fragments/Name":            `fragment Name on users { full_name }`,
+		"/fragments/Email.gql":       "\xEF\xBB\xBFfragment Email on users {\r\n email\r\n}",
+		"/fragments/common.graphqls": `fragment Common on users { id }`,
+	}
+	for fn, v := range files {
+		if err := afero.WriteFile(fs, fn, []byte(v), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var logs bytes.Buffer
+	al, err := New(Config{Log: log.New(&logs, "", 0)}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := al.Migrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []string{
+		"renamed /fragments/Name to /fragments/Name.gql",
+		"reformatted /fragments/Email.gql",
+		"reformatted /queries/GetPosts.json",
+		"reformatted /queries/GetUser.yaml",
+	}
+	if strings.Join(report, "\n") != strings.Join(exp, "\n") {
+		t.Fatalf("expected the report %q, got %q", exp, report)
+	}
+
+	if !strings.Contains(logs.String(), "Broken.yaml") {
+		t.Fatal("expected the broken file to be logged: ", logs.String())
+	}
+
+	b, err := afero.ReadFile(fs, "/queries/GetTags.gql")
+	if err != nil || string(b) != files["/queries/GetTags.gql"] {
+		t.Fatal("expected the .gql query file not to be changed: ", string(b), err)
+	}
+
+	b, err = afero.ReadFile(fs, "/fragments/Email.gql")
+	if err != nil || string(b) != "fragment Email on users {\n email\n}" {
+		t.Fatalf("expected the fragment to be normalized, got %q: %v", b, err)
+	}
+
+	item, err := al.GetByName("GetUser")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q, _ := Normalize(`query GetUser { user { id ...Name } }`); item.Query != q {
+		t.Fatalf("expected the query to be normalized, got %q", item.Query)
+	}
+	if strings.Contains(item.Vars, "5") {
+		t.Fatal("expected the variable values to be cleared: ", item.Vars)
+	}
+
+	report, err = al.Migrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report) != 0 {
+		t.Fatal("expected nothing to change the second time: ", report)
+	}
+}
+
+func TestMigrateReadOnly(t *testing.T) {
+	al, err := NewReadOnly(Config{}, afero.NewMemMapFs())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := al.Migrate(); err != ErrReadOnly {
+		t.Fatal("expected ErrReadOnly, got ", err)
+	}
+}
@@ -0,0 +1,41 @@
+//go:build go1.18
+// +build go1.18
+
+package allow
+
+import (
+	"strings"
+	"testing"
+)
+
+func FuzzParseQuery(f *testing.F) {
+	seeds := []string{
+		`query getUser { user { id } }`,
+		`/* comment */ variables { "id": 1 } query getUser { user(id: $id) { id } }`,
+		"# comment\nquery getUser { user { id } }",
+		`query getUser { user { id ...userFields } } fragment userFields on user { email }`,
+		`query getUser { user { id } } }`,
+		`query getUser { user { id `,
+		`variables { "id": `,
+		`/* unterminated`,
+		`query "unterminated`,
+		`fragment`,
+		`}`,
+	}
+	for _, v := range seeds {
+		f.Add(v)
+	}
+
+	f.Fuzz(func(t *testing.T, b string) {
+		item, err := parseQuery(b)
+		if err != nil {
+			return
+		}
+		if item.Query == "" {
+			t.Fatalf("no error and no query for %q", b)
+		}
+		if !strings.Contains(b, item.Query) {
+			t.Fatalf("query %q not in input %q", item.Query, b)
+		}
+	})
+}
@@ -0,0 +1,158 @@
+package allow
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// LoadFragments returns the body of every fragment in the namespace keyed
+// by name, including those in library files. With ScopedFragments the
+// fragments without a namespace are included unless the namespace has a
+// fragment with the same name, as FragmentFetcher falls back to them. The
+// fragments saved with a custom FragmentNamer cannot be listed so only
+// those used by the queries in the namespace are returned.
+func (al *List) LoadFragments(namespace string) (map[string]string, error) {
+	if err := validateNamespace(namespace); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case al.conf.ScopedFragments:
+		m := make(map[string]string)
+		if namespace != "" {
+			if err := al.scopedFragments("", m); err != nil {
+				return nil, err
+			}
+		}
+		if err := al.scopedFragments(namespace, m); err != nil {
+			return nil, err
+		}
+		return m, nil
+
+	case al.conf.FragmentNamer != nil:
+		items, err := al.LoadNamespace(namespace)
+		if err != nil {
+			return nil, err
+		}
+		frags, err := al.usedFragments(items)
+		if err != nil {
+			return nil, err
+		}
+		return fragmentMap(namespace, frags), nil
+
+	default:
+		return al.namespaceFragments(namespace)
+	}
+}
+
+// namespaceFragments returns the fragments in the namespace reading only
+// its fragment files, the library fragments are shadowed by those files
+func (al *List) namespaceFragments(namespace string) (map[string]string, error) {
+	m := make(map[string]string)
+
+	lib, err := al.readLibrary()
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range lib {
+		if ns, name := SplitName(k); ns == namespace {
+			m[name] = v
+		}
+	}
+
+	fi, err := al.listFiles(KindFragment, false)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range fi {
+		if isLibraryFile(f.name()) {
+			continue
+		}
+
+		ns, name := SplitName(fragmentStem(f.name()))
+		if ns != namespace || validateName(name) != nil {
+			continue
+		}
+
+		v, err := al.readFragment(ns, name)
+		if err != nil {
+			return nil, fmt.Errorf("allow list: %w", err)
+		}
+		m[name] = v
+	}
+	return m, nil
+}
+
+// scopedFragments adds the fragments in the directory of the namespace
+// and in the library files to m
+func (al *List) scopedFragments(namespace string, m map[string]string) error {
+	lib, err := al.readLibrary()
+	if err != nil {
+		return err
+	}
+	for k, v := range lib {
+		if ns, name := SplitName(k); ns == namespace {
+			m[name] = v
+		}
+	}
+
+	dir := filepath.ToSlash(filepath.Dir(scopedFragmentName(namespace, "")))
+
+	fi, err := al.listFiles(KindFragment, true)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range fi {
+		if path.Dir(f.rel) != dir || !strings.HasSuffix(f.name(), fragmentExt) {
+			continue
+		}
+
+		name := fragmentStem(f.name())
+		if validateName(name) != nil {
+			continue
+		}
+
+		v, err := al.readFragment(namespace, name)
+		if err != nil {
+			return fmt.Errorf("allow list: %w", err)
+		}
+		m[name] = v
+	}
+	return nil
+}
+
+func fragmentMap(namespace string, frags []exportFrag) map[string]string {
+	m := make(map[string]string)
+	for _, f := range frags {
+		if f.Namespace == namespace {
+			m[f.Name] = f.Value
+		}
+	}
+	return m
+}
+
+// FragmentFetcherFromMap returns a function that fetches a fragment by name
+// along with the fragments it uses from the map of fragment bodies keyed by
+// name, such as the one returned by LoadFragments, the same as
+// FragmentFetcher does without reading the files.
+func FragmentFetcherFromMap(m map[string]string) func(name string) (string, error) {
+	read := func(name string) (string, error) {
+		v, ok := m[name]
+		if !ok {
+			return "", fmt.Errorf("%w: fragment %s", ErrNotFound, name)
+		}
+		return v, nil
+	}
+
+	return func(name string) (string, error) {
+		frags, err := resolveFragments("", name, read)
+		if err != nil {
+			return "", err
+		}
+		return joinFragments(frags), nil
+	}
+}
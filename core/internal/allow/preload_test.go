@@ -0,0 +1,148 @@
+package allow
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestLoadFragments(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	frags := map[string]string{
+		"/fragments/admin.User.gql":          `fragment User on users { id ...Name }`,
+		"/fragments/admin.Name.gql":          `fragment Name on users { full_name }`,
+		"/fragments/Post.gql":                `fragment Post on posts { id }`,
+		"/fragments/admin.common.graphqls":   `fragment Email on users { email }`,
+		"/fragments/admin.Loop.gql":          `fragment Loop on users { ...Loop2 }`,
+		"/fragments/admin.Loop2.gql":         `fragment Loop2 on users { ...Loop }`,
+		"/fragments/admin.Missing.gql":       `fragment Missing on users { ...Unknown }`,
+		"/fragments/.admin.Name.gql.tmp1234": `fragment Name on users { id }`,
+	}
+	for fn, v := range frags {
+		if err := afero.WriteFile(fs, fn, []byte(v), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	al, err := New(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := al.LoadFragments("admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(m) != 6 || m["Email"] != "fragment Email on users {\n  email\n}" {
+		t.Fatalf("unexpected fragments: %q", m)
+	}
+
+	fetch := FragmentFetcherFromMap(m)
+
+	v, err := fetch("User")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v1, err := al.FragmentFetcher("admin")("User"); err != nil || v != v1 {
+		t.Fatalf("expected %q, got %q", v1, v)
+	}
+
+	if _, err := fetch("Loop"); !errors.Is(err, ErrFragmentCycle) {
+		t.Fatal("expected ErrFragmentCycle, got ", err)
+	}
+
+	if _, err := fetch("Missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatal("expected ErrNotFound, got ", err)
+	}
+
+	m, err = al.LoadFragments("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := map[string]string{"Post": frags["/fragments/Post.gql"]}; !reflect.DeepEqual(m, exp) {
+		t.Fatalf("expected %q, got %q", exp, m)
+	}
+}
+
+func TestLoadScopedFragments(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	frags := map[string]string{
+		"/fragments/_global/Name.gql":  `fragment Name on users { full_name }`,
+		"/fragments/_global/Post.gql":  `fragment Post on posts { id }`,
+		"/fragments/acme/Name.gql":     `fragment Name on users { first_name }`,
+		"/fragments/acme/User.gql":     `fragment User on users { id ...Name }`,
+		"/fragments/other/Secret.gql":  `fragment Secret on users { password }`,
+		"/fragments/acme.lib.graphqls": `fragment Email on users { email }`,
+	}
+	for fn, v := range frags {
+		if err := afero.WriteFile(fs, fn, []byte(v), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	al, err := New(Config{ScopedFragments: true}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := al.LoadFragments("acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := map[string]string{
+		"Name":  frags["/fragments/acme/Name.gql"],
+		"Post":  frags["/fragments/_global/Post.gql"],
+		"User":  frags["/fragments/acme/User.gql"],
+		"Email": "fragment Email on users {\n  email\n}",
+	}
+	if !reflect.DeepEqual(m, exp) {
+		t.Fatalf("expected %q, got %q", exp, m)
+	}
+
+	v, err := FragmentFetcherFromMap(m)("User")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v1, err := al.FragmentFetcher("acme")("User"); err != nil || v != v1 {
+		t.Fatalf("expected %q, got %q", v1, v)
+	}
+}
+
+func TestLoadFragmentsReadsNamespace(t *testing.T) {
+	s := newMapStore()
+
+	al, err := NewWithStore(Config{}, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ns := range []string{"admin", "tenant1", "tenant2"} {
+		q := `query getUser { user { ...User } } fragment User on users { id }`
+		if err := al.SetSync(nil, q, Metadata{}, ns); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s.mu.Lock()
+	s.gets = 0
+	s.mu.Unlock()
+
+	m, err := al.LoadFragments("admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(m) != 1 || m["User"] == "" {
+		t.Fatalf("unexpected fragments: %q", m)
+	}
+
+	if s.gets != 1 {
+		t.Fatalf("expected only the fragment in the namespace to be read, got %d reads", s.gets)
+	}
+}
@@ -0,0 +1,76 @@
+package allow
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+)
+
+// ReloadOn reloads the allow list each time the process receives the
+// signal (eg. syscall.SIGHUP) and emits the added and changed items on the
+// returned channel, for when filesystem events cannot be used (see Watch).
+// The items are reloaded with Load so the index, the hashes and the cache
+// are rebuilt and the removed items dropped. The returned function stops
+// the reloads and closes the channel.
+func (al *List) ReloadOn(sig os.Signal) (<-chan Item, func()) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, sig)
+
+	ch, stop := al.reloadOn(sigCh)
+	return ch, func() {
+		signal.Stop(sigCh)
+		stop()
+	}
+}
+
+func (al *List) reloadOn(sigCh <-chan os.Signal) (<-chan Item, func()) {
+	ch := make(chan Item)
+	done := make(chan struct{})
+	exited := make(chan struct{})
+
+	var prev []Item
+	_ = al.Range(func(item Item) error {
+		prev = append(prev, item)
+		return nil
+	})
+
+	go func() {
+		defer close(exited)
+		defer close(ch)
+
+		for {
+			select {
+			case <-done:
+				return
+
+			case <-sigCh:
+				items, err := al.Load()
+				if err != nil {
+					if al.conf.Log != nil {
+						al.conf.Log.Println("WRN allow list reload:", err)
+					}
+					continue
+				}
+
+				added, _, changed := Diff(prev, items)
+				prev = items
+
+				for _, v := range append(added, changed...) {
+					select {
+					case ch <- v:
+					case <-done:
+						return
+					}
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			close(done)
+			<-exited
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package allow
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+func TestReloadOn(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	al, err := New(Config{EnableIndex: true}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, q := range []string{`query getUser { user { id } }`, `query getPosts { posts { id } }`} {
+		if err := al.SetSync(nil, q, Metadata{}, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	ch, stop := al.reloadOn(sigCh)
+	defer stop()
+
+	// changed outside of the allow list like by a deploy
+	files := map[string]string{
+		"/queries/getUser.yaml": "name: getUser\nquery: query getUser { user { id email } }\n",
+		"/queries/getTags.yaml": "name: getTags\nquery: query getTags { tags { id } }\n",
+	}
+	for fn, v := range files {
+		if err := afero.WriteFile(fs, fn, []byte(v), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := fs.Remove("/queries/getPosts.yaml"); err != nil {
+		t.Fatal(err)
+	}
+
+	sigCh <- os.Interrupt
+
+	var names []string
+	for len(names) != 2 {
+		select {
+		case v := <-ch:
+			names = append(names, v.Name)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for the reloaded items: ", names)
+		}
+	}
+	sort.Strings(names)
+
+	if strings.Join(names, ",") != "getTags,getUser" {
+		t.Fatal("unexpected items: ", names)
+	}
+
+	if item, err := al.GetByName("getUser"); err != nil || !strings.Contains(item.Query, "email") {
+		t.Fatal("expected the index to be reloaded: ", item.Query, err)
+	}
+
+	if item, err := al.GetByName("getPosts"); err != nil || item.Name != "" {
+		t.Fatal("expected the removed item to be dropped from the index: ", item, err)
+	}
+
+	stop()
+	if _, ok := <-ch; ok {
+		t.Fatal("expected the channel to be closed")
+	}
+	stop()
+}
+
+func TestReloadOnStop(t *testing.T) {
+	al, err := New(Config{}, afero.NewMemMapFs())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, stop := al.ReloadOn(os.Interrupt)
+	stop()
+}
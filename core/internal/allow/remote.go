@@ -0,0 +1,133 @@
+package allow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+// ManifestFile is the file at the base url listing the allow list files
+// since object stores like S3 cannot list directories over http
+const ManifestFile = "manifest.json"
+
+// Manifest lists the files in the queries and fragments directories
+// (names relative to the directory) of a remote allow list
+type Manifest struct {
+	Queries   []string `json:"queries"`
+	Fragments []string `json:"fragments"`
+}
+
+// NewFromURL returns a read-only allow list with the files listed in the
+// manifest at baseURL fetched over http(s) and kept in memory. Fragments
+// that are listed but not found (404) are skipped with a warning and
+// reported as missing by FragmentFetcher.
+func NewFromURL(ctx context.Context, baseURL string) (*List, error) {
+	return newFromURL(ctx, http.DefaultClient, baseURL, Config{})
+}
+
+func newFromURL(ctx context.Context, c *http.Client, baseURL string, conf Config) (*List, error) {
+	// the directories are set on a copy as NewReadOnly sets up the config
+	dirs := conf
+	if err := dirs.init(); err != nil {
+		return nil, err
+	}
+
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("allow list: %w", err)
+	}
+
+	b, err := fetch(ctx, c, u, ManifestFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("allow list: %s: %w", ManifestFile, err)
+	}
+
+	fs := afero.NewMemMapFs()
+	_ = fs.MkdirAll(dirs.QueryDir, os.ModePerm)
+	_ = fs.MkdirAll(dirs.FragmentDir, os.ModePerm)
+
+	for _, fn := range m.Queries {
+		b, err := fetch(ctx, c, u, path.Join(queryPath, fn))
+		if err != nil {
+			return nil, err
+		}
+		if err := afero.WriteFile(fs, filepath.Join(dirs.QueryDir, path.Base(fn)), b, 0600); err != nil {
+			return nil, fmt.Errorf("allow list: %w", err)
+		}
+	}
+
+	for _, fn := range m.Fragments {
+		// fragments in subdirectories (like scoped fragments) keep their
+		// path, cleaning leaves no .. in it
+		fn = strings.TrimPrefix(path.Clean("/"+fn), "/")
+		if fn == "" {
+			continue
+		}
+
+		b, err := fetch(ctx, c, u, path.Join(fragmentPath, fn))
+		if err == errRemoteNotFound {
+			if dirs.Log != nil {
+				dirs.Log.Printf("WRN allow list: fragment not found: %s", fn)
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		ffn := filepath.Join(dirs.FragmentDir, filepath.FromSlash(fn))
+		if err := fs.MkdirAll(filepath.Dir(ffn), os.ModePerm); err != nil {
+			return nil, fmt.Errorf("allow list: %w", err)
+		}
+		if err := afero.WriteFile(fs, ffn, b, 0600); err != nil {
+			return nil, fmt.Errorf("allow list: %w", err)
+		}
+	}
+
+	return NewReadOnly(conf, fs)
+}
+
+var errRemoteNotFound = fmt.Errorf("allow list: remote file %w", ErrNotFound)
+
+// fetch returns the body of the file at the path relative to the base url
+func fetch(ctx context.Context, c *http.Client, base *url.URL, p string) ([]byte, error) {
+	u := *base
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + strings.TrimPrefix(p, "/")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("allow list: %w", err)
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("allow list: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, errRemoteNotFound
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("allow list: %s: %s", u.String(), resp.Status)
+	}
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("allow list: %s: %w", u.String(), err)
+	}
+	return b, nil
+}
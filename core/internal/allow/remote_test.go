@@ -0,0 +1,98 @@
+package allow
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNewFromURL(t *testing.T) {
+	files := map[string]string{
+		"/allow/manifest.json":           `{ "queries": ["getUser.yaml"], "fragments": ["User.gql", "Missing.gql"] }`,
+		"/allow/queries/getUser.yaml":    "name: getUser\nquery: query getUser { user { ...User } }\n",
+		"/allow/fragments/User.gql":      `fragment User on users { id }`,
+		"/allow/queries/notListed.yaml":  "name: notListed\nquery: query notListed { user { id } }\n",
+		"/allow/fragments/NotListed.gql": `fragment NotListed on users { id }`,
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(v)) //nolint:errcheck
+	}))
+	defer ts.Close()
+
+	al, err := NewFromURL(context.Background(), ts.URL+"/allow/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := al.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(list) != 1 || list[0].Name != "getUser" {
+		t.Fatal("expected only the listed query")
+	}
+
+	fetch := al.FragmentFetcher("")
+
+	if v, err := fetch("User"); err != nil || v != files["/allow/fragments/User.gql"] {
+		t.Fatal("unexpected fragment: ", v, err)
+	}
+
+	if _, err := fetch("Missing"); err == nil {
+		t.Fatal("expected an error fetching a missing fragment")
+	}
+
+	if err := al.Set(nil, `query getUser { user { id } }`, Metadata{}, ""); !errors.Is(err, ErrReadOnly) {
+		t.Fatal("expected ErrReadOnly, got ", err)
+	}
+
+	if _, err := NewFromURL(context.Background(), ts.URL+"/missing"); err == nil {
+		t.Fatal("expected an error without a manifest")
+	}
+}
+
+func TestNewFromURLScoped(t *testing.T) {
+	files := map[string]string{
+		"/manifest.json":              `{ "queries": ["acme.getUser.yaml"], "fragments": ["acme/User.gql", "_global/User.gql", "../acme/Email.gql"] }`,
+		"/queries/acme.getUser.yaml":  "name: getUser\nnamespace: acme\nquery: query getUser { user { ...User } }\n",
+		"/fragments/acme/User.gql":    `fragment User on users { id ...Email }`,
+		"/fragments/_global/User.gql": `fragment User on users { email }`,
+		"/fragments/acme/Email.gql":   `fragment Email on users { email }`,
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(v)) //nolint:errcheck
+	}))
+	defer ts.Close()
+
+	al, err := newFromURL(context.Background(), http.DefaultClient, ts.URL, Config{ScopedFragments: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for ns, exp := range map[string]string{"acme": "id", "": "email"} {
+		v, err := al.FragmentFetcher(ns)("User")
+		if err != nil || !strings.Contains(v, exp) {
+			t.Fatalf("%s: expected the fragment in its namespace directory, got %q: %v", ns, v, err)
+		}
+	}
+
+	if v, err := al.FragmentFetcher("acme")("Email"); err != nil || v == "" {
+		t.Fatal("expected the fragment with a cleaned path: ", v, err)
+	}
+}
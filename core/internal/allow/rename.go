@@ -0,0 +1,181 @@
+package allow
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/scanner"
+)
+
+// Rename moves the query saved under the old namespace and name to the
+// new ones changing the operation name in the query. The fragments it uses
+// are saved in the new namespace and those no longer used in the old one
+// are removed. It fails with ErrNameCollision if a query is already saved
+// under the new name unless WithOverwrite is passed.
+func (al *List) Rename(oldNS, oldName, newNS, newName string, opts ...SetOption) error {
+	if al.IsReadOnly() {
+		return ErrReadOnly
+	}
+
+	if oldName == "" || newName == "" {
+		return ErrNoQueryName
+	}
+
+	if err := validateNames(oldNS, oldName); err != nil {
+		return err
+	}
+
+	if err := validateNames(newNS, newName); err != nil {
+		return err
+	}
+
+	if oldNS == newNS && oldName == newName {
+		return nil
+	}
+
+	r := saveReq{
+		op:     opRename,
+		item:   Item{Namespace: oldNS, Name: oldName},
+		target: Item{Namespace: newNS, Name: newName},
+		reply:  make(chan error, 1),
+	}
+	for _, o := range opts {
+		o(&r)
+	}
+	if err := al.enqueue(r, true); err != nil {
+		return err
+	}
+	return <-r.reply
+}
+
+func (al *List) rename(r saveReq) (Item, error) {
+	oldNS, newNS := r.item.Namespace, r.target.Namespace
+
+	src, err := al.findFileFold(fileName(oldNS, r.item.Name))
+	if err != nil {
+		return r.item, err
+	}
+
+	if src == "" {
+		return r.item, fmt.Errorf("%w: %s", ErrNotFound, fileName(oldNS, r.item.Name))
+	}
+
+	if isGQLFile(src) {
+		return r.item, fmt.Errorf("%w: %s", ErrSourceFile, src)
+	}
+
+	d := defaults{saved: true}
+	items, err := al.get(src, &d)
+	if err != nil {
+		return r.item, err
+	}
+
+	// renaming would drop the other operations saved in the file
+	if len(items) != 1 {
+		return r.item, fmt.Errorf("allow list: cannot rename '%s', the file has %d operations: %s",
+			r.item.Name, len(items), src)
+	}
+	old := items[0]
+
+	item := Item{
+		Namespace: newNS,
+		Comment:   old.Comment,
+		Vars:      old.Vars,
+		VarsRef:   old.VarsRef,
+		Metadata:  old.Metadata,
+	}
+	item.Metadata.UpdatedAt = al.savedTime()
+	if item.Metadata.CreatedAt.IsZero() {
+		item.Metadata.CreatedAt = item.Metadata.UpdatedAt
+	}
+
+	// keep the shared variables as a reference
+	if item.VarsRef != "" {
+		if defs, err := al.defaults(&d); err == nil && defs[item.VarsRef] == item.Vars {
+			item.Vars = ""
+		}
+	}
+
+	if item.Query, err = renameOperation(old.Query, r.target.Name); err != nil {
+		return r.item, err
+	}
+
+	for _, f := range fragmentSpreads(old.Query) {
+		frags, err := al.resolveFragments(oldNS, f)
+		if err != nil {
+			return r.item, err
+		}
+		item.frags = append(item.frags, frags...)
+	}
+
+	if item, err = al.prepareItem(item); err != nil {
+		return item, err
+	}
+
+	dst, err := al.findFileFold(fileName(newNS, item.Name))
+	if err != nil {
+		return item, err
+	}
+
+	// a rename changing only the case of the name is saved in the same file
+	sameFile := dst == src
+	if sameFile {
+		dst = filepath.Join(filepath.Dir(src),
+			fileName(newNS, item.Name)+strings.TrimPrefix(filepath.Base(src), fileStem(src)))
+
+		if err := al.renameFile(src, dst); err != nil {
+			return item, fmt.Errorf("allow list: %w", err)
+		}
+	} else if dst != "" && !r.overwrite {
+		return item, fmt.Errorf("%w: %s", ErrNameCollision, dst)
+	}
+
+	if err := al.checkFragments(item, r.overwrite); err != nil {
+		return item, err
+	}
+
+	fn, err := al.saveItem(item, dst)
+	if err != nil {
+		return item, err
+	}
+
+	if !sameFile {
+		if err := al.remove(oldNS, old.Name, oldNS != newNS); err != nil {
+			return item, err
+		}
+	}
+
+	al.updateIndex(old, true)
+	al.indexSaved(item, fn)
+	return item, nil
+}
+
+// renameOperation returns the query with the operation name changed
+func renameOperation(query, name string) (string, error) {
+	var s scanner.Scanner
+	s.Init(strings.NewReader(query))
+	s.Error = func(*scanner.Scanner, string) {}
+
+	for tok := s.Scan(); tok != scanner.EOF; tok = s.Scan() {
+		if tok == '#' {
+			for ch := s.Peek(); ch != '\n' && ch != scanner.EOF; ch = s.Peek() {
+				s.Next()
+			}
+			continue
+		}
+
+		switch s.TokenText() {
+		case OpQuery, OpMutation, OpSubscription:
+		default:
+			continue
+		}
+
+		if tok = s.Scan(); tok != scanner.Ident {
+			break
+		}
+
+		st := s.Position.Offset
+		return query[:st] + name + query[st+len(s.TokenText()):], nil
+	}
+	return "", ErrNoQueryName
+}
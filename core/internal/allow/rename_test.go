@@ -0,0 +1,116 @@
+package allow
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestRename(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	al, err := New(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queries := []string{
+		`query getUser { user { ...User } } fragment User on users { id email }`,
+		`query getUsers { users { ...User } } fragment User on users { id email }`,
+		`query getPosts { posts { ...Post } } fragment Post on posts { id title }`,
+	}
+	for _, q := range queries {
+		if err := al.SetSync([]byte(`{"id": 1}`), q, Metadata{Tags: []string{"public"}}, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := al.Rename("", "getPosts", "tenant1", "getArticles"); err != nil {
+		t.Fatal(err)
+	}
+
+	item, err := al.GetByName("tenant1.getArticles")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(item.Query, "query getArticles") || item.Vars == "" ||
+		!item.Metadata.HasTag("public") {
+		t.Fatal("unexpected renamed item: ", item)
+	}
+
+	files := map[string]bool{
+		"/queries/getPosts.yaml":            false,
+		"/queries/tenant1.getArticles.yaml": true,
+		"/fragments/Post.gql":               false,
+		"/fragments/tenant1.Post.gql":       true,
+	}
+	for fn, exists := range files {
+		if ok, _ := afero.Exists(fs, fn); ok != exists {
+			t.Errorf("%s: expected exists to be %v", fn, exists)
+		}
+	}
+
+	// the fragment is still used by getUsers
+	if err := al.Rename("", "getUser", "tenant1", "getUser"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, fn := range []string{"/fragments/User.gql", "/fragments/tenant1.User.gql"} {
+		if ok, _ := afero.Exists(fs, fn); !ok {
+			t.Errorf("expected the fragment file %s", fn)
+		}
+	}
+
+	err = al.Rename("", "getUsers", "tenant1", "getArticles")
+	if !errors.Is(err, ErrNameCollision) {
+		t.Fatal("expected ErrNameCollision, got ", err)
+	}
+
+	if err := al.Rename("", "getUsers", "tenant1", "getArticles", WithOverwrite()); err != nil {
+		t.Fatal(err)
+	}
+
+	if item, err := al.GetByName("tenant1.getArticles"); err != nil || !strings.Contains(item.Query, "users") {
+		t.Fatal("expected the query to be overwritten: ", item.Query, err)
+	}
+
+	if err := al.Rename("tenant1", "getUser", "tenant1", "GetUser"); err != nil {
+		t.Fatal(err)
+	}
+
+	names, err := al.Names()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(names, ",") != "tenant1.GetUser,tenant1.getArticles" {
+		t.Fatal("unexpected names: ", names)
+	}
+
+	if err := al.Rename("", "getUser", "", "getMember"); !errors.Is(err, ErrNotFound) {
+		t.Fatal("expected ErrNotFound, got ", err)
+	}
+
+	if err := al.Rename("", "getUser", "a.b", "getMember"); !errors.Is(err, ErrInvalidNamespace) {
+		t.Fatal("expected ErrInvalidNamespace, got ", err)
+	}
+}
+
+func TestRenameOperation(t *testing.T) {
+	q, err := renameOperation(`# query old
+query getUser($id: ID!) { user(id: $id) { id } }`, "getMember")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if q != "# query old\nquery getMember($id: ID!) { user(id: $id) { id } }" {
+		t.Fatal("unexpected query: ", q)
+	}
+
+	if _, err := renameOperation(`query { user { id } }`, "getMember"); !errors.Is(err, ErrNoQueryName) {
+		t.Fatal("expected ErrNoQueryName, got ", err)
+	}
+}
@@ -0,0 +1,106 @@
+package allow
+
+import (
+	_ "embed"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// ItemJSONSchema is the JSON Schema of the items saved in the yaml and json
+// formats, to validate them in editors and pre-commit hooks. It is generated
+// from the Item struct, run the tests with -update after changing it.
+//
+//go:embed schema/item.json
+var ItemJSONSchema []byte
+
+const schemaDraft = "http://json-schema.org/draft-07/schema#"
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// itemSchema generates the JSON Schema of Item from its yaml field tags
+func itemSchema() ([]byte, error) {
+	s := typeSchema(reflect.TypeOf(Item{}))
+	s["$schema"] = schemaDraft
+	s["title"] = "GraphJin allow list item"
+
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+func typeSchema(t reflect.Type) map[string]interface{} {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		// yaml timestamps (2006-01-02) as well as RFC 3339 are accepted
+		return map[string]interface{}{"type": "string"}
+
+	case t.Kind() == reflect.String:
+		return map[string]interface{}{"type": "string"}
+
+	case t.Kind() == reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+
+	case t.Kind() == reflect.Int:
+		return map[string]interface{}{"type": "integer", "minimum": 0}
+
+	case t.Kind() == reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem())}
+
+	case t.Kind() == reflect.Struct:
+		props := make(map[string]interface{})
+		var req []string
+		structSchema(t, props, &req)
+
+		s := map[string]interface{}{
+			"type":                 "object",
+			"properties":           props,
+			"additionalProperties": false,
+		}
+		if len(req) != 0 {
+			s["required"] = req
+		}
+		return s
+	}
+	return map[string]interface{}{}
+}
+
+// structSchema adds the yaml fields of t to props merging the inlined
+// structs and the fields without omitempty to req
+func structSchema(t reflect.Type, props map[string]interface{}, req *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+
+		name, opts := f.Tag.Get("yaml"), ""
+		if n := strings.IndexByte(name, ','); n != -1 {
+			name, opts = name[:n], name[n:]
+		}
+		if name == "-" {
+			continue
+		}
+
+		if strings.Contains(opts, ",inline") {
+			structSchema(f.Type, props, req)
+			continue
+		}
+
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		props[name] = typeSchema(f.Type)
+
+		if !strings.Contains(opts, ",omitempty") {
+			*req = append(*req, name)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package allow
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"testing"
+
+	"github.com/spf13/afero"
+	"gopkg.in/yaml.v3"
+)
+
+var updateSchema = flag.Bool("update", false, "regenerate schema/item.json and the golden files")
+
+func TestItemJSONSchema(t *testing.T) {
+	b, err := itemSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *updateSchema {
+		if err := os.WriteFile("schema/item.json", b, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	if !bytes.Equal(b, ItemJSONSchema) {
+		t.Fatal("schema/item.json is out of date with the Item struct, run: go test -run TestItemJSONSchema -update")
+	}
+}
+
+func TestItemJSONSchemaFields(t *testing.T) {
+	var s struct {
+		Properties map[string]struct {
+			Properties map[string]interface{} `json:"properties"`
+		} `json:"properties"`
+		Required []string `json:"required"`
+	}
+	if err := json.Unmarshal(ItemJSONSchema, &s); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := afero.NewMemMapFs()
+	al, err := New(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	md := Metadata{Deprecated: true, AllowedVars: []string{"id"}}
+	md.Order.Var = "order"
+	md.Order.Values = []string{"asc", "desc"}
+	md.Subscription = &SubscriptionMetadata{HeartbeatSeconds: 10}
+
+	err = al.SetSync([]byte(`{"id": 1}`),
+		`subscription getUser { user(id: $id) { id } }`, md, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := afero.ReadFile(fs, "/queries/admin.getUser.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var item map[string]interface{}
+	if err := yaml.Unmarshal(b, &item); err != nil {
+		t.Fatal(err)
+	}
+
+	for k, v := range item {
+		p, ok := s.Properties[k]
+		if !ok {
+			t.Errorf("saved field '%s' not in the schema", k)
+			continue
+		}
+		if m, ok := v.(map[string]interface{}); ok {
+			for k1 := range m {
+				if _, ok := p.Properties[k1]; !ok {
+					t.Errorf("saved field '%s.%s' not in the schema", k, k1)
+				}
+			}
+		}
+	}
+
+	for _, k := range s.Required {
+		if _, ok := item[k]; !ok {
+			t.Errorf("required field '%s' not saved", k)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package allow
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// globalScope is the directory of the fragments without a namespace
+// when the fragments are scoped
+const globalScope = "_global"
+
+// scopedFragmentName is the FragmentNamer used for ScopedFragments
+func scopedFragmentName(namespace, name string) string {
+	if namespace == "" {
+		namespace = globalScope
+	}
+	return filepath.Join(namespace, name+fragmentExt)
+}
+
+// scopedFragment returns the fragment in the namespace falling back to
+// the fragment without a namespace when the fragments are scoped
+func (al *List) scopedFragment(namespace, name string) (string, error) {
+	v, err := al.readFragment(namespace, name)
+	if os.IsNotExist(err) && al.conf.ScopedFragments && namespace != "" {
+		return al.readFragment("", name)
+	}
+	return v, err
+}
+
+// migrateScopedFragments moves the fragment files saved in the default
+// layout to the directory of their namespace calling fn with the old and
+// new filename of each file moved. If the fragment is already in the
+// namespace directory the old file is removed.
+func (al *List) migrateScopedFragments(fn func(oldFn, newFn string, moved bool)) error {
+	fi, err := al.listFiles(KindFragment, false)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range fi {
+		if !strings.HasSuffix(f.name(), fragmentExt) {
+			continue
+		}
+
+		ns, name := SplitName(fragmentStem(f.name()))
+		if validateNames(ns, name) != nil {
+			continue
+		}
+
+		oldFn := f.path
+		newFn, err := al.fragmentFile(ns, name)
+		if err != nil {
+			return err
+		}
+
+		ok, _ := al.exists(newFn)
+		if ok {
+			err = al.removeFile(oldFn)
+		} else {
+			err = al.renameFile(oldFn, newFn)
+		}
+
+		if err != nil {
+			return fmt.Errorf("allow list: %w", err)
+		}
+		fn(oldFn, newFn, !ok)
+	}
+	return nil
+}
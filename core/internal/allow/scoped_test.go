@@ -0,0 +1,99 @@
+package allow
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestScopedFragments(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	al, err := New(Config{ScopedFragments: true}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queries := map[string]string{
+		"": `query GetUser { user { id ...Name } }
+fragment Name on users { full_name }`,
+		"acme": `query GetUser { user { id ...Name } }
+fragment Name on users { first_name last_name }`,
+	}
+	for ns, q := range queries {
+		if err := al.SetSync(nil, q, Metadata{}, ns); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, fn := range []string{"/fragments/_global/Name.gql", "/fragments/acme/Name.gql"} {
+		if ok, _ := afero.Exists(fs, fn); !ok {
+			t.Fatal("expected the fragment file: ", fn)
+		}
+	}
+
+	v, err := al.FragmentFetcher("acme")("Name")
+	if err != nil || !strings.Contains(v, "first_name") {
+		t.Fatalf("expected the namespace fragment, got %q: %v", v, err)
+	}
+
+	v, err = al.FragmentFetcher("other")("Name")
+	if err != nil || !strings.Contains(v, "full_name") {
+		t.Fatalf("expected the global fragment, got %q: %v", v, err)
+	}
+
+	if _, err := New(Config{ScopedFragments: true, FragmentNamer: scopedFragmentName}, fs); err == nil {
+		t.Fatal("expected an error using scoped fragments with a fragment namer")
+	}
+}
+
+func TestMigrateScopedFragments(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	frags := map[string]string{
+		"/fragments/Name.gql":         `fragment Name on users { full_name }`,
+		"/fragments/acme.Name.gql":    `fragment Name on users { first_name }`,
+		"/fragments/acme.Email":       `fragment Email on users { email }`,
+		"/fragments/common.graphqls":  `fragment Common on users { id }`,
+		"/fragments/_global/Post.gql": `fragment Post on posts { id }`,
+	}
+	for fn, v := range frags {
+		if err := afero.WriteFile(fs, fn, []byte(v), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	al, err := New(Config{ScopedFragments: true}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := al.Migrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []string{
+		"renamed /fragments/acme.Email to /fragments/acme.Email.gql",
+		"moved /fragments/Name.gql to /fragments/_global/Name.gql",
+		"moved /fragments/acme.Email.gql to /fragments/acme/Email.gql",
+		"moved /fragments/acme.Name.gql to /fragments/acme/Name.gql",
+	}
+	if strings.Join(report, "\n") != strings.Join(exp, "\n") {
+		t.Fatalf("expected the report %q, got %q", exp, report)
+	}
+
+	if ok, _ := afero.Exists(fs, "/fragments/common.graphqls"); !ok {
+		t.Fatal("expected the library file not to be moved")
+	}
+
+	v, err := al.FragmentFetcher("acme")("Email")
+	if err != nil || v != frags["/fragments/acme.Email"] {
+		t.Fatalf("expected the moved fragment, got %q: %v", v, err)
+	}
+
+	if report, err = al.Migrate(); err != nil || len(report) != 0 {
+		t.Fatal("expected nothing to change the second time: ", report, err)
+	}
+}
@@ -0,0 +1,93 @@
+package allow
+
+import (
+	"fmt"
+	"path"
+)
+
+// Stats is the size of the allow list
+type Stats struct {
+	// Items is the number of saved queries
+	Items int
+
+	// Namespaces is the number of saved queries in each namespace with
+	// the queries without a namespace under the empty string
+	Namespaces map[string]int
+
+	// Fragments is the number of saved fragments
+	Fragments int
+
+	// Bytes is the total size of the query and fragment files
+	Bytes int64
+}
+
+// Stats returns the size of the allow list. Only files that can have
+// multiple operations (.gql and .graphql) are read, the others are
+// counted as one query. If some files cannot be read the stats for the
+// rest are returned with the error.
+func (al *List) Stats() (Stats, error) {
+	var errs []error
+	st := Stats{Namespaces: make(map[string]int)}
+
+	fi, err := al.listFiles(KindQuery, false)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	for _, f := range fi {
+		if isDefaultsFile(f.name()) || isNamespaceFile(f.name()) || !isQueryFile(f.name()) {
+			continue
+		}
+		st.Bytes += f.Size
+
+		n := 1
+		if ext := fileExt(f.name()); ext == ".gql" || ext == ".graphql" {
+			items, err := al.Get(f.path)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			n = len(items)
+		}
+
+		ns, _ := SplitName(fileStem(f.name()))
+		st.Namespaces[ns] += n
+		st.Items += n
+	}
+
+	// scoped fragments are saved in a directory for each namespace
+	seen := make(map[string]struct{})
+	ff, err := al.listFiles(KindFragment, true)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	for _, f := range ff {
+		if f.link {
+			continue
+		}
+		st.Bytes += f.Size
+
+		if isLibraryFile(f.name()) {
+			_, frags, err := al.readLibraryFile(f.path)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			st.Fragments += len(frags)
+			continue
+		}
+
+		// fragments saved both with and without an extension
+		k := path.Join(path.Dir(f.rel), fragmentStem(f.name()))
+		if _, ok := seen[k]; !ok {
+			seen[k] = struct{}{}
+			st.Fragments++
+		}
+	}
+
+	if len(errs) != 0 {
+		return st, fmt.Errorf("allow list: partial stats: %w (%d errors)", errs[0], len(errs))
+	}
+	return st, nil
+}
@@ -0,0 +1,359 @@
+package allow
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// Kinds of the files kept in a Store
+const (
+	KindQuery    = "query"
+	KindFragment = "fragment"
+)
+
+// StoreKey identifies a file kept in a Store
+type StoreKey struct {
+	// Kind is KindQuery for the query files (including the defaults and
+	// namespace files) or KindFragment for the fragment files
+	Kind      string
+	Namespace string
+
+	// Name is prefixed with the slash separated subdirectory of the
+	// files in one (like scoped fragments)
+	Name string
+
+	// Ext is the file extension including the .gz of compressed files,
+	// empty for fragments saved without one
+	Ext string
+}
+
+// StoreFile is a file listed by a Store. The modification time is used
+// to poll for changes (see Watch).
+type StoreFile struct {
+	Key     StoreKey
+	Size    int64
+	ModTime time.Time
+
+	// link is set for the symlinks listed by the default store
+	link bool
+}
+
+// Store keeps the allow list files in a database or other shared storage
+// so that many servers can use the same allow list. Get and Delete return
+// an error wrapping ErrNotFound for a missing key. Exists is used to check
+// for a file without reading it. List returns the files of the kind in
+// all the subdirectories. See NewWithStore.
+type Store interface {
+	Get(key StoreKey) ([]byte, error)
+	Exists(key StoreKey) (bool, error)
+	Put(key StoreKey, data []byte) error
+	List(kind string) ([]StoreFile, error)
+	Delete(key StoreKey) error
+}
+
+// storeRenamer is implemented by the stores that can move a file without
+// reading and writing it again
+type storeRenamer interface {
+	Rename(oldKey, newKey StoreKey) error
+}
+
+// NewWithStore returns an allow list keeping its files in the store.
+// Everything works the same as with files except for the imports in .gql
+// files outside the query directory, LoadBundle and the checksum manifest
+// which are only read from the filesystem of the default store (see New
+// and NewFsStore).
+func NewWithStore(conf Config, s Store) (*List, error) {
+	if s == nil {
+		return nil, fmt.Errorf("no store defined for the allow list")
+	}
+
+	if err := conf.init(); err != nil {
+		return nil, err
+	}
+	return newList(conf, s)
+}
+
+// NewFsStore returns a store keeping the files in the query and fragment
+// directories of the filesystem as the allow list does by default.
+func NewFsStore(fs afero.Fs, queryDir, fragmentDir string) Store {
+	return newFsStore(fs, Config{QueryDir: queryDir, FragmentDir: fragmentDir})
+}
+
+func newFsStore(fs afero.Fs, conf Config) *fsStore {
+	return &fsStore{fs: fs, log: conf.Log, dirs: map[string]string{
+		KindQuery:    filepath.Clean(conf.QueryDir),
+		KindFragment: filepath.Clean(conf.FragmentDir),
+	}}
+}
+
+// storeKey returns the key of the file at the slash separated path
+// relative to the directory of the kind
+func storeKey(kind, rel string) StoreKey {
+	dir, base := path.Split(rel)
+	ext := storeExt(base)
+	stem := strings.TrimSuffix(base, ext)
+
+	// names that would not give back the filename are kept whole
+	ns, name := SplitName(stem)
+	if name == "" || fileName(ns, name) != stem {
+		ns, name = "", stem
+	}
+	return StoreKey{Kind: kind, Namespace: ns, Name: dir + name, Ext: ext}
+}
+
+// storeExt returns the extension of the filename if it is a file type of
+// the allow list so the legacy fragments saved without an extension keep
+// their namespace and name
+func storeExt(fn string) string {
+	gz := ""
+	if strings.HasSuffix(fn, gzipExt) {
+		gz, fn = gzipExt, strings.TrimSuffix(fn, gzipExt)
+	}
+
+	switch ext := filepath.Ext(fn); ext {
+	case ".gql", ".graphql", ".yml", ".yaml", ".json", libraryExt:
+		return ext + gz
+	}
+	return gz
+}
+
+// path returns the slash separated path of the file relative to the
+// directory of its kind
+func (k StoreKey) path() string {
+	dir, name := path.Split(k.Name)
+	return dir + fileName(k.Namespace, name) + k.Ext
+}
+
+// fsStore keeps the files in the query and fragment directories of
+// a filesystem
+type fsStore struct {
+	fs   afero.Fs
+	log  *log.Logger
+	dirs map[string]string
+}
+
+func (s *fsStore) path(key StoreKey) (string, error) {
+	dir, ok := s.dirs[key.Kind]
+	if !ok {
+		return "", fmt.Errorf("allow list: unknown store kind: %s", key.Kind)
+	}
+	return filepath.Join(dir, filepath.FromSlash(key.path())), nil
+}
+
+func (s *fsStore) Get(key StoreKey) ([]byte, error) {
+	fn, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := afero.ReadFile(s.fs, fn)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, fn)
+	}
+	return b, err
+}
+
+func (s *fsStore) Exists(key StoreKey) (bool, error) {
+	fn, err := s.path(key)
+	if err != nil {
+		return false, err
+	}
+	return afero.Exists(s.fs, fn)
+}
+
+func (s *fsStore) Put(key StoreKey, data []byte) error {
+	fn, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := s.fs.MkdirAll(filepath.Dir(fn), os.ModePerm); err != nil {
+		return err
+	}
+	return writeFile(s.fs, fn, data)
+}
+
+func (s *fsStore) Delete(key StoreKey) error {
+	fn, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := s.fs.Remove(fn); os.IsNotExist(err) {
+		return fmt.Errorf("%w: %s", ErrNotFound, fn)
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *fsStore) Rename(oldKey, newKey StoreKey) error {
+	oldFn, err := s.path(oldKey)
+	if err != nil {
+		return err
+	}
+
+	newFn, err := s.path(newKey)
+	if err != nil {
+		return err
+	}
+
+	if err := s.fs.MkdirAll(filepath.Dir(newFn), os.ModePerm); err != nil {
+		return err
+	}
+	return s.fs.Rename(oldFn, newFn)
+}
+
+// List returns the files in the directory of the kind, the query files
+// are only listed from the query directory itself. Symlinks to files are
+// listed, links to directories are skipped and broken links or link loops
+// are logged.
+func (s *fsStore) List(kind string) ([]StoreFile, error) {
+	dir, ok := s.dirs[kind]
+	if !ok {
+		return nil, fmt.Errorf("allow list: unknown store kind: %s", kind)
+	}
+
+	if ok, err := afero.DirExists(s.fs, dir); !ok {
+		return nil, err
+	}
+
+	var files []StoreFile
+	err := afero.Walk(s.fs, dir, func(fn string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if f.IsDir() {
+			if fn != dir && (kind == KindQuery || s.isDir(fn)) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if isTempFile(f.Name()) {
+			return nil
+		}
+
+		link := f.Mode()&os.ModeSymlink != 0
+		if link {
+			if f, err = s.fs.Stat(fn); err != nil {
+				if s.log != nil {
+					s.log.Printf("WRN allow list: skipping symlink: %s", err)
+				}
+				return nil
+			}
+		}
+
+		if !f.Mode().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, fn)
+		if err != nil {
+			return err
+		}
+
+		files = append(files, StoreFile{
+			Key:     storeKey(kind, filepath.ToSlash(rel)),
+			Size:    f.Size(),
+			ModTime: f.ModTime(),
+			link:    link,
+		})
+		return nil
+	})
+	return files, err
+}
+
+// isDir reports whether the path is the directory of a kind
+func (s *fsStore) isDir(fn string) bool {
+	for _, d := range s.dirs {
+		if filepath.Clean(fn) == d {
+			return true
+		}
+	}
+	return false
+}
+
+// fsys returns the filesystem of the default store or nil for other
+// stores. The files outside the query and fragment directories are only
+// read from it.
+func (al *List) fsys() afero.Fs {
+	if s, ok := al.store.(*fsStore); ok {
+		return s.fs
+	}
+	return nil
+}
+
+var errStorePath = errors.New("path not kept in the store")
+
+// storeKey returns the key of the file in the store, false if the path is
+// not in the query or fragment directory
+func (al *List) storeKey(fn string) (StoreKey, bool) {
+	fn = filepath.Clean(fn)
+
+	// the fragment directory can be inside the query directory
+	for _, d := range []struct{ dir, kind string }{
+		{al.conf.FragmentDir, KindFragment},
+		{al.conf.QueryDir, KindQuery},
+	} {
+		rel, err := filepath.Rel(filepath.Clean(d.dir), fn)
+		if err != nil || rel == "." || rel == ".." ||
+			strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		return storeKey(d.kind, filepath.ToSlash(rel)), true
+	}
+	return StoreKey{}, false
+}
+
+// storePath returns the path of the file in the allow list
+func (al *List) storePath(key StoreKey) string {
+	dir := al.conf.QueryDir
+	if key.Kind == KindFragment {
+		dir = al.conf.FragmentDir
+	}
+	return filepath.Join(dir, filepath.FromSlash(key.path()))
+}
+
+// storedFile is a file listed in the store with its path in the allow list
+// and the slash separated path relative to the directory of its kind
+type storedFile struct {
+	StoreFile
+	path, rel string
+}
+
+// name returns the filename without the directory
+func (f storedFile) name() string {
+	return path.Base(f.rel)
+}
+
+// listFiles returns the files of the kind in the store sorted by path,
+// those in subdirectories when nested is set
+func (al *List) listFiles(kind string, nested bool) ([]storedFile, error) {
+	sf, err := al.store.List(kind)
+	if err != nil {
+		return nil, fmt.Errorf("allow list: %w", err)
+	}
+
+	var files []storedFile
+	for _, f := range sf {
+		rel := f.Key.path()
+		if isTempFile(rel) || (!nested && strings.Contains(rel, "/")) {
+			continue
+		}
+		files = append(files, storedFile{StoreFile: f, path: al.storePath(f.Key), rel: rel})
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].path < files[j].path })
+	return files, nil
+}
@@ -0,0 +1,239 @@
+package allow
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+// mapStore keeps the files in a map like a database backed store
+type mapStore struct {
+	mu   sync.Mutex
+	m    map[StoreKey][]byte
+	gets int
+}
+
+func newMapStore() *mapStore {
+	return &mapStore{m: make(map[StoreKey][]byte)}
+}
+
+func (s *mapStore) Get(key StoreKey) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.gets++
+	v, ok := s.m[key]
+	if !ok {
+		return nil, fmt.Errorf("%w: %v", ErrNotFound, key)
+	}
+	return append([]byte(nil), v...), nil
+}
+
+func (s *mapStore) Exists(key StoreKey) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.m[key]
+	return ok, nil
+}
+
+func (s *mapStore) Put(key StoreKey, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.m[key] = append([]byte(nil), data...)
+	return nil
+}
+
+func (s *mapStore) List(kind string) ([]StoreFile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var files []StoreFile
+	for k, v := range s.m {
+		if k.Kind == kind {
+			files = append(files, StoreFile{Key: k, Size: int64(len(v))})
+		}
+	}
+	return files, nil
+}
+
+func (s *mapStore) Delete(key StoreKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.m[key]; !ok {
+		return fmt.Errorf("%w: %v", ErrNotFound, key)
+	}
+	delete(s.m, key)
+	return nil
+}
+
+func (s *mapStore) keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var keys []string
+	for k := range s.m {
+		keys = append(keys, k.Kind+":"+k.path())
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestStore(t *testing.T) {
+	s := newMapStore()
+
+	al, err := NewWithStore(Config{}, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queries := []string{
+		`query getUser { user { ...User } } fragment User on users { id email }`,
+		`query getPosts { posts { id } }`,
+	}
+	for _, q := range queries {
+		if err := al.SetSync([]byte(`{"id": 1}`), q, Metadata{}, "tenant1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	exp := "fragment:tenant1.User.gql,query:tenant1.getPosts.yaml,query:tenant1.getUser.yaml"
+	if v := strings.Join(s.keys(), ","); v != exp {
+		t.Fatal("unexpected keys: ", v)
+	}
+
+	// a second server sharing the store
+	al1, err := NewWithStore(Config{}, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items, err := al1.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(items) != 2 {
+		t.Fatal("expected the items saved by the other server, got ", items)
+	}
+
+	if v, err := al1.FragmentFetcher("tenant1")("User"); err != nil || !strings.Contains(v, "email") {
+		t.Fatal("expected the fragment: ", v, err)
+	}
+
+	if err := al1.Rename("tenant1", "getPosts", "", "getArticles"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := al.Remove("tenant1", "getUser", true); err != nil {
+		t.Fatal(err)
+	}
+
+	if v := strings.Join(s.keys(), ","); v != "query:getArticles.yaml" {
+		t.Fatal("unexpected keys: ", v)
+	}
+
+	if _, err := al.GetByName("getArticles"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStoreExists(t *testing.T) {
+	s := newMapStore()
+
+	al, err := NewWithStore(Config{}, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := al.SetSync(nil, `query getUser { user { id } }`, Metadata{}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	s.mu.Lock()
+	s.gets = 0
+	s.mu.Unlock()
+
+	for name, exp := range map[string]bool{"getUser": true, "getUsers": false} {
+		if ok, err := al.Has("", name); err != nil || ok != exp {
+			t.Fatalf("%s: expected %v, got %v: %v", name, exp, ok, err)
+		}
+	}
+
+	if s.gets != 0 {
+		t.Fatalf("expected the files not to be read to check they exist, got %d reads", s.gets)
+	}
+}
+
+func TestStoreKey(t *testing.T) {
+	tests := map[string]StoreKey{
+		"getUser.yaml":            {Kind: KindQuery, Name: "getUser", Ext: ".yaml"},
+		"tenant1.getUser.json.gz": {Kind: KindQuery, Namespace: "tenant1", Name: "getUser", Ext: ".json.gz"},
+		"_defaults.yaml":          {Kind: KindQuery, Name: "_defaults", Ext: ".yaml"},
+		"tenant1._namespace.yaml": {Kind: KindQuery, Namespace: "tenant1", Name: "_namespace", Ext: ".yaml"},
+		"tenant1.User":            {Kind: KindQuery, Namespace: "tenant1", Name: "User"},
+		"acme/User.gql":           {Kind: KindQuery, Name: "acme/User", Ext: ".gql"},
+		".hidden":                 {Kind: KindQuery, Name: ".hidden"},
+	}
+
+	for fn, exp := range tests {
+		k := storeKey(KindQuery, fn)
+		if k != exp {
+			t.Errorf("%s: expected %v, got %v", fn, exp, k)
+		}
+		if v := k.path(); v != fn {
+			t.Errorf("%s: expected the same filename, got %s", fn, v)
+		}
+	}
+}
+
+func TestFsStore(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	s := NewFsStore(fs, "/queries", "/queries/fragments")
+
+	al, err := NewWithStore(Config{}, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a fragment saved before the .gql extension was added
+	if err := afero.WriteFile(fs, "/queries/fragments/tenant1.User", []byte(`fragment User on users { id }`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := al.SetSync(nil, `query getUser { user { ...User } }`, Metadata{}, "tenant1"); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := s.List(KindQuery)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) != 1 || files[0].Key.path() != "tenant1.getUser.yaml" {
+		t.Fatal("expected only the query file, got ", files)
+	}
+
+	fi, err := fs.Stat("/queries/tenant1.getUser.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !files[0].ModTime.Equal(fi.ModTime()) || files[0].Size != fi.Size() {
+		t.Fatal("expected the modification time and size of the file, got ", files[0])
+	}
+
+	if n, err := al.MigrateFragments(); err != nil || n != 1 {
+		t.Fatal("expected the legacy fragment to be migrated: ", n, err)
+	}
+
+	if ok, _ := afero.Exists(fs, "/queries/fragments/tenant1.User.gql"); !ok {
+		t.Fatal("expected the fragment file with an extension")
+	}
+}
@@ -0,0 +1,91 @@
+package allow
+
+import (
+	"sort"
+	"strings"
+)
+
+// HasTag reports whether the tag is one of the query tags.
+func (md Metadata) HasTag(tag string) bool {
+	for _, v := range md.Tags {
+		if v == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadByTag returns the items with the tag. When the index is enabled and
+// loaded it is used instead of reading the files.
+func (al *List) LoadByTag(tag string) ([]Item, error) {
+	var items []Item
+	tag = strings.TrimSpace(tag)
+
+	if items, ok := al.indexByTag(tag); ok {
+		return items, nil
+	}
+
+	err := al.Range(func(item Item) error {
+		if item.Metadata.HasTag(tag) {
+			items = append(items, item)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// indexByTag returns the indexed items with the tag sorted by namespace
+// and name, false if the index is not loaded
+func (al *List) indexByTag(tag string) ([]Item, bool) {
+	if !al.conf.EnableIndex {
+		return nil, false
+	}
+
+	al.indexMu.RLock()
+	defer al.indexMu.RUnlock()
+
+	if al.index == nil {
+		return nil, false
+	}
+
+	var keys []string
+	for k, v := range al.index {
+		if v.Metadata.HasTag(tag) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	items := make([]Item, 0, len(keys))
+	for _, k := range keys {
+		items = append(items, al.index[k])
+	}
+	return items, true
+}
+
+// normalizeTags trims the tags and drops the empty and repeated ones
+func normalizeTags(tags []string) []string {
+	if len(tags) == 0 {
+		return nil
+	}
+
+	res := make([]string, 0, len(tags))
+	seen := make(map[string]struct{}, len(tags))
+
+	for _, v := range tags {
+		v = strings.TrimSpace(v)
+		if _, ok := seen[v]; ok || v == "" {
+			continue
+		}
+		seen[v] = struct{}{}
+		res = append(res, v)
+	}
+
+	if len(res) == 0 {
+		return nil
+	}
+	return res
+}
@@ -0,0 +1,90 @@
+package allow
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestLoadByTag(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	al, err := New(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queries := map[string][]string{
+		`query getUser { user { id } }`:   {"public", " reporting ", "public"},
+		`query getUsers { users { id } }`: {"internal"},
+		`query getPosts { posts { id } }`: {"public"},
+		`query getTags { tags { id } }`:   nil,
+	}
+
+	for q, tags := range queries {
+		if err := al.SetSync(nil, q, Metadata{Tags: tags}, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b, err := afero.ReadFile(fs, "/queries/getUser.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(b), "tags:\n  - public\n  - reporting\n") {
+		t.Fatal("expected the normalized tags to be saved: ", string(b))
+	}
+
+	items, err := al.LoadByTag("public")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(items) != 2 || items[0].Name != "getPosts" || items[1].Name != "getUser" {
+		t.Fatal("unexpected items: ", items)
+	}
+
+	if items[1].Metadata.HasTag("internal") || !items[1].Metadata.HasTag("reporting") {
+		t.Fatal("unexpected tags: ", items[1].Metadata.Tags)
+	}
+
+	ial, err := New(Config{EnableIndex: true}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ial.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a query saved after the index is loaded
+	if err := ial.SetSync(nil, `query getComments { comments { id } }`,
+		Metadata{Tags: []string{"public"}}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fs.Remove("/queries/getPosts.yaml"); err != nil {
+		t.Fatal(err)
+	}
+
+	items, err = ial.LoadByTag("public")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, v := range items {
+		names = append(names, v.Name)
+	}
+
+	// the index is used so the removed file is still returned
+	if strings.Join(names, ",") != "getComments,getPosts,getUser" {
+		t.Fatal("unexpected items from the index: ", names)
+	}
+
+	if items, err := ial.LoadByTag("none"); err != nil || len(items) != 0 {
+		t.Fatal("expected no items, got ", items, err)
+	}
+}
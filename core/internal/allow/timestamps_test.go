@@ -0,0 +1,106 @@
+package allow
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+func TestSavedTimes(t *testing.T) {
+	for _, format := range []string{FormatYAML, FormatJSON} {
+		fs := afero.NewMemMapFs()
+
+		now := time.Date(2022, 1, 1, 10, 0, 0, 0, time.UTC)
+		clock := func() time.Time { return now }
+
+		al, err := New(Config{Format: format, Now: clock}, fs)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		get := func() Metadata {
+			item, err := al.GetByName("getUser")
+			if err != nil {
+				t.Fatal(err)
+			}
+			return item.Metadata
+		}
+
+		if err := al.SetSync(nil, `query getUser { user { id } }`, Metadata{}, ""); err != nil {
+			t.Fatal(err)
+		}
+
+		created := now
+		if md := get(); !md.CreatedAt.Equal(created) || !md.UpdatedAt.Equal(created) {
+			t.Fatalf("%s: expected the times to be set when saved: %v %v", format, md.CreatedAt, md.UpdatedAt)
+		}
+
+		// saving the same query does not change the times
+		now = now.Add(time.Hour)
+		if err := al.SetSync(nil, `query getUser { user { id } }`, Metadata{}, ""); err != nil {
+			t.Fatal(err)
+		}
+
+		if md := get(); !md.UpdatedAt.Equal(created) {
+			t.Fatalf("%s: expected the unchanged query not to be updated: %v", format, md.UpdatedAt)
+		}
+
+		now = now.Add(time.Hour)
+		err = al.SetSync(nil, `query getUser { user { id email } }`, Metadata{}, "", WithOverwrite())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if md := get(); !md.CreatedAt.Equal(created) || !md.UpdatedAt.Equal(now) {
+			t.Fatalf("%s: expected only the updated time to change: %v %v", format, md.CreatedAt, md.UpdatedAt)
+		}
+
+		now = now.Add(time.Hour)
+		if err := al.Rename("", "getUser", "", "getUser2"); err != nil {
+			t.Fatal(err)
+		}
+
+		item, err := al.GetByName("getUser2")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if md := item.Metadata; !md.CreatedAt.Equal(created) || !md.UpdatedAt.Equal(now) {
+			t.Fatalf("%s: expected rename to update the time: %v %v", format, md.CreatedAt, md.UpdatedAt)
+		}
+	}
+}
+
+func TestSavedTimesSunsetLocation(t *testing.T) {
+	for _, format := range []string{FormatYAML, FormatJSON} {
+		fs := afero.NewMemMapFs()
+
+		now := time.Date(2022, 1, 1, 10, 0, 0, 0, time.UTC)
+		clock := func() time.Time { return now }
+
+		al, err := New(Config{Format: format, Now: clock}, fs)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		loc := time.FixedZone("IST", 5*60*60+30*60)
+		md := Metadata{Deprecated: true, Sunset: time.Date(2023, 6, 1, 12, 0, 0, 0, loc)}
+
+		for i := 0; i < 2; i++ {
+			if err := al.SetSync(nil, `query getUser { user { id } }`, md, ""); err != nil {
+				t.Fatal(err)
+			}
+			now = now.Add(time.Hour)
+		}
+
+		item, err := al.GetByName("getUser")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		created := time.Date(2022, 1, 1, 10, 0, 0, 0, time.UTC)
+		if !item.Metadata.UpdatedAt.Equal(created) {
+			t.Fatalf("%s: expected the unchanged query not to be updated: %v", format, item.Metadata.UpdatedAt)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package allow
+
+import (
+	"sort"
+	"strings"
+)
+
+// FragmentUsage returns the names of the queries using each fragment keyed
+// by the fragment namespace and name joined by a dot (just the name when
+// there is no namespace). The fragments used by other fragments are used
+// by the queries spreading those. Fragments no query uses have an empty
+// list. The fragments saved with a custom FragmentNamer cannot be listed
+// so only those used are returned.
+func (al *List) FragmentUsage() (map[string][]string, error) {
+	items, err := al.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	frags, err := al.allFragments(items)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := make(map[string][]string, len(frags))
+	for _, f := range frags {
+		usage[fileName(f.Namespace, f.Name)] = []string{}
+	}
+
+	for _, item := range items {
+		seen := make(map[string]struct{})
+
+		for _, fn := range fragmentSpreads(item.Query) {
+			names := []string{fn}
+
+			if fl, err := al.resolveFragments(item.Namespace, fn); err == nil {
+				names = names[:0]
+				for _, f := range fl {
+					names = append(names, f.Name)
+				}
+			} else if al.conf.Log != nil {
+				al.conf.Log.Printf("WRN allow list: %s: %s", fileName(item.Namespace, item.Name), err)
+			}
+
+			for _, name := range names {
+				k := fileName(item.Namespace, name)
+
+				// fragments found in _global when scoped
+				if _, ok := usage[k]; !ok && al.conf.ScopedFragments {
+					if _, ok := usage[name]; ok {
+						k = name
+					}
+				}
+
+				if _, ok := seen[k]; ok {
+					continue
+				}
+				seen[k] = struct{}{}
+				usage[k] = append(usage[k], fileName(item.Namespace, item.Name))
+			}
+		}
+	}
+
+	for _, v := range usage {
+		sort.Strings(v)
+	}
+	return usage, nil
+}
+
+// allFragments returns all the saved fragments or for a custom
+// FragmentNamer the fragments used by the items
+func (al *List) allFragments(items []Item) ([]exportFrag, error) {
+	if !al.conf.ScopedFragments {
+		return al.exportFragments(items)
+	}
+
+	var frags []exportFrag
+
+	fi, err := al.listFiles(KindFragment, true)
+	if err != nil {
+		return nil, err
+	}
+
+	// the namespaces with a directory or a library file
+	namespaces := map[string]struct{}{"": {}}
+	for _, f := range fi {
+		dir, _, nested := strings.Cut(f.rel, "/")
+		switch {
+		case nested && dir == globalScope:
+		case nested && validateNamespace(dir) == nil:
+			namespaces[dir] = struct{}{}
+		case !nested && isLibraryFile(f.name()):
+			ns, _ := SplitName(strings.TrimSuffix(f.name(), libraryExt))
+			namespaces[ns] = struct{}{}
+		}
+	}
+
+	for ns := range namespaces {
+		m := make(map[string]string)
+		if err := al.scopedFragments(ns, m); err != nil {
+			return nil, err
+		}
+		for name, v := range m {
+			frags = append(frags, exportFrag{Namespace: ns, Name: name, Value: v})
+		}
+	}
+	return frags, nil
+}
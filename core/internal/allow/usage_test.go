@@ -0,0 +1,88 @@
+package allow
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestFragmentUsage(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	files := map[string]string{
+		"/fragments/admin.User.gql":       `fragment User on users { id ...Name }`,
+		"/fragments/admin.Name.gql":       `fragment Name on users { full_name }`,
+		"/fragments/admin.Orphan.gql":     `fragment Orphan on users { id }`,
+		"/fragments/Post.gql":             `fragment Post on posts { id }`,
+		"/fragments/admin.lib.graphqls":   `fragment Email on users { email }`,
+		"/queries/admin.GetUser.gql":      `query GetUser { user { ...User ...Name } }`,
+		"/queries/admin.GetUserName.gql":  `query GetUserName { user { ...Name } }`,
+		"/queries/admin.GetUserEmail.gql": `query GetUserEmail { user { ...Email } }`,
+		"/queries/GetPosts.gql":           `query GetPosts { posts { ...Post } }`,
+		"/queries/GetTags.gql":            `query GetTags { tags { id } }`,
+	}
+	for fn, v := range files {
+		if err := afero.WriteFile(fs, fn, []byte(v), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	al, err := New(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	usage, err := al.FragmentUsage()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := map[string][]string{
+		"admin.User":   {"admin.GetUser"},
+		"admin.Name":   {"admin.GetUser", "admin.GetUserName"},
+		"admin.Orphan": {},
+		"admin.Email":  {"admin.GetUserEmail"},
+		"Post":         {"GetPosts"},
+	}
+	if !reflect.DeepEqual(usage, exp) {
+		t.Fatalf("expected %v, got %v", exp, usage)
+	}
+}
+
+func TestScopedFragmentUsage(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	files := map[string]string{
+		"/fragments/_global/Name.gql":  `fragment Name on users { full_name }`,
+		"/fragments/acme/User.gql":     `fragment User on users { id ...Name }`,
+		"/fragments/acme/Orphan.gql":   `fragment Orphan on users { id }`,
+		"/fragments/beta.lib.graphqls": `fragment Email on users { email }`,
+		"/queries/acme.GetUser.gql":    `query GetUser { user { ...User } }`,
+	}
+	for fn, v := range files {
+		if err := afero.WriteFile(fs, fn, []byte(v), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	al, err := New(Config{ScopedFragments: true}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	usage, err := al.FragmentUsage()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := map[string][]string{
+		"Name":        {"acme.GetUser"},
+		"acme.User":   {"acme.GetUser"},
+		"acme.Orphan": {},
+		"beta.Email":  {},
+	}
+	if !reflect.DeepEqual(usage, exp) {
+		t.Fatalf("expected %v, got %v", exp, usage)
+	}
+}
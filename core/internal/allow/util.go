@@ -1,7 +1,15 @@
 package allow
 
 import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"regexp"
 	"strings"
+	"text/scanner"
+	"unicode"
+
+	"github.com/spf13/afero"
 )
 
 func fragmentName(b string) string {
@@ -29,8 +37,185 @@ func isValidNameChar(c byte) bool {
 	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '_'
 }
 
+// validateNamespace returns ErrInvalidNamespace if the namespace has
+// path separators, dots or control characters since it is used in
+// filenames and is split from the name at the last dot.
+func validateNamespace(namespace string) error {
+	for _, c := range namespace {
+		if c == '/' || c == '\\' || c == '.' || unicode.IsControl(c) {
+			return fmt.Errorf("%w: %q", ErrInvalidNamespace, namespace)
+		}
+	}
+	return nil
+}
+
+// validateName returns ErrInvalidName if the query or fragment name is
+// not a valid GraphQL name.
+func validateName(name string) error {
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		return fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+	for i := 0; i < len(name); i++ {
+		if !isValidNameChar(name[i]) {
+			return fmt.Errorf("%w: %q", ErrInvalidName, name)
+		}
+	}
+	return nil
+}
+
+func validateNames(namespace, name string) error {
+	if err := validateNamespace(namespace); err != nil {
+		return err
+	}
+	return validateName(name)
+}
+
 func isGraphQL(s string) bool {
 	return strings.HasPrefix(s, "query") ||
 		strings.HasPrefix(s, "mutation") ||
 		strings.HasPrefix(s, "subscription")
 }
+
+// fragmentSpreads returns the names of the fragments spread (...name)
+// in the query. Inline fragments (... on Type) are skipped.
+func fragmentSpreads(b string) []string {
+	var names []string
+	seen := make(map[string]struct{})
+	bl := len(b)
+
+	for i := 0; i < bl-2; i++ {
+		if b[i] != '.' || b[i+1] != '.' || b[i+2] != '.' {
+			continue
+		}
+		i += 3
+
+		for i < bl && (b[i] == ' ' || b[i] == '\t' || b[i] == '\n' || b[i] == '\r') {
+			i++
+		}
+
+		s := i
+		for i < bl && isValidNameChar(b[i]) {
+			i++
+		}
+
+		n := b[s:i]
+		if n == "" || n == "on" {
+			continue
+		}
+		if _, ok := seen[n]; ok {
+			continue
+		}
+		seen[n] = struct{}{}
+		names = append(names, n)
+	}
+
+	return names
+}
+
+// leadingComment returns the text of the block comment (/* ... */) or
+// the run of line comments (# ...) at the start of b and the text
+// following it.
+func leadingComment(b string) (string, string) {
+	v := strings.TrimSpace(b)
+	if strings.HasPrefix(v, "#") {
+		return lineComments(v)
+	}
+	if !strings.HasPrefix(v, "/*") {
+		return "", b
+	}
+
+	i := strings.Index(v[2:], "*/")
+	if i == -1 {
+		return "", b
+	}
+	return strings.TrimSpace(v[2:(i + 2)]), v[(i + 4):]
+}
+
+// lineComments returns the text of the line comments at the start of b
+// with the # trimmed and the text following them.
+func lineComments(b string) (string, string) {
+	var lines []string
+
+	for strings.HasPrefix(b, "#") {
+		i := strings.IndexByte(b, '\n')
+		if i == -1 {
+			i = len(b)
+		}
+		lines = append(lines, strings.TrimSpace(b[1:i]))
+		b = strings.TrimLeft(b[i:], " \t\r\n")
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n")), b
+}
+
+// compactQuery returns the query with the whitespace that does not
+// separate names, numbers or strings removed.
+func compactQuery(query string) string {
+	var s scanner.Scanner
+	var sb strings.Builder
+
+	s.Init(strings.NewReader(query))
+	s.Mode = scanner.ScanIdents | scanner.ScanInts | scanner.ScanFloats | scanner.ScanStrings
+	s.Error = func(*scanner.Scanner, string) {}
+
+	prev := rune(0)
+	for tok := s.Scan(); tok != scanner.EOF; tok = s.Scan() {
+		if isWordToken(prev) && isWordToken(tok) {
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(s.TokenText())
+		prev = tok
+	}
+	return sb.String()
+}
+
+func isWordToken(tok rune) bool {
+	return tok == scanner.Ident || tok == scanner.Int || tok == scanner.Float
+}
+
+// writeFile writes the data to a temporary file in the same directory
+// and renames it to the filename so readers never see a partly written
+// file. If the filesystem cannot create the temporary file or rename it
+// the file is written directly. Errors writing the temporary file are
+// returned without touching the existing file.
+func writeFile(fs afero.Fs, fn string, data []byte) error {
+	dir, base := filepath.Split(fn)
+
+	f, err := afero.TempFile(fs, dir, "."+base+".tmp")
+	if err != nil {
+		return afero.WriteFile(fs, fn, data, 0600)
+	}
+	tmp := f.Name()
+
+	_, err = f.Write(data)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		_ = fs.Remove(tmp)
+		return err
+	}
+
+	if err := fs.Rename(tmp, fn); err != nil {
+		_ = fs.Remove(tmp)
+		return afero.WriteFile(fs, fn, data, 0600)
+	}
+	return nil
+}
+
+// tempFileRe matches the .<base>.tmp<random> names of the temporary files
+// created by writeFile
+var tempFileRe = regexp.MustCompile(`^\..+\.tmp[0-9]*$`)
+
+// isTempFile reports whether the file is a temporary file left by writeFile
+func isTempFile(fn string) bool {
+	return tempFileRe.MatchString(filepath.Base(fn))
+}
+
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// normalizeText strips a leading UTF-8 byte order mark and converts CRLF
+// line endings to LF as written by some editors and tools on Windows
+func normalizeText(b []byte) []byte {
+	b = bytes.TrimPrefix(b, utf8BOM)
+	return bytes.ReplaceAll(b, []byte("\r\n"), []byte("\n"))
+}
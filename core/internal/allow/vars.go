@@ -0,0 +1,60 @@
+package allow
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/chirino/graphql/schema"
+)
+
+// checkDeclaredVars returns ErrUnknownVariable if the query declares its
+// variables and the item has variables not declared. Required variables
+// with no default value that the item does not set are logged. Queries
+// that do not declare variables are not checked.
+func (al *List) checkDeclaredVars(item Item) error {
+	qd := &schema.QueryDocument{}
+	if err := qd.Parse(item.Query); err != nil || len(qd.Operations) == 0 {
+		return nil
+	}
+
+	decl := qd.Operations[0].Vars
+	if len(decl) == 0 {
+		return nil
+	}
+
+	var vars map[string]json.RawMessage
+	if item.Vars != "" {
+		if err := json.Unmarshal([]byte(item.Vars), &vars); err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidVars, err)
+		}
+	}
+
+	declared := make(map[string]struct{}, len(decl))
+	for _, v := range decl {
+		name := strings.TrimPrefix(v.Name, "$")
+		declared[name] = struct{}{}
+
+		if _, ok := vars[name]; ok || v.Default != nil || al.conf.Log == nil {
+			continue
+		}
+		if _, ok := v.Type.(*schema.NonNull); ok {
+			al.conf.Log.Printf("WRN allow list: query '%s': required variable '$%s' is not set",
+				item.Name, name)
+		}
+	}
+
+	var unknown []string
+	for k := range vars {
+		if _, ok := declared[k]; !ok {
+			unknown = append(unknown, k)
+		}
+	}
+
+	if len(unknown) != 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("%w: query '%s': %v", ErrUnknownVariable, item.Name, unknown)
+	}
+	return nil
+}
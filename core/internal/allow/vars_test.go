@@ -0,0 +1,65 @@
+package allow
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestDeclaredVars(t *testing.T) {
+	var buf bytes.Buffer
+
+	al, err := New(Config{Log: log.New(&buf, "", 0)}, afero.NewMemMapFs())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		vars  string
+		query string
+		ok    bool
+	}{
+		{`{"id": 1}`, `query getUser($id: ID!) { user(id: $id) { id } }`, true},
+		{`{"userId": 1}`, `query getUser($id: ID!) { user(id: $id) { id } }`, false},
+		{`{"id": 1, "limit": 5}`, `query getUser($id: ID!) { user(id: $id) { id } }`, false},
+		{`{"id": 1, "limit": 5}`, `query getUser($id: ID!, $limit: Int) { user(id: $id) { id } }`, true},
+		// queries not declaring variables are not checked
+		{`{"userId": 1}`, `query getUser { user(id: $userId) { id } }`, true},
+		{``, `query getUser($id: ID) { user(id: $id) { id } }`, true},
+	}
+
+	for _, v := range tests {
+		var vars []byte
+		if v.vars != "" {
+			vars = []byte(v.vars)
+		}
+
+		_, err := al.Validate(vars, v.query, Metadata{}, "")
+		if v.ok && err != nil {
+			t.Errorf("%s: %s: %v", v.vars, v.query, err)
+		}
+		if !v.ok && !errors.Is(err, ErrUnknownVariable) {
+			t.Errorf("%s: %s: expected ErrUnknownVariable, got %v", v.vars, v.query, err)
+		}
+	}
+
+	err = al.SetSync([]byte(`{"userId": 1}`), `query getUser($id: ID!) { user(id: $id) { id } }`, Metadata{}, "")
+	if !errors.Is(err, ErrUnknownVariable) || !strings.Contains(err.Error(), "userId") {
+		t.Fatal("expected ErrUnknownVariable naming the variable, got ", err)
+	}
+
+	buf.Reset()
+	q := `query getUsers($limit: Int!, $offset: Int! = 0, $order: String) { users(limit: $limit) { id } }`
+	if _, err := al.Validate(nil, q, Metadata{}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(buf.String(), "'$limit' is not set") || strings.Contains(buf.String(), "offset") ||
+		strings.Contains(buf.String(), "order") {
+		t.Fatalf("expected only the required variable with no default to be logged, got %q", buf.String())
+	}
+}
@@ -0,0 +1,307 @@
+package allow
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/afero"
+)
+
+var errWatchUnsupported = errors.New("filesystem events not supported")
+
+const (
+	watchDebounce = 200 * time.Millisecond
+	watchPoll     = time.Second
+)
+
+// Watch emits the items from query files that are created or changed and
+// the items using fragments that are created or changed. The items in
+// query files that are removed are dropped from the index and cache.
+// Filesystem events are used when the allow list is on the OS filesystem
+// else the directories are polled for changes. The channel is closed when
+// the context is done.
+func (al *List) Watch(ctx context.Context) (<-chan Item, error) {
+	dirs := []string{al.conf.QueryDir, al.conf.FragmentDir}
+	ch := make(chan Item)
+
+	if w, dm, err := al.newWatcher(dirs); err == nil {
+		go al.watchEvents(ctx, w, dm, ch)
+	} else {
+		go al.watchPoll(ctx, ch)
+	}
+
+	return ch, nil
+}
+
+// newWatcher returns an fsnotify watcher for the directories when the
+// allow list is backed by the OS filesystem along with a map of the
+// OS path of each directory to its path in the allow list filesystem.
+func (al *List) newWatcher(dirs []string) (*fsnotify.Watcher, map[string]string, error) {
+	var realPath func(string) (string, error)
+
+	switch v := al.fsys().(type) {
+	case *afero.OsFs:
+		realPath = func(p string) (string, error) { return p, nil }
+	case *afero.BasePathFs:
+		realPath = v.RealPath
+	default:
+		return nil, nil, errWatchUnsupported
+	}
+
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dm := make(map[string]string, len(dirs))
+	for _, d := range dirs {
+		rp, err := realPath(d)
+		if err == nil {
+			err = w.Add(rp)
+		}
+		if err != nil {
+			w.Close() //nolint:errcheck
+			return nil, nil, err
+		}
+		dm[filepath.Clean(rp)] = d
+	}
+	return w, dm, nil
+}
+
+func (al *List) watchEvents(ctx context.Context, w *fsnotify.Watcher, dm map[string]string, ch chan Item) {
+	defer close(ch)
+	defer w.Close() //nolint:errcheck
+
+	changed := make(map[string]struct{})
+	timer := time.NewTimer(watchDebounce)
+	timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+
+		case err, ok := <-w.Errors:
+			if !ok {
+				return
+			}
+			if al.conf.Log != nil {
+				al.conf.Log.Println("WRN allow list watch:", err)
+			}
+
+		case ev, ok := <-w.Events:
+			if !ok {
+				return
+			}
+			if ev.Op&(fsnotify.Create|fsnotify.Write|fsnotify.Rename|fsnotify.Remove) == 0 {
+				continue
+			}
+			if d, ok := dm[filepath.Dir(ev.Name)]; ok {
+				changed[filepath.Join(d, filepath.Base(ev.Name))] = struct{}{}
+				timer.Reset(watchDebounce)
+			}
+
+		case <-timer.C:
+			if !al.emitChanged(ctx, changed, ch) {
+				return
+			}
+			changed = make(map[string]struct{})
+		}
+	}
+}
+
+func (al *List) watchPoll(ctx context.Context, ch chan Item) {
+	defer close(ch)
+
+	ticker := time.NewTicker(watchPoll)
+	defer ticker.Stop()
+
+	modTimes := al.modTimes()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-ticker.C:
+			changed := make(map[string]struct{})
+			mt := al.modTimes()
+
+			for fn, f := range mt {
+				if f1, ok := modTimes[fn]; !ok || !f1.ModTime.Equal(f.ModTime) || f1.Size != f.Size {
+					changed[fn] = struct{}{}
+				}
+			}
+			for fn := range modTimes {
+				if _, ok := mt[fn]; !ok {
+					changed[fn] = struct{}{}
+				}
+			}
+			modTimes = mt
+
+			if !al.emitChanged(ctx, changed, ch) {
+				return
+			}
+		}
+	}
+}
+
+// modTimes returns the query and fragment files by path, those changed
+// since the last poll have a different modification time or size
+func (al *List) modTimes() map[string]StoreFile {
+	mt := make(map[string]StoreFile)
+
+	for _, kind := range []string{KindQuery, KindFragment} {
+		fi, err := al.listFiles(kind, false)
+		if err != nil {
+			continue
+		}
+		for _, f := range fi {
+			mt[f.path] = f.StoreFile
+		}
+	}
+	return mt
+}
+
+// emitChanged sends the items affected by the changed files on the channel.
+// It returns false if the context is done.
+func (al *List) emitChanged(ctx context.Context, changed map[string]struct{}, ch chan Item) bool {
+	var items []Item
+	var defs bool
+	frags := make(map[string]struct{})
+	namespaces := make(map[string]struct{})
+
+	for fn := range changed {
+		if isTempFile(fn) {
+			continue
+		}
+		if filepath.Dir(fn) == al.conf.FragmentDir && isLibraryFile(fn) {
+			al.resetLibrary()
+			ns, lf, err := al.readLibraryFile(fn)
+			if err != nil {
+				if al.conf.Log != nil {
+					al.conf.Log.Println("WRN allow list watch:", err)
+				}
+				continue
+			}
+			for _, v := range lf {
+				frags[fileName(ns, v.Name)] = struct{}{}
+			}
+			continue
+		}
+
+		if filepath.Dir(fn) == al.conf.FragmentDir {
+			frags[fragmentStem(fn)] = struct{}{}
+			continue
+		}
+
+		// the defaults file is not a query, the items using the
+		// shared variables in it are emitted instead
+		if isDefaultsFile(fn) {
+			defs = true
+			continue
+		}
+
+		// nor are namespace files, the items in the namespace are emitted
+		if isNamespaceFile(fn) {
+			namespaces[strings.TrimSuffix(filepath.Base(fn), NamespaceFile)] = struct{}{}
+			continue
+		}
+
+		if !isQueryFile(fn) {
+			continue
+		}
+
+		if ok, err := al.exists(fn); err == nil && !ok {
+			if fn = al.removedFile(fn); fn == "" {
+				continue
+			}
+		}
+
+		v, err := al.Get(fn)
+		if err != nil {
+			if al.conf.Log != nil {
+				al.conf.Log.Println("WRN allow list watch:", err)
+			}
+			continue
+		}
+		items = append(items, v...)
+	}
+
+	if len(frags) != 0 || len(namespaces) != 0 || defs {
+		list, err := al.Load()
+		if err != nil && al.conf.Log != nil {
+			al.conf.Log.Println("WRN allow list watch:", err)
+		}
+
+		for _, v := range list {
+			_, inNamespace := namespaces[v.Namespace]
+			if inNamespace || (defs && v.VarsRef != "") || usesFragment(v, frags) {
+				items = append(items, v)
+			}
+		}
+	}
+
+	for _, v := range items {
+		al.updateIndex(v, false)
+
+		select {
+		case ch <- v:
+		case <-ctx.Done():
+			return false
+		}
+	}
+	return true
+}
+
+// removedFile drops the items read from the removed query file from the
+// index and cache. It returns the file now saving the query if any, as
+// when the query is saved in another format.
+func (al *List) removedFile(fn string) string {
+	stem := fileStem(fn)
+
+	if v, err := al.findFile(stem); err == nil && v != "" {
+		return v
+	}
+
+	ns, name := SplitName(stem)
+	items := []Item{{Namespace: ns, Name: name}}
+
+	// the other operations in .gql files with multiple operations
+	al.indexMu.RLock()
+	for _, v := range al.index {
+		if v.source == fn {
+			items = append(items, v)
+		}
+	}
+	al.indexMu.RUnlock()
+
+	if al.cache != nil {
+		al.cacheMu.Lock()
+		for _, k := range al.cache.Keys() {
+			if v, ok := al.cache.Peek(k); ok && v.(Item).source == fn {
+				items = append(items, v.(Item))
+			}
+		}
+		al.cacheMu.Unlock()
+	}
+
+	for _, v := range items {
+		al.updateIndex(v, true)
+	}
+	return ""
+}
+
+func usesFragment(item Item, frags map[string]struct{}) bool {
+	for _, f := range fragmentSpreads(item.Query) {
+		if _, ok := frags[fileName(item.Namespace, f)]; ok {
+			return true
+		}
+	}
+	return false
+}
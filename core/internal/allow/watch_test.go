@@ -0,0 +1,191 @@
+package allow
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+func TestWatch(t *testing.T) {
+	fs := afero.NewBasePathFs(afero.NewOsFs(), t.TempDir())
+	testWatch(t, fs)
+}
+
+func TestWatchPoll(t *testing.T) {
+	testWatch(t, afero.NewMemMapFs())
+}
+
+func testWatch(t *testing.T, fs afero.Fs) {
+	al, err := New(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch, err := al.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// let the poller take its first snapshot
+	time.Sleep(100 * time.Millisecond)
+
+	q := []byte(`query getUser { user { id } }`)
+	for i := 0; i < 3; i++ {
+		if err := afero.WriteFile(fs, "/queries/getUser.gql", q, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	select {
+	case item := <-ch:
+		if item.Name != "getUser" {
+			t.Fatal("unexpected item: ", item.Name)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the changed item")
+	}
+
+	select {
+	case item := <-ch:
+		t.Fatal("expected the writes to be debounced, got: ", item.Name)
+	case <-time.After(watchDebounce * 2):
+	}
+
+	cancel()
+
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Fatal("expected the channel to be closed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the channel to close")
+	}
+}
+
+func TestWatchRemove(t *testing.T) {
+	t.Run("events", func(t *testing.T) {
+		testWatchRemove(t, afero.NewBasePathFs(afero.NewOsFs(), t.TempDir()))
+	})
+	t.Run("poll", func(t *testing.T) {
+		testWatchRemove(t, afero.NewMemMapFs())
+	})
+}
+
+func testWatchRemove(t *testing.T, fs afero.Fs) {
+	if err := fs.MkdirAll("/queries", 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, fn := range []string{"/queries/getUser.gql", "/queries/getUsers.gql"} {
+		q := "query " + fileStem(fn) + " { user { id } }"
+		if err := afero.WriteFile(fs, fn, []byte(q), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, conf := range []Config{{EnableIndex: true}, {CacheSize: 10}} {
+		al, err := New(conf, fs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := al.Load(); err != nil {
+			t.Fatal(err)
+		}
+		if item, err := al.GetByName("getUser"); err != nil || item.Name != "getUser" {
+			t.Fatal("expected the query: ", err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		if _, err := al.Watch(ctx); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(100 * time.Millisecond)
+
+		if err := fs.Remove("/queries/getUser.gql"); err != nil {
+			t.Fatal(err)
+		}
+
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			item, err := al.GetByName("getUser")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if item.Query == "" {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("timed out waiting for the removed query to be dropped")
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+
+		if item, err := al.GetByName("getUsers"); err != nil || item.Name != "getUsers" {
+			t.Fatal("expected the other query to be kept: ", err)
+		}
+		cancel()
+
+		if err := afero.WriteFile(fs, "/queries/getUser.gql", []byte("query getUser { user { id } }"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestWatchDefaults(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	files := map[string]string{
+		"/queries/_defaults.yaml": "pagination:\n  limit: 10\n",
+		"/queries/getUsers.yaml":  "name: getUsers\nquery: \"query getUsers { users(limit: $limit) { id } }\"\nvars_ref: pagination\n",
+		"/queries/getUser.yaml":   "name: getUser\nquery: \"query getUser { user { id } }\"\n",
+	}
+	for fn, v := range files {
+		if err := afero.WriteFile(fs, fn, []byte(v), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	al, err := NewReadOnly(Config{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch, err := al.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// let the poller take its first snapshot
+	time.Sleep(100 * time.Millisecond)
+
+	err = afero.WriteFile(fs, "/queries/_defaults.yaml", []byte("pagination:\n  limit: 20\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case item := <-ch:
+		if item.Name != "getUsers" || !strings.Contains(item.Vars, `"limit": 20`) {
+			t.Fatal("unexpected item: ", item.Name, item.Vars)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the item using the defaults")
+	}
+
+	select {
+	case item := <-ch:
+		t.Fatal("expected only the items using the defaults, got: ", item.Name)
+	case <-time.After(watchPoll * 2):
+	}
+}
@@ -313,6 +313,8 @@ func ParseArgValue(argVal string, json bool) (*Node, error) {
 	return p.parseValue()
 }
 
+// ParseFragment parses the fragment definitions in the string and returns
+// the last one. Fragments it depends on can be defined before it.
 func ParseFragment(fragment string, fetchFrag func(name string) (string, error)) (
 	Fragment, error) {
 	var f Fragment
@@ -330,9 +332,11 @@ func ParseFragment(fragment string, fetchFrag func(name string) (string, error))
 		items:     l.items,
 	}
 
-	if p.peekVal(fragmentToken) {
+	for p.peekVal(fragmentToken) {
 		p.ignore()
-		f, err = p.parseFragment()
+		if f, err = p.parseFragment(); err != nil {
+			return f, err
+		}
 	}
 	return f, err
 }
@@ -116,3 +116,29 @@ func BenchmarkSchemaParseP(b *testing.B) {
 		}
 	})
 }
+
+func TestParseFragmentWithDeps(t *testing.T) {
+	frags := `
+	fragment userName on user {
+		first_name
+		last_name
+	}
+
+	fragment userFields on user {
+		id
+		...userName
+	}`
+
+	f, err := ParseFragment(frags, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if f.Name != "userFields" {
+		t.Fatal("expected the last fragment, got ", f.Name)
+	}
+
+	if len(f.Fields) != 3 {
+		t.Fatal("expected the fields from the dependent fragment, got ", len(f.Fields))
+	}
+}
@@ -71,10 +71,18 @@ func (gj *graphjin) prepareRoleStmt() error {
 func (gj *graphjin) initAllowList() error {
 	var err error
 
+	// allow lists that loaded before the stricter checks still load, the
+	// invalid files are skipped and the last duplicate is kept with warnings
+	conf := allow.Config{
+		Log:         gj.log,
+		SkipInvalid: true,
+		OnDuplicate: allow.DuplicateLastWins,
+	}
+
 	if gj.conf.DisableAllowList {
-		gj.allowList, err = allow.NewReadOnly(gj.fs)
+		gj.allowList, err = allow.NewReadOnly(conf, gj.fs)
 	} else {
-		gj.allowList, err = allow.New(allow.Config{Log: gj.log}, gj.fs)
+		gj.allowList, err = allow.New(conf, gj.fs)
 	}
 
 	if err != nil {
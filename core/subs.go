@@ -15,6 +15,7 @@ import (
 	"time"
 
 	"github.com/avast/retry-go"
+	"github.com/dosco/graphjin/core/internal/allow"
 	"github.com/dosco/graphjin/core/internal/graph"
 	"github.com/dosco/graphjin/core/internal/qcode"
 	"github.com/rs/xid"
@@ -196,9 +197,12 @@ func (gj *graphjin) newSub(c context.Context,
 			nil,
 			query,
 			s.qc.st.qc.Metadata,
-			qr.ns)
+			qr.ns,
+			allow.WithOverwrite())
 
-		if err != nil {
+		if errors.Is(err, allow.ErrQueueFull) {
+			gj.log.Printf("WRN allow list: %s", err)
+		} else if err != nil {
 			return err
 		}
 	}